@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// combinedLogFormat is the default access log format, the combined log format plus the request latency
+const combinedLogFormat = `{remote} - - [{time}] "{request}" {status} {bytes} "{header:Referer}" "{header:User-Agent}" {latency_ms}ms`
+
+// commonLogFormat is the Common Log Format, used with --log-format common
+const commonLogFormat = `{remote} - - [{time}] "{request}" {status} {bytes}`
+
+// AccessRecord describes a request the server has answered
+type AccessRecord struct {
+	Remote   string
+	Method   string
+	Target   string
+	Path     string
+	Proto    string
+	Status   int
+	Bytes    int64
+	Started  time.Time
+	Duration time.Duration
+	// TLS describes the connection's TLS, nil for plaintext requests
+	TLS *tlsDetails
+
+	// head holds the request's header fields for the {header:Name} placeholders
+	head *Request
+}
+
+// Header returns the request header field name, or "" when the request didn't send it
+func (r *AccessRecord) Header(name string) string {
+	return r.head.Header.Get(name)
+}
+
+// tls returns the TLS details of the record, empty ones for plaintext requests
+func (r *AccessRecord) tls() *tlsDetails {
+	if r.TLS == nil {
+		return &tlsDetails{}
+	}
+	return r.TLS
+}
+
+// AccessLogSink receives the record of every request the server has answered. Records are logged from
+// the goroutines serving connections, so a sink must be safe for concurrent use.
+type AccessLogSink interface {
+	LogAccess(record *AccessRecord)
+}
+
+// accessLog is the sink of the access log, nil when no access log is kept
+var accessLog AccessLogSink
+
+// lineSink is an access log sink writing every record formatted as one line
+type lineSink struct {
+	format func(record *AccessRecord) string
+	write  func(line string)
+}
+
+// LogAccess implements AccessLogSink
+func (s *lineSink) LogAccess(record *AccessRecord) {
+	s.write(s.format(record))
+}
+
+// newLineSink returns a sink writing lines in the --log-format style, text lines follow template
+func newLineSink(style string, template string, write func(line string)) (AccessLogSink, error) {
+	switch style {
+	case "json":
+		return &lineSink{format: formatJSONRecord, write: write}, nil
+	case "common":
+		template = commonLogFormat
+	case "text":
+	default:
+		return nil, fmt.Errorf("unknown log format %q", style)
+	}
+
+	segments, err := compileLogFormat(template)
+	if err != nil {
+		return nil, err
+	}
+	return &lineSink{
+		format: func(record *AccessRecord) string {
+			var b strings.Builder
+			for _, segment := range segments {
+				segment(&b, record)
+			}
+			return b.String()
+		},
+		write: write,
+	}, nil
+}
+
+// formatJSONRecord formats the record as a JSON object on one line
+func formatJSONRecord(record *AccessRecord) string {
+	line, _ := json.Marshal(struct {
+		Time       string  `json:"time"`
+		Remote     string  `json:"remote"`
+		Method     string  `json:"method"`
+		Path       string  `json:"path"`
+		Query      string  `json:"query,omitempty"`
+		Proto      string  `json:"protocol"`
+		Status     int     `json:"status"`
+		Bytes      int64   `json:"bytes"`
+		DurationMS float64 `json:"duration_ms"`
+		Referer    string  `json:"referer,omitempty"`
+		UserAgent  string  `json:"user_agent,omitempty"`
+		TLSVersion string  `json:"tls_version,omitempty"`
+		TLSCipher  string  `json:"tls_cipher,omitempty"`
+		SNI        string  `json:"sni,omitempty"`
+	}{
+		Time:       record.Started.Format(time.RFC3339Nano),
+		Remote:     record.Remote,
+		Method:     record.Method,
+		Path:       record.Path,
+		Query:      strings.TrimPrefix(strings.TrimPrefix(record.Target, record.Path), "?"),
+		Proto:      record.Proto,
+		Status:     record.Status,
+		Bytes:      record.Bytes,
+		DurationMS: float64(record.Duration.Microseconds()) / 1000,
+		Referer:    record.Header("Referer"),
+		UserAgent:  record.Header("User-Agent"),
+		TLSVersion: record.tls().Version,
+		TLSCipher:  record.tls().Cipher,
+		SNI:        record.tls().ServerName,
+	})
+	return string(line)
+}
+
+// logSegment renders part of an access log line, either literal text or a placeholder
+type logSegment func(b *strings.Builder, record *AccessRecord)
+
+// logPlaceholders renders the placeholders an access log format may use, {header:Name} is handled separately
+var logPlaceholders = map[string]logSegment{
+	"remote": func(b *strings.Builder, r *AccessRecord) { b.WriteString(r.Remote) },
+	"time": func(b *strings.Builder, r *AccessRecord) {
+		b.WriteString(r.Started.Format("02/Jan/2006:15:04:05 -0700"))
+	},
+	"time_iso8601": func(b *strings.Builder, r *AccessRecord) {
+		b.WriteString(r.Started.Format(time.RFC3339))
+	},
+	"request": func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Method+" "+r.Target+" "+r.Proto) },
+	"method":  func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Method) },
+	"target":  func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Target) },
+	"path":    func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Path) },
+	"query": func(b *strings.Builder, r *AccessRecord) {
+		_, query, _ := strings.Cut(r.Target, "?")
+		writeLogValue(b, query)
+	},
+	"protocol": func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Proto) },
+	"status": func(b *strings.Builder, r *AccessRecord) {
+		if r.Status == 0 {
+			b.WriteByte('-')
+			return
+		}
+		b.WriteString(strconv.Itoa(r.Status))
+	},
+	"bytes": func(b *strings.Builder, r *AccessRecord) { b.WriteString(strconv.FormatInt(r.Bytes, 10)) },
+	"latency_ms": func(b *strings.Builder, r *AccessRecord) {
+		b.WriteString(strconv.FormatFloat(float64(r.Duration.Microseconds())/1000, 'f', 3, 64))
+	},
+	"latency_us": func(b *strings.Builder, r *AccessRecord) {
+		b.WriteString(strconv.FormatInt(r.Duration.Microseconds(), 10))
+	},
+	"tls_version":    func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.tls().Version) },
+	"tls_cipher":     func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.tls().Cipher) },
+	"sni":            func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.tls().ServerName) },
+	"client_subject": func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.tls().ClientSubject) },
+}
+
+// compileLogFormat parses an access log format like nginx's log_format, with {name} placeholders
+// instead of $name variables. A literal brace is written as {{ or }}.
+func compileLogFormat(format string) ([]logSegment, error) {
+	var segments []logSegment
+	var literal strings.Builder
+
+	flush := func() {
+		if literal.Len() > 0 {
+			text := literal.String()
+			segments = append(segments, func(b *strings.Builder, _ *AccessRecord) { b.WriteString(text) })
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		switch {
+		case strings.HasPrefix(format[i:], "{{"), strings.HasPrefix(format[i:], "}}"):
+			literal.WriteByte(format[i])
+			i++
+		case format[i] == '{':
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated placeholder at offset %d", i)
+			}
+			name := format[i+1 : i+end]
+			i += end
+
+			flush()
+			if header, ok := strings.CutPrefix(name, "header:"); ok && header != "" {
+				segments = append(segments, func(b *strings.Builder, r *AccessRecord) { writeLogValue(b, r.Header(header)) })
+				continue
+			}
+			segment, ok := logPlaceholders[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown placeholder {%s}", name)
+			}
+			segments = append(segments, segment)
+		default:
+			literal.WriteByte(format[i])
+		}
+	}
+	flush()
+
+	return segments, nil
+}
+
+// writeLogValue writes a value taken from the request, escaping quotes and control characters so a client
+// can't forge log lines, and using "-" for empty values
+func writeLogValue(b *strings.Builder, value string) {
+	if value == "" {
+		b.WriteByte('-')
+		return
+	}
+	quoted := strconv.Quote(strings.ToValidUTF8(value, "?"))
+	b.WriteString(quoted[1 : len(quoted)-1])
+}
+
+// logAccess will hand the record of a finished request to the access log
+func logAccess(client string, head *Request, info httpserver.ResponseInfo) {
+	if accessLog == nil {
+		return
+	}
+
+	path, _, _ := strings.Cut(head.Target, "?")
+	accessLog.LogAccess(&AccessRecord{
+		Remote:   client,
+		Method:   head.Method,
+		Target:   head.Target,
+		Path:     path,
+		Proto:    head.Proto,
+		Status:   info.Status,
+		Bytes:    info.Bytes,
+		Started:  info.Started,
+		Duration: time.Since(info.Started),
+		TLS:      requestTLS(head),
+		head:     head,
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/safepath"
+)
+
+var (
+	// activeConnections, acceptedConnections, rejectedConnections, and handledRequests are reported by
+	// the stats endpoint
+	activeConnections   atomic.Int64
+	acceptedConnections atomic.Int64
+	rejectedConnections atomic.Int64
+	handledRequests     atomic.Int64
+
+	// handledConnections, readingConnections, and writingConnections are reported by the stub status page
+	handledConnections atomic.Int64
+	readingConnections atomic.Int64
+	writingConnections atomic.Int64
+
+	// draining is set once the public listener has been closed by drain
+	draining atomic.Bool
+
+	// publicListeners are the listeners drain closes
+	publicListeners []net.Listener
+
+	// connections tracks the public connections in flight so the server can wait for them when draining
+	connections sync.WaitGroup
+)
+
+// drain stops accepting public connections and closes the idle ones, main returns once the ones in
+// flight are done or have been closed after --shutdown-grace
+func drain() {
+	if draining.CompareAndSwap(false, true) {
+		if publicServer != nil {
+			publicServer.SetKeepAlivesEnabled(false)
+		}
+		for _, l := range publicListeners {
+			l.Close()
+		}
+		closeConnections(true)
+		forceCloseAfterGrace()
+	}
+}
+
+// handleAdminRequest will handle requests for the admin API on the public port, which requires the
+// --admin-token bearer token and is disabled altogether once --admin-socket moves the API off that port
+func handleAdminRequest(writer *bufio.Writer, method string, head *Request, path string, query url.Values, actor string) error {
+	if *adminTokenFlag == "" || *adminSocketFlag != "" {
+		return ErrNotFound
+	}
+
+	if err := authorizeAdmin(head); err != nil {
+		return err
+	}
+
+	return routeAdminRequest(writer, method, path, query, actor)
+}
+
+// authorizeAdmin checks the request's bearer token against --admin-token, returning a 401 when it doesn't match
+func authorizeAdmin(head *Request) error {
+	token, ok := strings.CutPrefix(head.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(*adminTokenFlag)) != 1 {
+		return ErrUnauthorized.WithHeader("WWW-Authenticate", `Bearer realm="admin"`)
+	}
+	return nil
+}
+
+// routeAdminRequest dispatches an authorized admin request, path is relative to the admin API root
+// and actor identifies the client in the settings audit trail
+func routeAdminRequest(writer *bufio.Writer, method string, path string, query url.Values, actor string) error {
+	switch {
+	case path == "stats" && method == "GET":
+		return handleStatsRequest(writer)
+	case path == "config" && method == "GET":
+		return handleConfigRequest(writer)
+	case path == "usage" && method == "GET":
+		return handleAdminUsageRequest(writer)
+	case path == "diagnostics" && method == "POST":
+		handleDiagnosticsRequest(writer)
+	case path == "drain" && method == "POST":
+		handleDrainRequest(writer)
+	case path == "cache/stats" && method == "GET":
+		return handleCacheStatsRequest(writer)
+	case path == "cache/purge" && method == "POST":
+		return handleCachePurgeRequest(writer, query)
+	case path == "settings" && (method == "GET" || method == "POST"):
+		return handleSettingsRequest(writer, method, query, actor)
+	case path == "settings/audit" && method == "GET":
+		return handleAuditRequest(writer)
+	case path == "maintenance" && (method == "GET" || method == "POST"):
+		return handleMaintenanceRequest(writer, query)
+	default:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// listenAdminSocket serves the admin API on a unix socket only the server's user can connect to.
+// The bearer token is still required there when --admin-token is set.
+func listenAdminSocket(path string) error {
+	// A socket left behind by a previous run would make the bind fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	// Each connection carries a single request, so it can't keep a drain waiting
+	server := httpserver.New(httpserver.Config{
+		Handler:         serveAdminRequest,
+		MaxHeaderBytes:  *maxHeaderSizeFlag,
+		MaxHeaderFields: maxHeaderFields,
+		ReadBufferSize:  *readBufferFlag,
+		WriteBufferSize: *writeBufferFlag,
+		WriteError:      writeHTTPError,
+	})
+	server.SetKeepAlivesEnabled(false)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				fmt.Printf("Error accepting admin connection: %s\n", err.Error())
+				return
+			}
+			// Tracked like public connections so a drain requested here is still answered before exiting
+			connections.Add(1)
+			go func() {
+				defer connections.Done()
+				server.ServeConn(conn)
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// serveAdminRequest answers a request on the admin socket
+func serveAdminRequest(w *Response, r *Request) error {
+	if *adminTokenFlag != "" {
+		if err := authorizeAdmin(r); err != nil {
+			return err
+		}
+	}
+	return routeAdminRequest(w.Raw(), r.Method, strings.TrimPrefix(r.RawPath, "admin/"), r.Query(), "unix:"+*adminSocketFlag)
+}
+
+// handleStatsRequest will respond with the connection and request counters
+func handleStatsRequest(writer *bufio.Writer) error {
+	return writeJSON(writer, struct {
+		ActiveConnections int64        `json:"active_connections"`
+		Accepted          int64        `json:"accepted"`
+		Rejected          int64        `json:"rejected"`
+		Requests          int64        `json:"requests"`
+		Draining          bool         `json:"draining"`
+		Maintenance       bool         `json:"maintenance"`
+		Build             buildDetails `json:"build"`
+	}{activeConnections.Load(), acceptedConnections.Load(), rejectedConnections.Load(), handledRequests.Load(), draining.Load(), inMaintenance(), currentBuild()})
+}
+
+// handleConfigRequest will respond with the value of every flag, with secrets redacted
+func handleConfigRequest(writer *bufio.Writer) error {
+	config := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if (f.Name == "admin-token" || f.Name == "oidc-client-secret" || f.Name == "cookie-secrets") && value != "" {
+			value = "<redacted>"
+		}
+		if u, err := url.Parse(value); f.Name == "session-store" && err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		config[f.Name] = value
+	})
+	return writeJSON(writer, config)
+}
+
+// handleDrainRequest will stop the public listener from accepting connections, the server exits
+// once the connections in flight have finished
+func handleDrainRequest(writer *bufio.Writer) {
+	drain()
+	writer.WriteString("HTTP/1.1 202 Accepted\r\nContent-Length: 0\r\n\r\n")
+}
+
+// writeJSON will respond with v encoded as JSON
+func writeJSON(writer *bufio.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	newResponseHeader("200 OK").
+		add("Content-Type", "application/json").
+		add("Cache-Control", "no-store").
+		addInt("Content-Length", int64(len(body))).
+		write(writer, body)
+	return nil
+}
+
+// handleCachePurgeRequest will drop cached entries for the request target in ?path=, or every target
+// starting with it when ?prefix=true, from the response cache and the file info and listing caches
+func handleCachePurgeRequest(writer *bufio.Writer, query url.Values) error {
+	path := query.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		return ErrBadRequest
+	}
+	prefix := query.Get("prefix") == "true"
+
+	purged := respCache.purge(path, prefix)
+
+	// File info and listings are cached by filesystem path, so map /files/ targets onto the files directory
+	if name, ok := strings.CutPrefix(path, "/files"); ok && *directoryFlag != "" {
+		if filePath, err := safepath.Resolve(*directoryFlag, strings.TrimPrefix(name, "/")); err == nil {
+			purged += infoCache.purge(filePath, prefix)
+			purged += listingCache.purge(filePath, prefix)
+		}
+	}
+
+	return writeJSON(writer, struct {
+		Purged int `json:"purged"`
+	}{purged})
+}
+
+// purge forgets the info cached for path, or for every path below it when prefix is set
+func (c *fileInfoCache) purge(path string, prefix bool) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for cached := range c.entries {
+		if cached == path || (prefix && strings.HasPrefix(cached, path)) {
+			delete(c.entries, cached)
+			purged++
+		}
+	}
+	return purged
+}
+
+// purge forgets the listings cached for the directory at path, or for every directory below it when prefix is set
+func (c *bodyCache) purge(path string, prefix bool) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path = filepath.Clean(path)
+
+	purged := 0
+	for key := range c.entries {
+		dir, _, _ := strings.Cut(key, "?")
+		if dir == path || (prefix && strings.HasPrefix(dir, path)) {
+			delete(c.entries, key)
+			purged++
+		}
+	}
+	return purged
+}
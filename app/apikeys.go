@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiKeyQuota are the limits of a single API key, a zero limit is unlimited
+type apiKeyQuota struct {
+	Name            string `json:"name"`
+	DailyRequests   int64  `json:"daily_requests"`
+	MonthlyRequests int64  `json:"monthly_requests"`
+	DailyBytes      int64  `json:"daily_bytes"`
+	MonthlyBytes    int64  `json:"monthly_bytes"`
+}
+
+// apiKeyUsage counts the requests and bytes of a key in the current day and month (UTC)
+type apiKeyUsage struct {
+	Day             string `json:"day"`
+	DailyRequests   int64  `json:"daily_requests"`
+	DailyBytes      int64  `json:"daily_bytes"`
+	Month           string `json:"month"`
+	MonthlyRequests int64  `json:"monthly_requests"`
+	MonthlyBytes    int64  `json:"monthly_bytes"`
+}
+
+// apiKey is a configured key with its quota and usage. Usage is kept in memory and starts over on restart.
+type apiKey struct {
+	quota apiKeyQuota
+	mu    sync.Mutex
+	usage apiKeyUsage
+}
+
+// apiKeys maps keys from --api-keys to their *apiKey, nil when the file API is open to everyone
+var apiKeys map[string]*apiKey
+
+// loadAPIKeys reads a JSON object mapping each key to its apiKeyQuota
+func loadAPIKeys(path string) (map[string]*apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var quotas map[string]apiKeyQuota
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*apiKey, len(quotas))
+	for key, quota := range quotas {
+		if quota.Name == "" {
+			quota.Name = keyFingerprint(key)
+		}
+		keys[key] = &apiKey{quota: quota}
+	}
+	return keys, nil
+}
+
+// keyFingerprint identifies a key in logs and usage reports without revealing it
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// currentUsage returns the usage of k, rolling the counters over when a new day or month has started
+func (k *apiKey) currentUsage(now time.Time) *apiKeyUsage {
+	day, month := now.UTC().Format(time.DateOnly), now.UTC().Format("2006-01")
+	if k.usage.Month != month {
+		k.usage.Month, k.usage.MonthlyRequests, k.usage.MonthlyBytes = month, 0, 0
+	}
+	if k.usage.Day != day {
+		k.usage.Day, k.usage.DailyRequests, k.usage.DailyBytes = day, 0, 0
+	}
+	return &k.usage
+}
+
+// admit counts a request against k, returning the error to reject it with once a quota is exhausted:
+// 429 with a Retry-After of the next UTC midnight for daily quotas, 403 for monthly ones
+func (k *apiKey) admit(now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	usage, quota := k.currentUsage(now), k.quota
+	if exhausted(usage.MonthlyRequests, quota.MonthlyRequests) || exhausted(usage.MonthlyBytes, quota.MonthlyBytes) {
+		return ErrForbidden
+	}
+	if exhausted(usage.DailyRequests, quota.DailyRequests) || exhausted(usage.DailyBytes, quota.DailyBytes) {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		retryAfter := strconv.Itoa(int(midnight.Sub(now).Seconds()) + 1)
+		return ErrTooManyRequests.WithHeader("Retry-After", retryAfter)
+	}
+
+	usage.DailyRequests++
+	usage.MonthlyRequests++
+	return nil
+}
+
+// exhausted reports whether used has reached a non-zero limit
+func exhausted(used int64, limit int64) bool {
+	return limit > 0 && used >= limit
+}
+
+// addBytes counts n transferred bytes against k
+func (k *apiKey) addBytes(n int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	usage := k.currentUsage(time.Now())
+	usage.DailyBytes += n
+	usage.MonthlyBytes += n
+}
+
+// snapshot returns the key's current usage
+func (k *apiKey) snapshot() apiKeyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return *k.currentUsage(time.Now())
+}
+
+// lookupAPIKey returns the key sent in the request's X-API-Key header, or a 401 when it is missing or unknown
+func lookupAPIKey(head *Request) (*apiKey, error) {
+	key, ok := apiKeys[head.Header.Get("X-API-Key")]
+	if !ok {
+		return nil, ErrUnauthorized.WithHeader("WWW-Authenticate", "X-API-Key")
+	}
+	return key, nil
+}
+
+// withAPIKey runs handler once the request's API key has been checked against its quotas, counting the bytes
+// of the request body and of the response towards the key's usage. Without --api-keys handler runs unchecked.
+func withAPIKey(writer *bufio.Writer, head *Request, handler func(writer *bufio.Writer) error) error {
+	if apiKeys == nil {
+		return handler(writer)
+	}
+
+	key, err := lookupAPIKey(head)
+	if err != nil {
+		return err
+	}
+
+	if err := key.admit(time.Now()); err != nil {
+		fmt.Printf("Rejected request for API key %s: quota exhausted\n", key.quota.Name)
+		return err
+	}
+
+	counter := &countingWriter{w: writer}
+	counted := bufio.NewWriter(counter)
+	err = handler(counted)
+	counted.Flush()
+
+	requestBytes, _ := strconv.ParseInt(head.Header.Get("Content-Length"), 10, 64)
+	key.addBytes(max(requestBytes, 0) + counter.n)
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom, so file bodies keep reaching the connection's ReadFrom
+func (cw *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := cw.w.ReadFrom(r)
+	cw.n += n
+	return n, err
+}
+
+// usageReport is how the usage endpoints present a key
+type usageReport struct {
+	Name  string      `json:"name"`
+	Quota apiKeyQuota `json:"quota"`
+	Usage apiKeyUsage `json:"usage"`
+}
+
+// handleUsageRequest will respond with the quotas and usage of the request's own API key
+func handleUsageRequest(writer *bufio.Writer, head *Request) error {
+	if apiKeys == nil {
+		return ErrNotFound
+	}
+
+	key, err := lookupAPIKey(head)
+	if err != nil {
+		return err
+	}
+	return writeJSON(writer, usageReport{key.quota.Name, key.quota, key.snapshot()})
+}
+
+// handleAdminUsageRequest will respond with the quotas and usage of every API key
+func handleAdminUsageRequest(writer *bufio.Writer) error {
+	reports := []usageReport{}
+	for _, key := range apiKeys {
+		reports = append(reports, usageReport{key.quota.Name, key.quota, key.snapshot()})
+	}
+	return writeJSON(writer, reports)
+}
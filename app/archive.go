@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// archiveFormats maps the supported ?format= values to their content type and file extension
+var archiveFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"zip":    {"application/zip", ".zip"},
+	"tar.gz": {"application/gzip", ".tar.gz"},
+}
+
+// handleArchiveRequest will stream an archive of the directory at dirPath in the requested format
+func handleArchiveRequest(writer *bufio.Writer, head *Request, dirPath string, format string) error {
+	archiveFormat, ok := archiveFormats[format]
+	if !ok {
+		return ErrBadRequest
+	}
+
+	name := filepath.Base(filepath.Clean(dirPath)) + archiveFormat.extension
+
+	header := newResponseHeader("200 OK").
+		add("Content-Type", archiveFormat.contentType).
+		add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+
+	// The archive size is unknown until it has been written
+	body, end := startStreamedBody(writer, head, header)
+
+	var err error
+	switch format {
+	case "zip":
+		err = writeZipArchive(body, dirPath)
+	case "tar.gz":
+		err = writeTarGzArchive(body, dirPath)
+	}
+
+	// Headers have already been sent at this point, so all we can do is log and cut the stream short
+	if err != nil {
+		fmt.Printf("Error writing archive: %s\n", err.Error())
+		head.CloseAfterResponse()
+		return nil
+	}
+	end()
+	return nil
+}
+
+// writeZipArchive writes a zip archive of every regular file under root to w
+func writeZipArchive(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkArchiveFiles(root, func(name string, info fs.FileInfo, file *os.File) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = copyAll(entry, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeTarGzArchive writes a gzip compressed tar archive of every regular file under root to w
+func writeTarGzArchive(w io.Writer, root string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := walkArchiveFiles(root, func(name string, info fs.FileInfo, file *os.File) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err = copyAll(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// walkArchiveFiles calls fn for every regular file under root with its slash separated name relative to root,
+// leaving out the files listings hide
+func walkArchiveFiles(root string, fn func(name string, info fs.FileInfo, file *os.File) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == tusDirName {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || isInternalFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return fn(filepath.ToSlash(rel), info, file)
+	})
+}
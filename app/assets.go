@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// immutableCacheControl is sent for content-hashed assets, whose content can never change under the same name
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// manifestName is the file written by the manifest subcommand
+const manifestName = "manifest.json"
+
+// hashedAssetPattern matches the end of names like app.3f9a2c.js or app-3f9a2c1b.min.css from the
+// separator before the hash
+var hashedAssetPattern = regexp.MustCompile(`(?i)^[.-]([0-9a-f]{6,64})(\.[a-z0-9]+)+$`)
+
+// isHashedAssetName reports whether the file name contains a content hash. Hex words like decade or
+// numbers like 20240101 are no hashes, a hash has both a digit and a letter.
+func isHashedAssetName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' && name[i] != '-' {
+			continue
+		}
+		if match := hashedAssetPattern.FindStringSubmatch(name[i:]); match != nil && isContentHash(match[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContentHash reports whether the hex digits mix digits and letters, as a hash almost always does
+func isContentHash(hash string) bool {
+	return strings.ContainsAny(hash, "0123456789") && strings.ContainsAny(hash, "abcdefABCDEF")
+}
+
+// runManifest implements the manifest subcommand: every file under dir without a content hash in its
+// name gets a hashed copy (app.js -> app.3f9a2c1b.js), and manifest.json maps logical to hashed names
+func runManifest(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: manifest <directory>")
+		os.Exit(2)
+	}
+
+	manifest, err := writeAssetManifest(args[0])
+	if err != nil {
+		fmt.Printf("Failed to generate manifest: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote %d entries to %s\n", len(manifest), filepath.Join(args[0], manifestName))
+}
+
+// writeAssetManifest creates the hashed copies of the assets under root and writes their manifest
+func writeAssetManifest(root string) (map[string]string, error) {
+	manifest := map[string]string{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || isHashedAssetName(d.Name()) || (d.Name() == manifestName && filepath.Dir(path) == root) {
+			return nil
+		}
+
+		hashedPath, err := copyHashedAsset(path)
+		if err != nil {
+			return err
+		}
+
+		logical, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hashed, err := filepath.Rel(root, hashedPath)
+		if err != nil {
+			return err
+		}
+
+		manifest[filepath.ToSlash(logical)] = filepath.ToSlash(hashed)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, os.WriteFile(filepath.Join(root, manifestName), append(data, '\n'), 0644)
+}
+
+// copyHashedAsset copies path next to itself with the first 8 hex digits of its SHA-256 inserted before
+// the extension, or as many more as it takes to mix digits and letters so the name is seen as hashed
+func copyHashedAsset(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	digits := hex.EncodeToString(sum[:])
+	hash := digits[:8]
+	for i := len(hash); !isContentHash(hash) && i < len(digits); i++ {
+		hash = digits[:i+1]
+	}
+
+	base := filepath.Base(path)
+	name, ext, found := strings.Cut(base, ".")
+	hashedName := name + "." + hash
+	if found {
+		hashedName += "." + ext
+	} else {
+		hashedName += ".bin"
+	}
+
+	hashedPath := filepath.Join(filepath.Dir(path), hashedName)
+	if _, err := os.Stat(hashedPath); err == nil {
+		return hashedPath, nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(hashedPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+
+	return hashedPath, dst.Close()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version, commit, and buildDate identify the binary. Release builds set them at link time:
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)" app/*.go
+//
+// Otherwise they are filled in from the module and VCS information the go command embeds.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// buildDetails describes the running binary
+type buildDetails struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// currentBuild returns the link time build details, completed with the embedded build information
+func currentBuild() buildDetails {
+	build := buildDetails{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if build.Version == "" && info.Main.Version != "(devel)" {
+			build.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if build.Commit == "" {
+					build.Commit = setting.Value
+				}
+			case "vcs.time":
+				if build.BuildDate == "" {
+					build.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				build.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if build.Version == "" {
+		build.Version = "dev"
+	}
+	return build
+}
+
+// String returns the build details on one line, as printed by --version
+func (b buildDetails) String() string {
+	details := []string{"version " + b.Version}
+	if b.Commit != "" {
+		commit := b.Commit
+		if b.Modified {
+			commit += "-dirty"
+		}
+		details = append(details, "commit "+commit)
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion)
+	return "http-server " + strings.Join(details, ", ")
+}
+
+// handleVersionRequest will respond with the build details of the running binary
+func handleVersionRequest(writer *bufio.Writer) error {
+	return writeJSON(writer, currentBuild())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chaosErrors are the server errors randomly returned at --chaos-error-rate
+var chaosErrors = []*httpError{ErrInternal, ErrBadGateway, ErrUnavailable, ErrGatewayTimeout}
+
+// chaosFail reports whether this request should be answered with a random server error
+func chaosFail() bool {
+	rate := chaosRateFlag.Get()
+	return rate > 0 && rand.Float64() < rate
+}
+
+// chaosError returns one of the chaosErrors
+func chaosError() error {
+	return chaosErrors[rand.IntN(len(chaosErrors))]
+}
+
+// handleChaosRequest will handle the fault-injection endpoints enabled by --chaos:
+//
+//	/chaos/truncate?size=N              declares N bytes but closes the connection after half of them
+//	/chaos/wrong-length?size=N&declared=M  sends N bytes with a Content-Length of M
+//	/chaos/delay?ms=N                   waits N milliseconds before sending the headers
+//	/chaos/reset?size=N                 resets the connection halfway through an N byte body
+//	/chaos/panic                        panics in the handler, which is answered with a 500
+func handleChaosRequest(conn net.Conn, writer *bufio.Writer, path string, query url.Values) error {
+	if !*chaosFlag {
+		return ErrNotFound
+	}
+
+	size, err := chaosParam(query, "size", 1024)
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	switch strings.TrimPrefix(path, "chaos/") {
+	case "truncate":
+		writeChaosHeader(writer, size)
+		writeChaosBody(writer, size/2)
+		writer.Flush()
+		closeWrite(conn)
+	case "wrong-length":
+		declared, err := chaosParam(query, "declared", size*2)
+		if err != nil {
+			return ErrBadRequest
+		}
+		writeChaosHeader(writer, declared)
+		writeChaosBody(writer, size)
+	case "delay":
+		ms, err := chaosParam(query, "ms", 1000)
+		if err != nil {
+			return ErrBadRequest
+		}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		writeChaosHeader(writer, size)
+		writeChaosBody(writer, size)
+	case "reset":
+		writeChaosHeader(writer, size)
+		writeChaosBody(writer, size/2)
+		writer.Flush()
+		// A zero linger makes Close send a RST instead of a FIN
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	case "panic":
+		panic("injected by /chaos/panic")
+	default:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// chaosParam parses the non-negative integer query parameter name, defaulting to fallback
+func chaosParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%s must not be negative", name)
+	}
+	return n, err
+}
+
+// writeChaosHeader writes a 200 response header declaring contentLength bytes
+func writeChaosHeader(writer *bufio.Writer, contentLength int) {
+	newResponseHeader("200 OK").
+		add("Content-Type", "text/plain").
+		addInt("Content-Length", int64(contentLength)).
+		write(writer, nil)
+}
+
+// writeChaosBody writes n filler bytes
+func writeChaosBody(writer *bufio.Writer, n int) {
+	for i := 0; i < n; i++ {
+		writer.WriteByte('a' + byte(i%26))
+	}
+}
+
+// closeWrite shuts down the sending side of conn so the client sees the body end early
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+		return
+	}
+	conn.Close()
+}
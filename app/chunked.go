@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// chunkedWriter writes a response body with the chunked transfer coding, for responses whose length
+// isn't known when their header is sent
+type chunkedWriter struct {
+	w    *bufio.Writer
+	size []byte
+}
+
+// newChunkedWriter returns a writer sending the body in chunks to w
+func newChunkedWriter(w *bufio.Writer) *chunkedWriter {
+	return &chunkedWriter{w: w, size: make([]byte, 0, 18)}
+}
+
+// Write sends p as one chunk. An empty p is skipped, since a chunk of size zero ends the body.
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	cw.size = strconv.AppendInt(cw.size[:0], int64(len(p)), 16)
+	cw.size = append(cw.size, "\r\n"...)
+	if _, err := cw.w.Write(cw.size); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = cw.w.WriteString("\r\n")
+	return n, err
+}
+
+// Close ends the body with the last chunk and an empty trailer section. A body that is cut short
+// must not be closed, so the client can tell it is incomplete.
+func (cw *chunkedWriter) Close() error {
+	_, err := cw.w.WriteString("0\r\n\r\n")
+	return err
+}
+
+// CloseWithTrailer ends the body like Close, with a trailer section holding the field name: value, which
+// the header should announce with Trailer
+func (cw *chunkedWriter) CloseWithTrailer(name string, value string) error {
+	_, err := cw.w.WriteString("0\r\n" + name + ": " + value + "\r\n\r\n")
+	return err
+}
+
+// startStreamedBody will write header for a body whose length isn't known yet, which is sent chunked
+// to HTTP/1.1 clients and delimited by closing the connection for HTTP/1.0 clients that don't understand
+// chunked bodies. It returns the writer of the body and the func ending it, which a body cut short by
+// an error must not call.
+func startStreamedBody(writer *bufio.Writer, head *Request, header *responseHeader) (io.Writer, func()) {
+	if head.Proto != "HTTP/1.1" {
+		header.add("Connection", "close").write(writer, nil)
+		return writer, func() {}
+	}
+
+	chunked := newChunkedWriter(writer)
+	header.add("Transfer-Encoding", "chunked").write(writer, nil)
+	return chunked, func() { chunked.Close() }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// entityTag is an entity tag from a conditional request field
+type entityTag struct {
+	// opaque is the tag including its quotes, without the W/ prefix
+	opaque string
+	weak   bool
+}
+
+// parseETags parses an If-Match or If-None-Match value into its entity tags, reporting whether it was *.
+// Anything that isn't a quoted tag ends the list.
+func parseETags(value string) ([]entityTag, bool) {
+	var tags []entityTag
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags, false
+		}
+		if value[0] == '*' {
+			return nil, true
+		}
+
+		var tag entityTag
+		if rest, ok := strings.CutPrefix(value, "W/"); ok {
+			tag.weak, value = true, rest
+		}
+		if !strings.HasPrefix(value, `"`) {
+			return tags, false
+		}
+		end := strings.IndexByte(value[1:], '"')
+		if end < 0 {
+			return tags, false
+		}
+		tag.opaque, value = value[:end+2], value[end+2:]
+		tags = append(tags, tag)
+	}
+}
+
+// encodedETag returns the entity tag of the file with etag encoded in coding, each coding is a separate
+// representation and must not share the tag of the unencoded file
+func encodedETag(etag string, coding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// sameEntity reports whether tag names the file with etag in any of its content codings
+func sameEntity(tag string, etag string) bool {
+	if tag == etag {
+		return true
+	}
+	for _, encoder := range httpserver.Encoders() {
+		if tag == encodedETag(etag, encoder.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesETag reports whether the field value lists a tag of the file with etag. Weak tags only match
+// when weak is set, for If-None-Match, If-Match only accepts strong ones.
+func matchesETag(value string, etag string, exists bool, weak bool) bool {
+	tags, wildcard := parseETags(value)
+	if wildcard {
+		return exists
+	}
+	for _, tag := range tags {
+		if exists && (weak || !tag.weak) && sameEntity(tag.opaque, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpDate formats t as an HTTP date, like Last-Modified
+func httpDate(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// modifiedSince reports whether modified is later than the HTTP date value, which only has whole
+// seconds, and whether value was a valid date at all
+func modifiedSince(modified time.Time, value string) (bool, bool) {
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return false, false
+	}
+	return modified.Truncate(time.Second).After(date), true
+}
+
+// checkPreconditions evaluates the conditional fields of the request against the file with etag
+// and modification time modified in the order of RFC 9110 section 13.2.2, exists is false when the
+// target doesn't exist yet. It reports whether a GET or HEAD should be answered with 304 Not Modified,
+// and returns a 412 when a precondition of any other method fails.
+func checkPreconditions(head *Request, etag string, modified time.Time, exists bool) (bool, error) {
+	if value := head.Header.Get("If-Match"); value != "" {
+		if !matchesETag(value, etag, exists, false) {
+			return false, ErrPreconditionFailed
+		}
+	} else if value := head.Header.Get("If-Unmodified-Since"); value != "" && exists {
+		if changed, ok := modifiedSince(modified, value); ok && changed {
+			return false, ErrPreconditionFailed
+		}
+	}
+
+	safe := head.Method == "GET" || head.Method == "HEAD"
+	if value := head.Header.Get("If-None-Match"); value != "" {
+		if !matchesETag(value, etag, exists, true) {
+			return false, nil
+		}
+		if safe {
+			return true, nil
+		}
+		return false, ErrPreconditionFailed
+	}
+	if value := head.Header.Get("If-Modified-Since"); value != "" && safe && exists {
+		if changed, ok := modifiedSince(modified, value); ok && !changed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ifRangeMatches reports whether a Range should be honored given the request's If-Range value, which
+// must name the unencoded file exactly, by its strong entity tag or its exact Last-Modified date
+func ifRangeMatches(value string, etag string, modified time.Time) bool {
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) {
+		return value == etag
+	}
+	return value == httpDate(modified)
+}
+
+// writeNotModified will respond with 304 Not Modified and the validators of the representation
+func writeNotModified(writer *bufio.Writer, etag string, modified time.Time) {
+	newResponseHeader("304 Not Modified").
+		add("ETag", etag).
+		add("Last-Modified", httpDate(modified)).
+		write(writer, nil)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables flags fall back to, --read-timeout is read from
+// HTTP_SERVER_READ_TIMEOUT when it isn't given on the command line
+const envPrefix = "HTTP_SERVER_"
+
+// envName returns the environment variable the flag name falls back to
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvironment sets every flag of flags that wasn't given on the command line from its environment
+// variable, when that is set. It must run after the flags are parsed.
+func applyEnvironment(flags *flag.FlagSet) error {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var errs []error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if given[f.Name] || !ok {
+			return
+		}
+		if err := flags.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", envName(f.Name), err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// listenAddr returns the address to listen on port at, on the --addr interface
+func listenAddr(port int) string {
+	return net.JoinHostPort(*addrFlag, strconv.Itoa(port))
+}
+
+// validateConfig checks the port, limit, and directory flags, so a bad value ends the server at startup
+// instead of failing requests. An --addr that can't be listened on is reported when binding.
+func validateConfig() error {
+	if *portFlag < 1 || *portFlag > 65535 {
+		return errors.New("--port must be between 1 and 65535")
+	}
+	if *tlsPortFlag < 0 || *tlsPortFlag > 65535 {
+		return errors.New("--tls-port must be between 0 and 65535")
+	}
+	if *readTimeoutFlag < 0 || *idleTimeoutFlag < 0 || *writeTimeoutFlag < 0 {
+		return errors.New("--read-timeout, --idle-timeout, and --write-timeout must not be negative")
+	}
+	if *maxConnectionsFlag < 0 || *maxConnsPerIPFlag < 0 {
+		return errors.New("--max-connections and --max-conns-per-ip must not be negative")
+	}
+	if *maxUploadSizeFlag < 0 || *maxBodySizeFlag < 0 {
+		return errors.New("--max-upload-size and --max-body-size must not be negative")
+	}
+	if *cacheMaxEntriesFlag <= 0 || *cacheMaxBytesFlag <= 0 {
+		return errors.New("--cache-max-entries and --cache-max-bytes must be positive")
+	}
+	if *maxHeaderSizeFlag <= 0 {
+		return errors.New("--max-header-size must be positive")
+	}
+	if *readBufferFlag <= 0 || *writeBufferFlag <= 0 || *copyBufferFlag <= 0 {
+		return errors.New("--read-buffer-size, --write-buffer-size, and --copy-buffer-size must be positive")
+	}
+
+	if *staticFlag != "" {
+		info, err := os.Stat(*staticFlag)
+		if err != nil {
+			return fmt.Errorf("--static: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("--static %s is not a directory", *staticFlag)
+		}
+	}
+
+	if *directoryFlag == "" {
+		switch {
+		case *quotaFlag > 0:
+			return errors.New("--quota requires --directory")
+		case *watchFlag:
+			return errors.New("--watch requires --directory")
+		case *tusPathFlag != "":
+			return errors.New("--tus-path requires --directory")
+		}
+		return nil
+	}
+
+	info, err := os.Stat(*directoryFlag)
+	if err != nil {
+		return fmt.Errorf("--directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("--directory %s is not a directory", *directoryFlag)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustedProxies are the networks parsed from --trusted-proxies whose X-Forwarded-For header is believed
+var trustedProxies []netip.Prefix
+
+var (
+	ipConnsMu sync.Mutex
+	ipConns   = map[netip.Addr]int{}
+)
+
+// parseTrustedProxies parses a comma separated list of CIDR prefixes or bare addresses
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// remoteIP returns the IP address of the peer of conn
+func remoteIP(conn net.Conn) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+// isTrustedProxy reports whether ip falls inside one of --trusted-proxies
+func isTrustedProxy(ip netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClient returns the client the request came from, as far as the connection and a trusted
+// proxy forwarding it tell
+func requestClient(r *Request) netip.Addr {
+	peer := remoteIP(r.Conn)
+	if isTrustedProxy(peer) {
+		return forwardedClientIP(r, peer)
+	}
+	return peer
+}
+
+// forwardedClientIP returns the client a trusted proxy forwarded the request for: the rightmost
+// X-Forwarded-For entry that isn't itself a trusted proxy, or peer when there is none
+func forwardedClientIP(head *Request, peer netip.Addr) netip.Addr {
+	hops := strings.Split(head.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = ip.Unmap()
+		if !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return peer
+}
+
+// acquireIPSlot counts another connection against ip, reporting false without counting it when ip
+// already holds --max-conns-per-ip connections
+func acquireIPSlot(ip netip.Addr) bool {
+	if *maxConnsPerIPFlag <= 0 {
+		return true
+	}
+
+	ipConnsMu.Lock()
+	defer ipConnsMu.Unlock()
+
+	if ipConns[ip] >= *maxConnsPerIPFlag {
+		return false
+	}
+	ipConns[ip]++
+	return true
+}
+
+// releaseIPSlot returns a connection acquired by acquireIPSlot
+func releaseIPSlot(ip netip.Addr) {
+	if *maxConnsPerIPFlag <= 0 {
+		return
+	}
+
+	ipConnsMu.Lock()
+	defer ipConnsMu.Unlock()
+
+	if ipConns[ip]--; ipConns[ip] <= 0 {
+		delete(ipConns, ip)
+	}
+}
+
+// rejectWriteTimeout bounds how long a connection over --max-connections may take to accept its 503
+const rejectWriteTimeout = time.Second
+
+// rejectConnection will answer a connection accepted over --max-connections with a 503 and close it.
+// TLS connections are closed right away, the 503 would first need a handshake the client controls.
+func rejectConnection(conn net.Conn) {
+	rejectedConnections.Add(1)
+	defer conn.Close()
+
+	if _, ok := conn.(*tls.Conn); ok {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nRetry-After: 1\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+}
+
+// tooManyConnections returns the 429 for a client that reached the limit through a trusted proxy,
+// the proxy's connection can't simply be dropped since it carries other clients too
+func tooManyConnections(ip netip.Addr) error {
+	fmt.Printf("Rejected request from %s: too many concurrent connections\n", ip)
+	return ErrTooManyRequests.WithHeader("Connection", "close")
+}
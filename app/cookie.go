@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cookieKey is the pair of keys derived from one configured secret
+type cookieKey struct {
+	sign    []byte
+	encrypt cipher.AEAD
+}
+
+// cookieCodec protects cookie values against tampering by signing them with HMAC-SHA256, or against
+// being read as well by sealing them with AES-256-GCM. Values are always produced with the first key
+// and accepted under any of them, so a secret can be rotated by prepending the new one.
+type cookieCodec struct {
+	keys    []cookieKey
+	encrypt bool
+}
+
+// cookies is the codec for the cookies the server sets, configured by --cookie-secrets and --encrypt-cookies
+var cookies *cookieCodec
+
+// newCookieCodec derives signing and encryption keys from each secret, newest first
+func newCookieCodec(secrets []string, encrypt bool) (*cookieCodec, error) {
+	codec := &cookieCodec{encrypt: encrypt}
+	for _, secret := range secrets {
+		block, err := aes.NewCipher(deriveKey(secret, "cookie-encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, cookieKey{sign: deriveKey(secret, "cookie-sign"), encrypt: aead})
+	}
+	return codec, nil
+}
+
+// deriveKey derives a 32 byte key for purpose from secret
+func deriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// encode protects value for use as the cookie called name. The name is bound into the signature or
+// ciphertext so a value can't be replayed under a different cookie.
+func (c *cookieCodec) encode(name string, value []byte) string {
+	key := c.keys[0]
+
+	if c.encrypt {
+		nonce := make([]byte, key.encrypt.NonceSize())
+		rand.Read(nonce)
+		return base64.RawURLEncoding.EncodeToString(key.encrypt.Seal(nonce, nonce, value, []byte(name)))
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(value)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signCookie(key.sign, name, encoded))
+}
+
+// decode returns the value protected by encode, reporting false when it was forged or tampered with
+func (c *cookieCodec) decode(name string, cookie string) ([]byte, bool) {
+	if c.encrypt {
+		sealed, err := base64.RawURLEncoding.DecodeString(cookie)
+		if err != nil {
+			return nil, false
+		}
+		for _, key := range c.keys {
+			size := key.encrypt.NonceSize()
+			if len(sealed) < size {
+				return nil, false
+			}
+			if value, err := key.encrypt.Open(nil, sealed[:size], sealed[size:], []byte(name)); err == nil {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+
+	encoded, signature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+	for _, key := range c.keys {
+		if hmac.Equal(mac, signCookie(key.sign, name, encoded)) {
+			value, err := base64.RawURLEncoding.DecodeString(encoded)
+			return value, err == nil
+		}
+	}
+	return nil, false
+}
+
+// signCookie returns the HMAC of the cookie's name and encoded value
+func signCookie(key []byte, name string, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "=" + encoded))
+	return mac.Sum(nil)
+}
+
+// getCookie returns the value of the named cookie sent with the request
+func getCookie(head *Request, name string) string {
+	for _, value := range head.Header.Values("Cookie") {
+		for _, cookie := range strings.Split(value, ";") {
+			if n, v, ok := strings.Cut(strings.TrimSpace(cookie), "="); ok && n == name {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// getSecureCookie returns the value of the named cookie if the codec can vouch for it
+func getSecureCookie(head *Request, name string) ([]byte, bool) {
+	cookie := getCookie(head, name)
+	if cookie == "" {
+		return nil, false
+	}
+	return cookies.decode(name, cookie)
+}
+
+// secureCookie returns a Set-Cookie header value setting the named cookie to the protected value for
+// maxAge. The cookie is HttpOnly, and Secure when secure is set.
+func secureCookie(name string, value []byte, maxAge time.Duration, secure bool) string {
+	cookie := name + "=" + cookies.encode(name, value) + "; Max-Age=" + strconv.Itoa(int(maxAge.Seconds())) + "; Path=/; HttpOnly; SameSite=Lax"
+	if secure {
+		cookie += "; Secure"
+	}
+	return cookie
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// corsMethods are the methods a preflight request is told cross-origin requests may use
+	corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+	// corsExposedHeaders are the response fields beyond the CORS-safelisted ones scripts may read
+	corsExposedHeaders = "Content-Disposition, Content-Encoding, Content-Range, Etag, Location, Upload-Offset, X-Checksum"
+
+	// corsMaxAge is how many seconds a browser may cache the answer to a preflight request
+	corsMaxAge = "600"
+)
+
+var (
+	// corsOrigins are the origins parsed from --cors-origins, like https://app.example.com
+	corsOrigins map[string]bool
+
+	// corsAnyOrigin is set when --cors-origins is *, allowing every origin
+	corsAnyOrigin bool
+)
+
+// parseCORSOrigins parses a comma separated list of origins, each a scheme and host with an optional
+// port like https://app.example.com:8443, or * for any origin
+func parseCORSOrigins(value string) (map[string]bool, bool, error) {
+	origins := map[string]bool{}
+	anyOrigin := false
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			anyOrigin = true
+			continue
+		}
+
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.User != nil || parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return nil, false, fmt.Errorf("%q is not an origin like https://app.example.com", origin)
+		}
+		origins[strings.ToLower(origin)] = true
+	}
+	return origins, anyOrigin, nil
+}
+
+// corsEnabled reports whether --cors-origins allows any origin at all
+func corsEnabled() bool {
+	return corsAnyOrigin || len(corsOrigins) > 0
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin for a request from origin, or "" when the origin
+// isn't allowed. Credentialed requests are never answered with *, browsers refuse that.
+func allowedOrigin(origin string) string {
+	switch {
+	case origin == "":
+		return ""
+	case corsAnyOrigin && !*corsCredentialsFlag:
+		return "*"
+	case corsAnyOrigin || corsOrigins[strings.ToLower(origin)]:
+		return origin
+	default:
+		return ""
+	}
+}
+
+// corsFields returns the CORS fields added to the response to r, or nil when CORS is disabled or the
+// request switches protocols. Unless every origin gets *, the answer depends on the Origin, which caches
+// are told with Vary even when the origin isn't allowed.
+func corsFields(r *Request) [][2]string {
+	if !corsEnabled() || r.Header.Get("Upgrade") != "" {
+		return nil
+	}
+
+	origin := allowedOrigin(r.Header.Get("Origin"))
+	var fields [][2]string
+	if origin != "*" {
+		fields = append(fields, [2]string{"Vary", "Origin"})
+	}
+	if origin == "" {
+		return fields
+	}
+
+	fields = append(fields,
+		[2]string{"Access-Control-Allow-Origin", origin},
+		[2]string{"Access-Control-Expose-Headers", corsExposedHeaders})
+	if *corsCredentialsFlag {
+		fields = append(fields, [2]string{"Access-Control-Allow-Credentials", "true"})
+	}
+	return fields
+}
+
+// isPreflight reports whether r is a CORS preflight request from an allowed origin. Preflight requests
+// from other origins are routed like any OPTIONS request, and the browser refuses the missing fields.
+func isPreflight(r *Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" &&
+		allowedOrigin(r.Header.Get("Origin")) != ""
+}
+
+// handlePreflight will answer a preflight request with the methods and request headers cross-origin
+// requests may use, the allowed origin itself is added with the rest of the CORS fields
+func handlePreflight(w *Response, r *Request) error {
+	w.SetStatus(204)
+	w.SetHeader("Access-Control-Allow-Methods", corsMethods)
+	if headers := strings.Join(r.Header.Values("Access-Control-Request-Headers"), ", "); headers != "" {
+		w.SetHeader("Access-Control-Allow-Headers", headers)
+		w.SetHeader("Vary", "Access-Control-Request-Headers")
+	}
+	w.SetHeader("Access-Control-Max-Age", corsMaxAge)
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// Connection states shown in the diagnostic dump, reading and writing are also counted for the stub status
+// page. They are the names of the engine's connection states.
+var (
+	connIdle    = httpserver.StateIdle.String()
+	connReading = httpserver.StateReading.String()
+	connWriting = httpserver.StateWriting.String()
+)
+
+// connInfo describes a public connection in flight for the diagnostic dump
+type connInfo struct {
+	remote  string
+	started time.Time
+	state   atomic.Pointer[string]
+	request atomic.Pointer[string]
+	tls     atomic.Pointer[tlsDetails]
+}
+
+// connTable maps every public net.Conn in flight to its *connInfo
+var connTable sync.Map
+
+// trackConnection adds conn to the connection table, the returned func removes it again
+func trackConnection(conn net.Conn) func() {
+	info := &connInfo{remote: conn.RemoteAddr().String(), started: time.Now()}
+	connTable.Store(conn, info)
+
+	return func() {
+		info.setState("")
+		connTable.Delete(conn)
+	}
+}
+
+// connectionInfo returns the entry of conn in the connection table, nil when it isn't tracked
+func connectionInfo(conn net.Conn) *connInfo {
+	if info, ok := connTable.Load(conn); ok {
+		return info.(*connInfo)
+	}
+	return nil
+}
+
+// setConnectionState records the state the engine moved a public connection to
+func setConnectionState(conn net.Conn, state httpserver.ConnState) {
+	if info := connectionInfo(conn); info != nil {
+		info.setState(state.String())
+	}
+}
+
+// setState moves the connection to state, keeping the reading and writing counters in step
+func (c *connInfo) setState(state string) {
+	if old := c.state.Swap(&state); old != nil {
+		stateCounter(*old).Add(-1)
+	}
+	stateCounter(state).Add(1)
+}
+
+// setTLS records the security of a TLS connection once its handshake is done
+func (c *connInfo) setTLS(details *tlsDetails) {
+	c.tls.Store(details)
+}
+
+// setRequest records the request line the connection is serving
+func (c *connInfo) setRequest(request string) {
+	c.request.Store(&request)
+}
+
+// stateCounter returns the counter tracking connections in state, or a throwaway one for untracked states
+func stateCounter(state string) *atomic.Int64 {
+	switch state {
+	case connReading:
+		return &readingConnections
+	case connWriting:
+		return &writingConnections
+	default:
+		return new(atomic.Int64)
+	}
+}
+
+// handleDiagnosticSignals will dump diagnostics every time the process receives SIGQUIT, which replaces the
+// runtime's default of dumping stacks and exiting
+func handleDiagnosticSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+
+	for range signals {
+		dumpDiagnostics()
+	}
+}
+
+// dumpDiagnostics will log the connection table, memory statistics, and the stacks of all goroutines
+func dumpDiagnostics() {
+	dump := bufio.NewWriter(os.Stdout)
+	defer dump.Flush()
+
+	now := time.Now()
+	fmt.Fprintf(dump, "=== Diagnostic dump at %s ===\n", now.Format(time.RFC3339))
+
+	var conns []*connInfo
+	connTable.Range(func(_, value any) bool {
+		conns = append(conns, value.(*connInfo))
+		return true
+	})
+	slices.SortFunc(conns, func(a, b *connInfo) int { return a.started.Compare(b.started) })
+
+	fmt.Fprintf(dump, "--- %d connections ---\n", len(conns))
+	for _, c := range conns {
+		state, request := "", ""
+		if s := c.state.Load(); s != nil {
+			state = *s
+		}
+		if r := c.request.Load(); r != nil {
+			request = *r
+		}
+		transport := "plain"
+		if t := c.tls.Load(); t != nil {
+			transport = fmt.Sprintf("%s/%s/sni=%s/alpn=%s", t.Version, t.Cipher, t.ServerName, t.Protocol)
+		}
+		fmt.Fprintf(dump, "%s\t%s\t%s\t%s\t%q\n", c.remote, state, transport, now.Sub(c.started).Round(time.Millisecond), request)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(dump, "--- memory ---\n")
+	fmt.Fprintf(dump, "alloc=%d total_alloc=%d sys=%d heap_objects=%d num_gc=%d goroutines=%d\n",
+		mem.Alloc, mem.TotalAlloc, mem.Sys, mem.HeapObjects, mem.NumGC, runtime.NumGoroutine())
+
+	// Grow the buffer until every goroutine's stack fits
+	stacks := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(stacks, true)
+		if n < len(stacks) {
+			stacks = stacks[:n]
+			break
+		}
+		stacks = make([]byte, 2*len(stacks))
+	}
+	fmt.Fprintf(dump, "--- goroutines ---\n%s\n", stacks)
+	fmt.Fprintf(dump, "=== End of diagnostic dump ===\n")
+}
+
+// handleDiagnosticsRequest will dump diagnostics to the log, as SIGQUIT does
+func handleDiagnosticsRequest(writer *bufio.Writer) {
+	dumpDiagnostics()
+	writer.WriteString("HTTP/1.1 202 Accepted\r\nContent-Length: 0\r\n\r\n")
+}
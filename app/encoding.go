@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// Encoder applies a content coding to response bodies
+type Encoder = httpserver.Encoder
+
+// writeEncoded will complete header and write it with the buffered body encoded with encoder, or as is
+// when encoder is nil
+func writeEncoded(writer *bufio.Writer, header *responseHeader, encoder Encoder, body []byte) error {
+	header.add("Vary", "Accept-Encoding")
+	if encoder != nil {
+		encoded, err := httpserver.EncodeBody(encoder, body)
+		if err != nil {
+			header.release()
+			return err
+		}
+		body = encoded
+		header.add("Content-Encoding", encoder.Name())
+	}
+	header.addInt("Content-Length", int64(len(body))).write(writer, body)
+	return nil
+}
+
+// minEncodedFileSize is the size below which files are sent as is, encoding them saves next to nothing
+const minEncodedFileSize = 1024
+
+// isCompressible reports whether a file named name holds text worth encoding, judging by its extension.
+// Most other formats are compressed already.
+func isCompressible(name string) bool {
+	contentType, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(name)), ";")
+	switch {
+	case strings.HasPrefix(contentType, "text/"),
+		strings.HasSuffix(contentType, "+json"), strings.HasSuffix(contentType, "+xml"):
+		return true
+	}
+	switch contentType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+	return false
+}
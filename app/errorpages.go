@@ -0,0 +1,69 @@
+package main
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errorPage is a document served as the body of an error response
+type errorPage struct {
+	contentType string
+	body        []byte
+}
+
+// errorPages maps status codes, and status classes like "5xx", to their document
+var errorPages = map[string]errorPage{}
+
+// loadErrorPages reads the error documents in dir. Files are named after the status code they
+// replace (404.html) or a status class with the trailing digits replaced (50x.html, 5xx.html).
+func loadErrorPages(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		ext := filepath.Ext(entry.Name())
+		key := strings.ToLower(strings.TrimSuffix(entry.Name(), ext))
+		if len(key) != 3 || key[0] < '1' || key[0] > '5' {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+
+		errorPages[key] = errorPage{contentType: contentType, body: body}
+	}
+
+	return nil
+}
+
+// errorPageFor finds the most specific error document for the status code
+func errorPageFor(code int) (errorPage, bool) {
+	key := strconv.Itoa(code)
+	if len(key) != 3 {
+		return errorPage{}, false
+	}
+
+	for _, key := range []string{key, key[:2] + "x", key[:1] + "xx"} {
+		if page, ok := errorPages[key]; ok {
+			return page, true
+		}
+	}
+
+	return errorPage{}, false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/safepath"
+)
+
+// httpError is an error answered with an HTTP status. Handlers return one, possibly wrapped with %w,
+// instead of writing a response, and writeHTTPError turns it into the status line, header, and body.
+type httpError = httpserver.Error
+
+var (
+	ErrBadRequest          = httpserver.ErrBadRequest
+	ErrUnauthorized        = httpserver.ErrUnauthorized
+	ErrForbidden           = httpserver.ErrForbidden
+	ErrNotFound            = httpserver.ErrNotFound
+	ErrMethodNotAllowed    = httpserver.ErrMethodNotAllowed
+	ErrNotAcceptable       = httpserver.ErrNotAcceptable
+	ErrTimeout             = httpserver.ErrTimeout
+	ErrConflict            = httpserver.ErrConflict
+	ErrGone                = httpserver.ErrGone
+	ErrPreconditionFailed  = httpserver.ErrPreconditionFailed
+	ErrEntityTooLarge      = httpserver.ErrEntityTooLarge
+	ErrUnsupportedMedia    = httpserver.ErrUnsupportedMedia
+	ErrRangeNotSatisfiable = httpserver.ErrRangeNotSatisfiable
+	ErrExpectationFailed   = httpserver.ErrExpectationFailed
+	ErrUnprocessable       = httpserver.ErrUnprocessable
+	ErrUpgradeRequired     = httpserver.ErrUpgradeRequired
+	ErrTooManyRequests     = httpserver.ErrTooManyRequests
+	ErrHeaderTooLarge      = httpserver.ErrHeaderTooLarge
+	ErrInternal            = httpserver.ErrInternal
+	ErrNotImplemented      = httpserver.ErrNotImplemented
+	ErrBadGateway          = httpserver.ErrBadGateway
+	ErrUnavailable         = httpserver.ErrUnavailable
+	ErrGatewayTimeout      = httpserver.ErrGatewayTimeout
+	ErrVersionNotSupported = httpserver.ErrVersionNotSupported
+	ErrInsufficientStorage = httpserver.ErrInsufficientStorage
+)
+
+// errorStatus maps err onto the status it is answered with. Errors that aren't an httpError are
+// classified by what they wrap, what the engine doesn't recognize either is a 500.
+func errorStatus(err error) *httpError {
+	var httpErr *httpError
+
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr
+	case errors.Is(err, safepath.ErrTraversal):
+		return ErrForbidden
+	case errors.Is(err, safepath.ErrInvalid):
+		return ErrBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, os.ErrPermission):
+		return ErrForbidden
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrInsufficientStorage
+	default:
+		return httpserver.StatusOf(err)
+	}
+}
+
+// writeHTTPError will respond to the request that failed with err, with the configured error document for
+// its status or, without one, the text of its detail
+func writeHTTPError(writer *bufio.Writer, err error) {
+	status := errorStatus(err)
+	if status == ErrInternal && !errors.Is(err, ErrInternal) {
+		fmt.Printf("Error handling request: %s\n", err.Error())
+	}
+
+	header := newResponseHeader(status.Status())
+	for _, field := range status.Fields() {
+		header.add(field[0], field[1])
+	}
+
+	page, ok := errorPageFor(status.Code())
+	if !ok {
+		if detail := status.Detail(); detail != "" {
+			header.add("Content-Type", "text/plain").
+				addInt("Content-Length", int64(len(detail))).
+				writeString(writer, detail)
+			return
+		}
+		header.add("Content-Length", "0").write(writer, nil)
+		return
+	}
+	header.add("Content-Type", page.contentType).
+		addInt("Content-Length", int64(len(page.body))).
+		write(writer, page.body)
+}
@@ -0,0 +1,1116 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/fs"
+	"math/big"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/mmap"
+)
+
+// newFilesServer returns the public server with the files endpoints serving a temporary --directory,
+// along with that directory
+func newFilesServer(t *testing.T) (*httpserver.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	*directoryFlag = dir
+	t.Cleanup(func() { *directoryFlag = "" })
+
+	routes = newRoutes()
+	return newPublicServer(), dir
+}
+
+// serveConn serves conn on a goroutine of its own. The returned func closes client, the other end of
+// conn, and waits until the server is done with the connection, its access log record included.
+func serveConn(server *httpserver.Server, conn net.Conn, client net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(done)
+	}()
+	return func() {
+		client.Close()
+		<-done
+	}
+}
+
+// roundTrip sends the request in raw on a new connection to server and returns the response and its body
+func roundTrip(t *testing.T, server *httpserver.Server, raw string) (*http.Response, string) {
+	t.Helper()
+	client, conn := net.Pipe()
+	defer serveConn(server, conn, client)()
+	go io.WriteString(client, raw)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	method, _, _ := strings.Cut(raw, " ")
+	resp, err := http.ReadResponse(bufio.NewReader(client), &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("reading response to %q: %v", raw, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body of the response to %q: %v", raw, err)
+	}
+	return resp, string(body)
+}
+
+func TestFilesEndpoints(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello world"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "a b.txt"), []byte("spaced"), 0644)
+	os.WriteFile(filepath.Join(dir, "big.txt"), []byte(strings.Repeat("compress me\n", 500)), 0644)
+
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		body   string
+		header map[string]string
+	}{
+		{
+			name:   "download",
+			raw:    "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 200,
+			body:   "hello world",
+			header: map[string]string{"Content-Type": "application/octet-stream", "Content-Length": "11", "Accept-Ranges": "bytes"},
+		},
+		{
+			name:   "encoded name",
+			raw:    "GET /files/sub/a%20b.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 200,
+			body:   "spaced",
+		},
+		{
+			name:   "HEAD",
+			raw:    "HEAD /files/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 200,
+			header: map[string]string{"Content-Length": "11"},
+		},
+		{
+			name:   "range",
+			raw:    "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=6-\r\n\r\n",
+			status: 206,
+			body:   "world",
+			header: map[string]string{"Content-Range": "bytes 6-10/11"},
+		},
+		{
+			name:   "unsatisfiable range",
+			raw:    "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=20-\r\n\r\n",
+			status: 416,
+		},
+		{
+			name:   "gzip",
+			raw:    "GET /files/big.txt HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\n\r\n",
+			status: 200,
+			header: map[string]string{"Content-Encoding": "gzip", "Vary": "Accept-Encoding"},
+		},
+		{
+			name:   "missing file",
+			raw:    "GET /files/nope.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 404,
+		},
+		{
+			name:   "traversal",
+			raw:    "GET /files/../files_test.go HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 403,
+		},
+		{
+			name:   "encoded traversal",
+			raw:    "GET /files/%2e%2e%2fsecret HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 400,
+		},
+		{
+			name:   "upload",
+			raw:    "POST /files/new.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nnew",
+			status: 201,
+		},
+		{
+			name:   "upload over an existing file",
+			raw:    "POST /files/hello.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nnew",
+			status: 409,
+		},
+		{
+			name:   "chunked upload",
+			raw:    "POST /files/chunked.txt HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+			status: 201,
+		},
+		{
+			name:   "replace",
+			raw:    "PUT /files/sub/a%20b.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 8\r\n\r\nreplaced",
+			status: 204,
+		},
+		{
+			name:   "delete",
+			raw:    "DELETE /files/new.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 204,
+		},
+		{
+			name:   "delete a missing file",
+			raw:    "DELETE /files/new.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 404,
+		},
+		{
+			name:   "method not allowed",
+			raw:    "PATCH /files/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n",
+			status: 405,
+			header: map[string]string{"Allow": "GET, HEAD, POST, PUT, DELETE, OPTIONS"},
+		},
+	}
+
+	for _, test := range tests {
+		resp, body := roundTrip(t, server, test.raw)
+		if resp.StatusCode != test.status || (test.body != "" && body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, resp.StatusCode, body, test.status, test.body)
+		}
+		for name, value := range test.header {
+			if got := resp.Header.Get(name); got != value {
+				t.Errorf("%s: %s %q, want %q", test.name, name, got, value)
+			}
+		}
+	}
+
+	for name, want := range map[string]string{"chunked.txt": "abc", "sub/a b.txt": "replaced", "hello.txt": "hello world"} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("%s holds %q %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted file still exists: %v", err)
+	}
+}
+
+func TestFilesRevalidation(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello world"), 0644)
+
+	resp, _ := roundTrip(t, server, "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("no validators in %v", resp.Header)
+	}
+
+	tests := []struct {
+		name   string
+		field  string
+		status int
+	}{
+		{name: "matching If-None-Match", field: "If-None-Match: " + etag, status: 304},
+		{name: "weak If-None-Match", field: "If-None-Match: W/" + etag, status: 304},
+		{name: "other If-None-Match", field: `If-None-Match: "other"`, status: 200},
+		{name: "If-Modified-Since", field: "If-Modified-Since: " + modified, status: 304},
+		{name: "stale If-Modified-Since", field: "If-Modified-Since: Mon, 01 Jan 2001 00:00:00 GMT", status: 200},
+		{name: "failed If-Match", field: `If-Match: "other"`, status: 412},
+	}
+	for _, test := range tests {
+		resp, _ := roundTrip(t, server, "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\n"+test.field+"\r\n\r\n")
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+	}
+
+	resp, _ = roundTrip(t, server, "PUT /files/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Match: \"stale\"\r\nContent-Length: 3\r\n\r\nnew")
+	if resp.StatusCode != 412 {
+		t.Errorf("PUT with a stale If-Match: got %d, want 412", resp.StatusCode)
+	}
+	resp, _ = roundTrip(t, server, "PUT /files/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Match: "+etag+"\r\nContent-Length: 3\r\n\r\nnew")
+	if resp.StatusCode != 204 {
+		t.Errorf("PUT with a matching If-Match: got %d, want 204", resp.StatusCode)
+	}
+}
+
+func TestMultipartUploadCleanup(t *testing.T) {
+	server, dir := newFilesServer(t)
+	*maxUploadSizeFlag = 300
+	t.Cleanup(func() { *maxUploadSizeFlag = 0 })
+
+	form := func(second string) string {
+		body := "--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"a.txt\"\r\n\r\nfirst\r\n" +
+			"--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"b.txt\"\r\n\r\n" + second + "\r\n--b--\r\n"
+		return "POST /files/ HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			strconv.FormatInt(int64(len(body)), 16) + "\r\n" + body + "\r\n0\r\n\r\n"
+	}
+
+	// The second part goes past --max-upload-size, so the first one is removed again
+	if resp, body := roundTrip(t, server, form(strings.Repeat("x", 400))); resp.StatusCode != 413 {
+		t.Fatalf("oversized form: got %d %q, want 413", resp.StatusCode, body)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files left behind by the failed upload: %v", entries)
+	}
+
+	if resp, body := roundTrip(t, server, form("second")); resp.StatusCode != 201 {
+		t.Fatalf("form: got %d %q, want 201", resp.StatusCode, body)
+	}
+	for name, want := range map[string]string{"a.txt": "first", "b.txt": "second"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFilesPipelined(t *testing.T) {
+	server, _ := newFilesServer(t)
+
+	// Each request depends on the ones before it, so they only succeed when answered in order
+	requests := []struct {
+		raw    string
+		status int
+		body   string
+	}{
+		{"PUT /files/p.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\none", 201, ""},
+		{"GET /files/p.txt HTTP/1.1\r\nHost: x\r\n\r\n", 200, "one"},
+		{"PUT /files/p.txt HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n4\r\ntwo!\r\n0\r\n\r\n", 204, ""},
+		{"HEAD /files/p.txt HTTP/1.1\r\nHost: x\r\n\r\n", 200, ""},
+		{"GET /files/p.txt HTTP/1.1\r\nHost: x\r\n\r\n", 200, "two!"},
+		{"DELETE /files/p.txt HTTP/1.1\r\nHost: x\r\n\r\n", 204, ""},
+		{"GET /files/p.txt HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n", 404, ""},
+	}
+
+	var raw strings.Builder
+	for _, request := range requests {
+		raw.WriteString(request.raw)
+	}
+	client, conn := net.Pipe()
+	defer serveConn(server, conn, client)()
+	go io.WriteString(client, raw.String())
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+	for i, request := range requests {
+		method, _, _ := strings.Cut(request.raw, " ")
+		resp, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != request.status || (request.body != "" && string(body) != request.body) {
+			t.Errorf("response %d to %q: got %d %q, want %d %q", i+1, request.raw, resp.StatusCode, body, request.status, request.body)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection left open after Connection: close: %v", err)
+	}
+}
+
+func TestChecksumTrailer(t *testing.T) {
+	server, dir := newFilesServer(t)
+	*checksumTrailerFlag = true
+	t.Cleanup(func() { *checksumTrailerFlag = false })
+
+	text := strings.Repeat("compress me\n", 500)
+	os.WriteFile(filepath.Join(dir, "big.txt"), []byte(text), 0644)
+	os.WriteFile(filepath.Join(dir, "hello.bin"), []byte("hello world"), 0644)
+	sum := func(s string) string {
+		digest := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(digest[:])
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		body     string
+		encoding string
+		checksum string
+	}{
+		{"gzip", "GET /files/big.txt HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\n\r\n", text, "gzip", sum(text)},
+		{"unencoded", "GET /files/hello.bin HTTP/1.1\r\nHost: x\r\n\r\n", "hello world", "", sum("hello world")},
+		{"range", "GET /files/hello.bin HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\n\r\n", "hello", "", ""},
+		{"HTTP/1.0", "GET /files/hello.bin HTTP/1.0\r\n\r\n", "hello world", "", ""},
+		{"HEAD", "HEAD /files/hello.bin HTTP/1.1\r\nHost: x\r\n\r\n", "", "", ""},
+	}
+
+	for _, test := range tests {
+		resp, body := roundTrip(t, server, test.raw)
+		if test.encoding == "gzip" {
+			reader, err := gzip.NewReader(strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			decoded, _ := io.ReadAll(reader)
+			body = string(decoded)
+		}
+		if body != test.body || resp.Header.Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s: got %q encoded %q, want %q encoded %q", test.name, body, resp.Header.Get("Content-Encoding"), test.body, test.encoding)
+		}
+		if got := resp.Trailer.Get("X-Checksum"); got != test.checksum {
+			t.Errorf("%s: X-Checksum trailer %q, want %q", test.name, got, test.checksum)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644)
+	corsOrigins = map[string]bool{"https://app.example.com": true}
+	t.Cleanup(func() { corsOrigins, *corsCredentialsFlag = nil, false })
+
+	tests := []struct {
+		name        string
+		raw         string
+		credentials bool
+		status      int
+		origin      string
+		methods     string
+	}{
+		{"preflight", "OPTIONS /files/hello.txt HTTP/1.1\r\nHost: x\r\nOrigin: https://app.example.com\r\nAccess-Control-Request-Method: PUT\r\n\r\n",
+			false, 204, "https://app.example.com", corsMethods},
+		{"preflight from another origin", "OPTIONS /files/hello.txt HTTP/1.1\r\nHost: x\r\nOrigin: https://evil.example.com\r\nAccess-Control-Request-Method: PUT\r\n\r\n",
+			false, 204, "", ""},
+		{"allowed origin", "GET /echo/abc HTTP/1.1\r\nHost: x\r\nOrigin: https://app.example.com\r\n\r\n", false, 200, "https://app.example.com", ""},
+		{"another origin", "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\nOrigin: https://evil.example.com\r\n\r\n", false, 200, "", ""},
+		{"error", "GET /files/missing HTTP/1.1\r\nHost: x\r\nOrigin: https://app.example.com\r\n\r\n", false, 404, "https://app.example.com", ""},
+		{"credentials", "GET /files/hello.txt HTTP/1.1\r\nHost: x\r\nOrigin: https://app.example.com\r\n\r\n", true, 200, "https://app.example.com", ""},
+	}
+
+	for _, test := range tests {
+		*corsCredentialsFlag = test.credentials
+		resp, _ := roundTrip(t, server, test.raw)
+		if resp.StatusCode != test.status || resp.Header.Get("Access-Control-Allow-Origin") != test.origin {
+			t.Errorf("%s: got %d for origin %q, want %d for %q", test.name, resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"), test.status, test.origin)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Methods"); got != test.methods {
+			t.Errorf("%s: Access-Control-Allow-Methods %q, want %q", test.name, got, test.methods)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Credentials") == "true"; got != (test.credentials && test.origin != "") {
+			t.Errorf("%s: Access-Control-Allow-Credentials %q", test.name, resp.Header.Get("Access-Control-Allow-Credentials"))
+		}
+		if !hasToken(resp.Header.Values("Vary"), "Origin") {
+			t.Errorf("%s: Vary %q doesn't list Origin", test.name, resp.Header.Values("Vary"))
+		}
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.MkdirAll(filepath.Join(dir, "site", "docs"), 0755)
+	os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(dir, "site", "docs", "a.txt"), []byte("docs"), 0644)
+	config := filepath.Join(dir, "routes.json")
+	t.Cleanup(func() { currentVirtualHosts.Store(nil) })
+
+	load := func(data string) error {
+		os.WriteFile(config, []byte(data), 0644)
+		hosts, err := loadVirtualHosts(config)
+		if err == nil {
+			currentVirtualHosts.Store(hosts)
+		}
+		return err
+	}
+	if err := load(`{"hosts": [
+		{"names": ["site.localhost"], "routes": [{"prefix": "/", "static": "site"}, {"prefix": "/docs", "static": "site/docs"}, {"prefix": "/say", "echo": true}]},
+		{"names": ["*.wild.localhost"], "routes": [{"prefix": "/", "echo": true}]}
+	]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{"static", "site.localhost", "/", 200, "home"},
+		{"longer prefix", "site.localhost:4221", "/docs/a.txt", 200, "docs"},
+		{"echo", "site.localhost", "/say/hello", 200, "hello"},
+		{"wildcard", "a.wild.localhost", "/hi", 200, "hi"},
+		{"wildcard parent", "wild.localhost", "/echo/hi", 200, "hi"},
+		{"unknown host", "other.localhost", "/echo/hi", 200, "hi"},
+		{"no route", "site.localhost", "/echo/hi", 404, ""},
+	}
+	for _, test := range tests {
+		resp, body := roundTrip(t, server, "GET "+test.path+" HTTP/1.1\r\nHost: "+test.host+"\r\n\r\n")
+		if resp.StatusCode != test.status || (test.body != "" && body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, resp.StatusCode, body, test.status, test.body)
+		}
+	}
+
+	// A reload replaces the routes, a file that fails to load keeps the ones loaded before
+	if err := load(`{"hosts": [{"names": ["site.localhost"], "routes": [{"prefix": "/", "echo": true}]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{
+		`{"hosts": [{"names": ["site.localhost"], "routes": [{"prefix": "/", "echo": true, "static": "site"}]}]}`,
+		`{"hosts": [{"names": ["site.localhost"], "routes": [{"prefix": "/", "static": "missing"}]}]}`,
+		`{"hosts": [{"names": ["site.localhost", "SITE.localhost"]}]}`,
+		`{"hosts": [{"names": ["site.localhost"], "routes": [{"prefix": "/", "proxy": "ftp://x"}]}]}`,
+		`{"hosts": [{"names": ["site.localhost"]}], "extra": 1}`,
+	} {
+		if err := load(bad); err == nil {
+			t.Errorf("loading %s succeeded", bad)
+		}
+	}
+	if resp, body := roundTrip(t, server, "GET /hello HTTP/1.1\r\nHost: site.localhost\r\n\r\n"); resp.StatusCode != 200 || body != "hello" {
+		t.Errorf("after reloading got %d %q, want 200 \"hello\"", resp.StatusCode, body)
+	}
+}
+
+// BenchmarkFileDownload measures the throughput of downloading a large file from the files endpoints
+// over a loopback TCP connection, where whole and ranged bodies are sent with sendfile
+func BenchmarkFileDownload(b *testing.B) {
+	const size = 64 << 20
+	dir := b.TempDir()
+	*directoryFlag = dir
+	b.Cleanup(func() { *directoryFlag = "" })
+	if err := os.WriteFile(filepath.Join(dir, "big.bin"), make([]byte, size), 0644); err != nil {
+		b.Fatal(err)
+	}
+	routes = newRoutes()
+
+	for _, bench := range []struct {
+		name    string
+		options fileServeOptions
+		rangeOf string
+		length  int64
+	}{
+		{"whole", fileServeOptions{}, "", size},
+		{"range", fileServeOptions{}, "bytes=1024-", size - 1024},
+		{"mmap", fileServeOptions{mmap: true}, "", size},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			filesServeOptions = bench.options
+			b.Cleanup(func() { filesServeOptions = fileServeOptions{} })
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			go newPublicServer().Serve(l)
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			reader := bufio.NewReaderSize(conn, 64<<10)
+			raw := "GET /files/big.bin HTTP/1.1\r\nHost: x\r\n\r\n"
+			if bench.rangeOf != "" {
+				raw = "GET /files/big.bin HTTP/1.1\r\nHost: x\r\nRange: " + bench.rangeOf + "\r\n\r\n"
+			}
+
+			b.SetBytes(bench.length)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				io.WriteString(conn, raw)
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n, err := io.Copy(io.Discard, resp.Body); n != bench.length || err != nil {
+					b.Fatalf("read %d bytes of the body: %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCachedProtectedFiles(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "x.txt"), []byte("secret"), 0644)
+	*cacheFlag = "files/"
+	apiKeys = map[string]*apiKey{"k1": {quota: apiKeyQuota{Name: "test"}}}
+	t.Cleanup(func() {
+		*cacheFlag, apiKeys = "", nil
+		respCache.purge("/", true)
+	})
+
+	requests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"without a key", "GET /files/x.txt HTTP/1.1\r\nHost: x\r\n\r\n", 401},
+		{"with a key", "GET /files/x.txt HTTP/1.1\r\nHost: x\r\nX-API-Key: k1\r\n\r\n", 200},
+		{"without a key after a cached response", "GET /files/x.txt HTTP/1.1\r\nHost: x\r\n\r\n", 401},
+	}
+	for _, request := range requests {
+		if resp, body := roundTrip(t, server, request.raw); resp.StatusCode != request.status {
+			t.Errorf("%s: got %d %q, want %d", request.name, resp.StatusCode, body, request.status)
+		}
+	}
+	if usage := apiKeys["k1"].snapshot(); usage.DailyRequests != 1 {
+		t.Errorf("key was counted for %d requests, want 1", usage.DailyRequests)
+	}
+}
+
+func TestCachedSSOFiles(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "x.txt"), []byte("secret"), 0644)
+	*cacheFlag = "files/"
+	t.Cleanup(func() {
+		*cacheFlag, oidc = "", nil
+		respCache.purge("/", true)
+	})
+
+	// A response cached before SSO protects the files is never served without a session
+	raw := "GET /files/x.txt HTTP/1.1\r\nHost: x\r\n\r\n"
+	if resp, _ := roundTrip(t, server, raw); resp.StatusCode != 200 {
+		t.Fatalf("got %d before SSO, want 200", resp.StatusCode)
+	}
+	oidc = &oidcProvider{}
+	if resp, body := roundTrip(t, server, raw); resp.StatusCode != 401 {
+		t.Errorf("got %d %q without a session, want 401", resp.StatusCode, body)
+	}
+}
+
+func TestOIDCLoginState(t *testing.T) {
+	server, _ := newFilesServer(t)
+	codec, _ := newCookieCodec([]string{"test secret"}, false)
+	cookies, oidc = codec, &oidcProvider{AuthorizationEndpoint: "https://idp.example.com/authorize"}
+	t.Cleanup(func() { cookies, oidc = nil, nil })
+
+	resp, _ := roundTrip(t, server, "GET /files/x.txt HTTP/1.1\r\nHost: x\r\nAccept: text/html\r\n\r\n")
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != 302 || err != nil {
+		t.Fatalf("got %d to %q, want a redirect to the IdP", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	state := location.Query().Get("state")
+	login, _, _ := strings.Cut(resp.Header.Get("Set-Cookie"), ";")
+	if !strings.HasPrefix(login, oidcLoginCookie+"=") {
+		t.Fatalf("redirect sets cookie %q, want the login cookie", login)
+	}
+
+	// The callback only gets past the state check with the login cookie of the browser sent to the IdP
+	callback := "GET /oidc/callback?state=" + state + "&error=access_denied HTTP/1.1\r\nHost: x\r\n"
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"no cookie", callback + "\r\n", 400},
+		{"other state", "GET /oidc/callback?state=other&error=access_denied HTTP/1.1\r\nHost: x\r\nCookie: " + login + "\r\n\r\n", 400},
+		{"forged cookie", callback + "Cookie: " + oidcLoginCookie + "=e30.AAAA\r\n\r\n", 400},
+		{"login cookie", callback + "Cookie: " + login + "\r\n\r\n", 403},
+	}
+	for _, test := range tests {
+		if resp, _ := roundTrip(t, server, test.raw); resp.StatusCode != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestProtectedStatic(t *testing.T) {
+	static := t.TempDir()
+	os.WriteFile(filepath.Join(static, "index.html"), []byte("members only"), 0644)
+	*staticFlag, *protectStaticFlag = static, true
+	apiKeys = map[string]*apiKey{"k1": {quota: apiKeyQuota{Name: "test"}}}
+	t.Cleanup(func() { *staticFlag, *protectStaticFlag, apiKeys = "", false, nil })
+	server, _ := newFilesServer(t)
+
+	if resp, _ := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"); resp.StatusCode != 401 {
+		t.Errorf("static file without a key got %d, want 401", resp.StatusCode)
+	}
+	if resp, body := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: x\r\nX-API-Key: k1\r\n\r\n"); resp.StatusCode != 200 || body != "members only" {
+		t.Errorf("static file with a key got %d %q, want 200", resp.StatusCode, body)
+	}
+}
+
+func TestStatCacheUnwatchedRoots(t *testing.T) {
+	static := t.TempDir()
+	os.WriteFile(filepath.Join(static, "page.txt"), []byte("first"), 0644)
+	*staticFlag = static
+	server, dir := newFilesServer(t)
+	infoCache, watchedRoot = &fileInfoCache{entries: map[string]fs.FileInfo{}}, dir
+	t.Cleanup(func() { *staticFlag, infoCache, watchedRoot = "", nil, "" })
+
+	// The watcher only sees the files directory, so static files are never served from stale file info
+	get := func() string {
+		_, body := roundTrip(t, server, "GET /page.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+		return body
+	}
+	get()
+	os.WriteFile(filepath.Join(static, "page.txt"), []byte("second version"), 0644)
+	if body := get(); body != "second version" {
+		t.Errorf("edited static file served as %q", body)
+	}
+	if _, ok := infoCache.get(filepath.Join(static, "page.txt")); ok {
+		t.Error("file info of a static file was cached")
+	}
+}
+
+func TestErrorPageOverDetail(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	raw := "DELETE /files/sub HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	if resp, body := roundTrip(t, server, raw); resp.StatusCode != 409 || body != "directories can't be deleted" {
+		t.Errorf("without an error page got %d %q, want the detail", resp.StatusCode, body)
+	}
+
+	// A configured error document wins over the detail of the error
+	errorPages["4xx"] = errorPage{contentType: "text/html", body: []byte("<h1>Client error</h1>")}
+	t.Cleanup(func() { delete(errorPages, "4xx") })
+	if resp, body := roundTrip(t, server, raw); resp.StatusCode != 409 || body != "<h1>Client error</h1>" || resp.Header.Get("Content-Type") != "text/html" {
+		t.Errorf("with an error page got %d %q, want the page", resp.StatusCode, body)
+	}
+}
+
+func TestDeleteInternalFiles(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.MkdirAll(filepath.Join(dir, tusDirName), 0755)
+	internal := []string{"report.txt.~1~", uploadTempPrefix + "123", tusDirName + "/abc.bin"}
+	for _, name := range internal {
+		os.WriteFile(filepath.Join(dir, name), []byte("keep"), 0644)
+	}
+
+	for _, name := range internal {
+		if resp, _ := roundTrip(t, server, "DELETE /files/"+name+" HTTP/1.1\r\nHost: x\r\n\r\n"); resp.StatusCode != 404 {
+			t.Errorf("DELETE %s got %d, want 404", name, resp.StatusCode)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("DELETE %s removed it", name)
+		}
+	}
+}
+
+func TestTusUploadAuth(t *testing.T) {
+	*tusPathFlag = "uploads"
+	apiKeys = map[string]*apiKey{"k1": {quota: apiKeyQuota{Name: "test"}}}
+	t.Cleanup(func() { *tusPathFlag, apiKeys = "", nil })
+	server, _ := newFilesServer(t)
+
+	create := "POST /uploads/ HTTP/1.1\r\nHost: x\r\nTus-Resumable: 1.0.0\r\nUpload-Length: 5\r\nContent-Length: 0\r\n"
+	if resp, _ := roundTrip(t, server, create+"\r\n"); resp.StatusCode != 401 {
+		t.Errorf("creating an upload without a key got %d, want 401", resp.StatusCode)
+	}
+	resp, _ := roundTrip(t, server, create+"X-API-Key: k1\r\n\r\n")
+	if resp.StatusCode != 201 {
+		t.Errorf("creating an upload with a key got %d, want 201", resp.StatusCode)
+	}
+	if _, err := http.ParseTime(resp.Header.Get("Upload-Expires")); err != nil || !strings.HasSuffix(resp.Header.Get("Upload-Expires"), " GMT") {
+		t.Errorf("Upload-Expires %q isn't an HTTP date", resp.Header.Get("Upload-Expires"))
+	}
+
+	// Patching an upload that doesn't exist leaves no lock behind
+	id := strings.Repeat("ab", 16)
+	patch := "PATCH /uploads/" + id + " HTTP/1.1\r\nHost: x\r\nX-API-Key: k1\r\nTus-Resumable: 1.0.0\r\n" +
+		"Content-Type: application/offset+octet-stream\r\nUpload-Offset: 0\r\nContent-Length: 0\r\n\r\n"
+	if resp, _ := roundTrip(t, server, patch); resp.StatusCode != 404 {
+		t.Errorf("patching an unknown upload got %d, want 404", resp.StatusCode)
+	}
+	if _, ok := tusLocks.Load(id); ok {
+		t.Error("patching an unknown upload left a lock for it")
+	}
+}
+
+func TestTusQuotaAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	tusDir := filepath.Join(dir, tusDirName)
+	os.MkdirAll(tusDir, 0755)
+	os.WriteFile(filepath.Join(dir, "other.txt"), []byte("12345"), 0644)
+	id := strings.Repeat("cd", 16)
+	writeTusUpload(tusDir, id, tusUpload{Length: 100, Expires: time.Now().Add(time.Hour)})
+	os.WriteFile(tusDataPath(tusDir, id), []byte("partial"), 0644)
+
+	quota, err := newStorageQuota(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesQuota = quota
+	t.Cleanup(func() { filesQuota = nil })
+
+	reserveTusUploads(tusDir)
+	if used := quota.used.Load(); used != 105 {
+		t.Errorf("quota used %d bytes after the restart, want 105", used)
+	}
+	// Expiring the upload releases its whole length, leaving only the other file
+	quota.release(100)
+	if used := quota.used.Load(); used != 5 {
+		t.Errorf("quota used %d bytes once the upload expired, want 5", used)
+	}
+}
+
+func TestProxyInternalRedirect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accel":
+			w.Header().Set("X-Accel-Redirect", "/files/report.txt")
+		case "/sendfile":
+			w.Header().Set("X-Sendfile", "report.txt")
+		case "/escape":
+			w.Header().Set("X-Sendfile", "../secret.txt")
+		}
+		io.WriteString(w, "from upstream")
+	}))
+
+	upstream, err := parseProxyRoutes("/api=>http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyRoutes = upstream
+	t.Cleanup(func() { proxyRoutes = nil })
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "report.txt"), []byte("internal report"), 0644)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/api/accel", 200, "internal report"},
+		{"/api/sendfile", 200, "internal report"},
+		{"/api/plain", 200, "from upstream"},
+		{"/api/escape", 500, ""},
+	}
+	for _, test := range tests {
+		resp, body := roundTrip(t, server, "GET "+test.path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		if resp.StatusCode != test.status || (test.body != "" && body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.path, resp.StatusCode, body, test.status, test.body)
+		}
+		if resp.Header.Get("X-Accel-Redirect") != "" || resp.Header.Get("X-Sendfile") != "" {
+			t.Errorf("%s: internal redirect fields reached the client", test.path)
+		}
+	}
+}
+
+func TestProxyDotSegments(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	targets := make(chan string, 10)
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets <- r.RequestURI
+	}))
+
+	upstream, err := parseProxyRoutes("/api=>http://" + l.Addr().String() + "/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyRoutes = upstream
+	t.Cleanup(func() { proxyRoutes = nil })
+	server, _ := newFilesServer(t)
+
+	tests := []struct {
+		target string
+		status int
+		sent   string
+	}{
+		{"/api/x?q=1", 200, "/v1/x?q=1"},
+		{"/api/../secret", 400, ""},
+		{"/api/%2e%2e/secret", 400, ""},
+		{"/api/a/%2E/b", 400, ""},
+		{"/api/./x", 400, ""},
+	}
+	for _, test := range tests {
+		resp, _ := roundTrip(t, server, "GET "+test.target+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got %d, want %d", test.target, resp.StatusCode, test.status)
+		}
+		if test.sent != "" {
+			if sent := <-targets; sent != test.sent {
+				t.Errorf("%s: upstream got %s, want %s", test.target, sent, test.sent)
+			}
+		}
+	}
+	if len(targets) != 0 {
+		t.Errorf("upstream got %s for a refused target", <-targets)
+	}
+}
+
+func TestResponseCacheNegotiation(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.WriteFile(filepath.Join(dir, "x.txt"), []byte("hello world"), 0644)
+	*cacheFlag = "files/"
+	t.Cleanup(func() {
+		*cacheFlag = ""
+		respCache.purge("/", true)
+	})
+
+	// A cached whole response never answers a range or conditional request
+	resp, _ := roundTrip(t, server, "GET /files/x.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	etag := resp.Header.Get("ETag")
+	if resp, body := roundTrip(t, server, "GET /files/x.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\n\r\n"); resp.StatusCode != 206 || body != "hello" {
+		t.Errorf("range request got %d %q, want 206 \"hello\"", resp.StatusCode, body)
+	}
+	if resp, _ := roundTrip(t, server, "GET /files/x.txt HTTP/1.1\r\nHost: x\r\nIf-None-Match: "+etag+"\r\n\r\n"); resp.StatusCode != 304 {
+		t.Errorf("conditional request got %d, want 304", resp.StatusCode)
+	}
+
+	// Listings in both formats are cached side by side
+	for _, accept := range []string{"application/json", "text/html", "application/json", "text/html"} {
+		resp, _ := roundTrip(t, server, "GET /files/ HTTP/1.1\r\nHost: x\r\nAccept: "+accept+"\r\n\r\n")
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), accept) {
+			t.Errorf("listing for Accept %s got Content-Type %s", accept, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestPurgeFilesRoot(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.MkdirAll(filepath.Join(dir, "a"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "x.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "y.txt"), []byte("y"), 0644)
+	*cacheFlag = "files/"
+	t.Cleanup(func() {
+		*cacheFlag = ""
+		respCache.purge("/", true)
+	})
+
+	for _, path := range []string{"/files/a/x.txt", "/files/y.txt"} {
+		roundTrip(t, server, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+	}
+	if respCache.recent.Len() != 2 {
+		t.Fatalf("cache holds %d responses, want 2", respCache.recent.Len())
+	}
+
+	// A change notified for the root, as after the watcher's queue overflowed, purges every file
+	purgeFilesInvalidator(dir)(dir)
+	if respCache.recent.Len() != 0 {
+		t.Errorf("cache holds %d responses after a change to the root, want none", respCache.recent.Len())
+	}
+}
+
+func TestResponseCacheLimits(t *testing.T) {
+	server, _ := newFilesServer(t)
+	*cacheFlag = "echo/"
+	maxEntries, maxBytes := *cacheMaxEntriesFlag, *cacheMaxBytesFlag
+	*cacheMaxEntriesFlag = 3
+	t.Cleanup(func() {
+		*cacheFlag, *cacheMaxEntriesFlag, *cacheMaxBytesFlag = "", maxEntries, maxBytes
+		respCache.purge("/", true)
+	})
+
+	get := func(path string) {
+		roundTrip(t, server, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+	}
+	cached := func(path string) bool {
+		_, ok := respCache.lookup(path+"|identity", &Request{Header: httpserver.Header{}})
+		return ok
+	}
+
+	// The least recently used response is evicted once more are cached than the limit allows
+	for _, path := range []string{"/echo/a", "/echo/b", "/echo/c"} {
+		get(path)
+	}
+	cached("/echo/a")
+	get("/echo/d")
+	if !cached("/echo/a") || cached("/echo/b") || !cached("/echo/d") || respCache.recent.Len() != 3 {
+		t.Errorf("cache holds %d responses after evicting, want /echo/a, /echo/c, and /echo/d", respCache.recent.Len())
+	}
+
+	// As is the least recently used response that doesn't fit the byte limit
+	*cacheMaxEntriesFlag, *cacheMaxBytesFlag = 100, respCache.bytes
+	get("/echo/e")
+	if cached("/echo/c") || !cached("/echo/e") || respCache.bytes > *cacheMaxBytesFlag {
+		t.Errorf("cache holds %d bytes, want at most %d", respCache.bytes, *cacheMaxBytesFlag)
+	}
+
+	// Expired responses are swept whether they are asked for again or not
+	if swept := respCache.sweep(time.Now().Add(time.Hour)); swept != 3 || len(respCache.entries) != 0 || respCache.bytes != 0 {
+		t.Errorf("sweep dropped %d responses, leaving %d keys and %d bytes", swept, len(respCache.entries), respCache.bytes)
+	}
+}
+
+func TestArchiveSkipsInternalFiles(t *testing.T) {
+	server, dir := newFilesServer(t)
+	os.MkdirAll(filepath.Join(dir, "docs"), 0755)
+	for _, name := range []string{"report.txt", "report.txt.~1~", uploadTempPrefix + "123"} {
+		os.WriteFile(filepath.Join(dir, "docs", name), []byte(name), 0644)
+	}
+
+	resp, body := roundTrip(t, server, "GET /files/docs?format=zip HTTP/1.1\r\nHost: x\r\n\r\n")
+	archive, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("got %d with an unreadable zip: %v", resp.StatusCode, err)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if len(names) != 1 || names[0] != "report.txt" {
+		t.Errorf("archive holds %q, want only report.txt", names)
+	}
+}
+
+func TestArchiveFilename(t *testing.T) {
+	server, dir := newFilesServer(t)
+	for _, name := range []string{"plain", "résumé", `say "hi"`} {
+		os.MkdirAll(filepath.Join(dir, name), 0755)
+		resp, _ := roundTrip(t, server, "GET /files/"+url.PathEscape(name)+"?format=zip HTTP/1.1\r\nHost: x\r\n\r\n")
+		disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" || params["filename"] != name+".zip" {
+			t.Errorf("%s: Content-Disposition %q, want an attachment named %q", name, resp.Header.Get("Content-Disposition"), name+".zip")
+		}
+	}
+}
+
+// newTestCertificate returns a certificate for template signed by parent, self-signed without one, along
+// with its key
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// writePEM writes the certificate, and its key when set, PEM encoded to path
+func writePEM(t *testing.T, path string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if key != nil {
+		der, _ := x509.MarshalECPrivateKey(key)
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+	}
+	os.WriteFile(path, data, 0600)
+}
+
+func TestTLSDetails(t *testing.T) {
+	server, dir := newFilesServer(t)
+	ca, caKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	serverCert, serverKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	clientCert, clientKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca, caKey)
+	strangerCert, strangerKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, nil, nil)
+
+	certFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "ca.pem")
+	writePEM(t, certFile, serverCert, serverKey)
+	writePEM(t, caFile, ca, nil)
+	config, err := loadTLSConfig(certFile, certFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string, 1)
+	accessLog, _ = newLineSink("text", "{tls_version} {sni} {client_subject} {tls_cipher}", func(line string) { lines <- line })
+	t.Cleanup(func() { accessLog = nil })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tests := []struct {
+		name   string
+		client *tls.Certificate
+		line   string
+	}{
+		{"no certificate", nil, "TLS 1.3 localhost - TLS_"},
+		{"trusted certificate", &tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}, "TLS 1.3 localhost CN=alice TLS_"},
+		{"untrusted certificate", &tls.Certificate{Certificate: [][]byte{strangerCert.Raw}, PrivateKey: strangerKey}, ""},
+	}
+
+	for _, test := range tests {
+		client, conn := net.Pipe()
+		wait := serveConn(server, tls.Server(conn, config), client)
+		// The certificate is sent even when the server doesn't list its issuer among the acceptable CAs
+		certificate := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if test.client == nil {
+				return &tls.Certificate{}, nil
+			}
+			return test.client, nil
+		}
+		tlsClient := tls.Client(client, &tls.Config{ServerName: "localhost", RootCAs: roots, GetClientCertificate: certificate})
+		tlsClient.SetDeadline(time.Now().Add(5 * time.Second))
+		go io.WriteString(tlsClient, "GET /echo/tls HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(tlsClient), nil)
+		if test.line == "" {
+			if err == nil {
+				t.Errorf("%s: got %d, want the handshake to fail", test.name, resp.StatusCode)
+			}
+			wait()
+			continue
+		}
+		if err != nil {
+			wait()
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		wait()
+
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, test.line) {
+				t.Errorf("%s: logged %q, want it to start with %q", test.name, line, test.line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: no access log line", test.name)
+		}
+	}
+}
+
+func TestHashedAssetName(t *testing.T) {
+	tests := []struct {
+		name   string
+		hashed bool
+	}{
+		{"app.3f9a2c.js", true},
+		{"app-3f9a2c1b.min.css", true},
+		{"app.decade.js", false},
+		{"report-20240101.pdf", false},
+		{"photo.abcdef.1a2b3c4d.jpg", true},
+		{"app.js", false},
+	}
+	for _, test := range tests {
+		if got := isHashedAssetName(test.name); got != test.hashed {
+			t.Errorf("isHashedAssetName(%q) = %v, want %v", test.name, got, test.hashed)
+		}
+	}
+}
+
+func TestWriteMappedTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.bin")
+	os.WriteFile(path, make([]byte, 4*mmapMinSize), 0644)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, unmap, err := mmap.Map(file, 4*mmapMinSize)
+	if err != nil {
+		t.Skipf("can't map files here: %v", err)
+	}
+	defer unmap()
+
+	// Reading the pages the file lost faults, which fails the write instead of crashing the test
+	os.Truncate(path, 0)
+	if err := writeMapped(bufio.NewWriter(&bytes.Buffer{}), path, data); err == nil {
+		t.Error("writing a truncated mapping succeeded")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/mmap"
+)
+
+// mmapMinSize is the smallest file served from a memory mapping, below it the mapping costs more than it saves
+const mmapMinSize = 64 * 1024
+
+// fileServeOptions are the per-mount settings used when serving files
+type fileServeOptions struct {
+	// mmap serves files of at least mmapMinSize bytes from a read-only memory mapping
+	mmap bool
+	// immutable marks content-hashed files (app.3f9a2c.js) as cacheable forever
+	immutable bool
+	// detectType sends the Content-Type of the file's extension instead of application/octet-stream
+	detectType bool
+}
+
+// contentType returns the Content-Type a file named name is served with
+func (o fileServeOptions) contentType(name string) string {
+	if o.detectType {
+		if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// filesServeOptions are the serving options of the files directory
+var filesServeOptions fileServeOptions
+
+// writeFileBody writes the length bytes of file starting at offset to writer, failing when they can't all
+// be sent, like when the file is truncated while it is being sent
+func writeFileBody(writer *bufio.Writer, file *os.File, offset int64, length int64, options fileServeOptions) error {
+	if options.mmap && length >= mmapMinSize {
+		data, unmap, err := mmap.Map(file, offset+length)
+		if err == nil {
+			defer unmap()
+			return writeMapped(writer, file.Name(), data[offset:])
+		}
+		if !errors.Is(err, mmap.ErrUnsupported) {
+			fmt.Printf("Error mapping %s, falling back to reads: %s\n", file.Name(), err.Error())
+		}
+	}
+
+	// The body goes through ReadFrom, which the engine hands to the connection once the buffered head is
+	// out. A TCP connection sends a file limited like this with sendfile, without copying it at all.
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := writer.ReadFrom(io.LimitReader(file, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writeMapped writes data mapped from the file called name to writer. Pages the file was truncated out
+// of fault when they are read, which fails this write instead of crashing the process with SIGBUS.
+func writeMapped(writer *bufio.Writer, name string, data []byte) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			fmt.Printf("Error reading the mapping of %s, it was truncated while being sent\n", name)
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	_, err = writer.Write(data)
+	return err
+}
+
+// copyBuffers recycles the --copy-buffer-size buffers used to move file contents
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, *copyBufferFlag)
+		return &buf
+	},
+}
+
+// copyN is io.CopyN through a pooled buffer of --copy-buffer-size bytes. The ReaderFrom and WriterTo
+// shortcuts are hidden from io.CopyBuffer on purpose, they would pick their own buffer size instead.
+func copyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	written, err := io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{io.LimitReader(src, n)}, *buf)
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// copyAll is io.Copy through a pooled buffer of --copy-buffer-size bytes
+func copyAll(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"sync"
+)
+
+// responseHeader builds the status line and header fields of a response in a reusable buffer, so
+// responses are serialized without fmt.Sprintf and the allocations that come with it
+type responseHeader struct {
+	buf []byte
+}
+
+// headerPool recycles response headers between responses
+var headerPool = sync.Pool{
+	New: func() any { return &responseHeader{buf: make([]byte, 0, 512)} },
+}
+
+// newResponseHeader returns an empty header for a response with status, like "200 OK"
+func newResponseHeader(status string) *responseHeader {
+	h := headerPool.Get().(*responseHeader)
+	h.buf = append(h.buf[:0], "HTTP/1.1 "...)
+	h.buf = append(h.buf, status...)
+	h.buf = append(h.buf, "\r\n"...)
+	return h
+}
+
+// add appends a header field, writing name in canonical form and replacing any CR or LF in value so a
+// value taken from the request can't inject header fields of its own
+func (h *responseHeader) add(name string, value string) *responseHeader {
+	h.buf = appendCanonicalKey(h.buf, name)
+	h.buf = append(h.buf, ": "...)
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '\r' || c == '\n' {
+			h.buf = append(h.buf, ' ')
+		} else {
+			h.buf = append(h.buf, c)
+		}
+	}
+	h.buf = append(h.buf, "\r\n"...)
+	return h
+}
+
+// addInt appends a header field with a decimal integer value
+func (h *responseHeader) addInt(name string, value int64) *responseHeader {
+	h.buf = appendCanonicalKey(h.buf, name)
+	h.buf = append(h.buf, ": "...)
+	h.buf = strconv.AppendInt(h.buf, value, 10)
+	h.buf = append(h.buf, "\r\n"...)
+	return h
+}
+
+// write will terminate the header and write it together with body, which may be empty when the body is
+// streamed afterwards. Both land in writer's buffer so a short response reaches the connection in a single
+// write when the connection is flushed. The header must not be used afterwards.
+func (h *responseHeader) write(writer *bufio.Writer, body []byte) {
+	h.buf = append(h.buf, "\r\n"...)
+	writer.Write(h.buf)
+	writer.Write(body)
+	h.release()
+}
+
+// writeString is write for a body held in a string
+func (h *responseHeader) writeString(writer *bufio.Writer, body string) {
+	h.buf = append(h.buf, "\r\n"...)
+	writer.Write(h.buf)
+	writer.WriteString(body)
+	h.release()
+}
+
+// release returns the header to the pool, unless one huge header would pin a large buffer there
+func (h *responseHeader) release() {
+	if cap(h.buf) <= 4096 {
+		headerPool.Put(h)
+	}
+}
+
+// appendCanonicalKey appends name with the first letter and every letter following a hyphen in upper case
+// and the rest in lower case, like textproto.CanonicalMIMEHeaderKey but without allocating
+func appendCanonicalKey(buf []byte, name string) []byte {
+	upper := true
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case upper && 'a' <= c && c <= 'z':
+			c -= 'a' - 'A'
+		case !upper && 'A' <= c && c <= 'Z':
+			c += 'a' - 'A'
+		}
+		buf = append(buf, c)
+		upper = c == '-'
+	}
+	return buf
+}
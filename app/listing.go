@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+//go:embed listing.html
+var defaultListingTemplate string
+
+// listingTemplate renders HTML directory listings, operators can replace it with --listing-template
+var listingTemplate = template.Must(template.New("listing").Parse(defaultListingTemplate))
+
+// htmlListing is the data passed to the listing template
+type htmlListing struct {
+	Path    string
+	Sort    string
+	Order   string
+	Entries []htmlListingEntry
+}
+
+// htmlListingEntry is a listing entry along with the relative link to it
+type htmlListingEntry struct {
+	listingEntry
+	URL string
+}
+
+// NextOrder returns the order a column's sort link should use, toggling it for the current sort column
+func (l htmlListing) NextOrder(column string) string {
+	if l.Sort == column && l.Order == "asc" {
+		return "desc"
+	}
+	return "asc"
+}
+
+// listingEntry describes a single file or directory in a JSON listing
+type listingEntry struct {
+	Name  string    `json:"name"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Type  string    `json:"type"`
+	ETag  string    `json:"etag,omitempty"`
+}
+
+// handleListingRequest will respond with a JSON listing of the directory at dirPath
+//
+// Supported query parameters are recursive=true to descend into subdirectories and
+// glob=<pattern> to only include entries whose base name matches the pattern.
+func handleListingRequest(writer *bufio.Writer, head *Request, dirPath string, query url.Values) error {
+	encoder, err := httpserver.NegotiateEncoding(head)
+	if err != nil {
+		return err
+	}
+
+	pattern := query.Get("glob")
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return ErrBadRequest
+	}
+
+	recursive := query.Get("recursive") == "true"
+	cacheKey := fmt.Sprintf("%s?recursive=%t&glob=%s", filepath.Clean(dirPath), recursive, pattern)
+
+	body, ok := listingCache.get(cacheKey)
+	if !ok {
+		entries, err := listDirectory(dirPath, recursive, pattern)
+		if err != nil {
+			return err
+		}
+
+		body, err = json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		listingCache.put(cacheKey, body)
+	}
+
+	// The Accept field picks JSON or HTML for the same target
+	header := newResponseHeader("200 OK").add("Content-Type", "application/json").add("Vary", "Accept")
+	return writeEncoded(writer, header, encoder, body)
+}
+
+// handleHTMLListingRequest will respond with an HTML listing of the directory at dirPath requested as target
+//
+// Entries can be ordered with sort=name|size|mtime and order=asc|desc.
+func handleHTMLListingRequest(writer *bufio.Writer, head *Request, dirPath string, target string, query url.Values) error {
+	// Entry links are relative, so they only resolve correctly below a URL ending in a slash
+	if !strings.HasSuffix(target, "/") {
+		newResponseHeader("301 Moved Permanently").
+			add("Location", target+"/").
+			add("Content-Length", "0").
+			write(writer, nil)
+		return nil
+	}
+
+	encoder, err := httpserver.NegotiateEncoding(head)
+	if err != nil {
+		return err
+	}
+
+	entries, err := listDirectory(dirPath, false, "")
+	if err != nil {
+		return err
+	}
+
+	listing := htmlListing{Path: target, Sort: query.Get("sort"), Order: query.Get("order")}
+	if listing.Order != "desc" {
+		listing.Order = "asc"
+	}
+
+	sortListing(entries, listing.Sort, listing.Order == "desc")
+
+	for _, entry := range entries {
+		link := url.PathEscape(entry.Name)
+		if entry.Type == "dir" {
+			link += "/"
+		}
+		listing.Entries = append(listing.Entries, htmlListingEntry{listingEntry: entry, URL: link})
+	}
+
+	var body bytes.Buffer
+	if err := listingTemplate.Execute(&body, listing); err != nil {
+		return fmt.Errorf("rendering listing: %w", err)
+	}
+
+	header := newResponseHeader("200 OK").add("Content-Type", "text/html; charset=utf-8").add("Vary", "Accept")
+	return writeEncoded(writer, header, encoder, body.Bytes())
+}
+
+// sortListing orders entries by the named column, falling back to the name
+func sortListing(entries []listingEntry, column string, descending bool) {
+	slices.SortStableFunc(entries, func(a, b listingEntry) int {
+		var c int
+		switch column {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "mtime":
+			c = a.Mtime.Compare(b.Mtime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if descending {
+			return -c
+		}
+		return c
+	})
+}
+
+// loadListingTemplate replaces the embedded listing template with the html/template file at path
+func loadListingTemplate(path string) error {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return err
+	}
+	listingTemplate = tmpl
+	return nil
+}
+
+// listDirectory collects the entries of root, optionally descending into subdirectories and filtering by pattern
+func listDirectory(root string, recursive bool, pattern string) ([]listingEntry, error) {
+	entries := []listingEntry{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		if d.IsDir() && d.Name() == tusDirName {
+			return filepath.SkipDir
+		}
+
+		if d.Type().IsRegular() && isInternalFile(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if pattern == "" || matchesGlob(pattern, d.Name()) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, newListingEntry(filepath.ToSlash(rel), info))
+		}
+
+		if d.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// newListingEntry builds the listing entry for the file described by info
+func newListingEntry(name string, info fs.FileInfo) listingEntry {
+	entry := listingEntry{
+		Name:  name,
+		Size:  info.Size(),
+		Mtime: info.ModTime().UTC(),
+		Type:  "file",
+	}
+
+	if info.IsDir() {
+		entry.Type = "dir"
+	} else {
+		entry.ETag = fileETag(info)
+	}
+
+	return entry
+}
+
+// matchesGlob reports whether name matches the (already validated) glob pattern
+func matchesGlob(pattern string, name string) bool {
+	matched, _ := filepath.Match(pattern, name)
+	return matched
+}
+
+// fileETag returns a strong entity tag derived from the file's size and modification time
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// isInternalFile reports whether a file named name is a previous version or an in-flight upload, which are
+// an implementation detail of the write API and left out of listings and archives
+func isInternalFile(name string) bool {
+	return isVersionName(name) || strings.HasPrefix(name, uploadTempPrefix)
+}
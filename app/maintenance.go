@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maintenanceMode is toggled through the admin API, --maintenance-file turns it on while that file exists
+var maintenanceMode atomic.Bool
+
+// inMaintenance reports whether requests other than health checks and the admin API are currently refused
+func inMaintenance() bool {
+	if maintenanceMode.Load() {
+		return true
+	}
+	if *maintenanceFileFlag != "" {
+		if _, err := os.Stat(*maintenanceFileFlag); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isMaintenanceExempt reports whether path keeps working during maintenance
+func isMaintenanceExempt(path string) bool {
+	return path == "healthz" || path == "stub_status" || path == "version" || strings.HasPrefix(path, "admin/")
+}
+
+// maintenanceError returns a 503 with a Retry-After, which uses the 503 error document as the maintenance page
+func maintenanceError() error {
+	retryAfter := int(maintenanceRetryFlag.Get().Seconds())
+	return ErrUnavailable.WithHeader("Retry-After", strconv.Itoa(retryAfter))
+}
+
+// handleHealthRequest will respond to health checks, which are answered even during maintenance
+func handleHealthRequest(writer *bufio.Writer) {
+	writer.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok")
+}
+
+// handleMaintenanceRequest will report the maintenance mode, and switch it when ?enabled= is given
+func handleMaintenanceRequest(writer *bufio.Writer, query url.Values) error {
+	if value := query.Get("enabled"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return ErrBadRequest
+		}
+		maintenanceMode.Store(enabled)
+	}
+
+	body := `{"enabled":` + strconv.FormatBool(inMaintenance()) + `}`
+	newResponseHeader("200 OK").
+		add("Content-Type", "application/json").
+		addInt("Content-Length", int64(len(body))).
+		writeString(writer, body)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/metrics"
+)
+
+// serverMetrics is the registry exposed at /metrics with --metrics
+var serverMetrics = metrics.NewRegistry()
+
+var (
+	requestsByClass  = serverMetrics.CounterVec("http_requests_total", "Requests answered, by status class.", "code")
+	requestsInFlight = serverMetrics.Gauge("http_requests_in_flight", "Requests being handled.")
+	bytesReceived    = serverMetrics.Counter("http_request_bytes_total", "Bytes read off public connections for requests, heads included.")
+	bytesSent        = serverMetrics.Counter("http_response_bytes_total", "Bytes written to public connections for responses, heads included.")
+	requestDuration  = serverMetrics.Histogram("http_request_duration_seconds", "Time from reading a request head to flushing its response.", metrics.DefaultBuckets)
+)
+
+// registerConnectionMetrics adds the connection counters kept for the admin API and stub status to the
+// metrics, main calls it with --metrics
+func registerConnectionMetrics() {
+	serverMetrics.GaugeFunc("http_connections_active", "Public connections open.", activeConnections.Load)
+	serverMetrics.CounterFunc("http_connections_accepted_total", "Public connections accepted.", acceptedConnections.Load)
+	serverMetrics.CounterFunc("http_connections_rejected_total", "Public connections refused over --max-connections.", rejectedConnections.Load)
+}
+
+// observeRequest will record the answered request in the metrics
+func observeRequest(info httpserver.ResponseInfo) {
+	class := "other"
+	if info.Status >= 100 && info.Status < 600 {
+		class = strconv.Itoa(info.Status/100) + "xx"
+	}
+	requestsByClass.With(class).Inc()
+	bytesReceived.Add(info.Received)
+	bytesSent.Add(info.Bytes)
+	requestDuration.Observe(time.Since(info.Started).Seconds())
+}
+
+// handleMetricsRequest will respond with every metric in the Prometheus text exposition format
+func handleMetricsRequest(writer *bufio.Writer) error {
+	var body bytes.Buffer
+	if err := serverMetrics.WriteText(&body); err != nil {
+		return err
+	}
+	newResponseHeader("200 OK").
+		add("Content-Type", metrics.ContentType).
+		add("Cache-Control", "no-store").
+		addInt("Content-Length", int64(body.Len())).
+		write(writer, body.Bytes())
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// recoverPanics turns a panicking handler into a 500, instead of the panic ending the whole server. The
+// connection is closed after the response, the handler may have left a partial response on it.
+func recoverPanics(next Handler) Handler {
+	return func(w *Response, r *Request) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				fmt.Printf("Panic handling %s: %v\n%s", r.RequestLine(), p, debug.Stack())
+				r.CloseAfterResponse()
+				w.Reset()
+				err = ErrInternal
+			}
+		}()
+		return next(w, r)
+	}
+}
+
+// logRequests logs each request reaching a handler with how long the handler took and the error it
+// returned, the access log only has the status the error was answered with
+func logRequests(next Handler) Handler {
+	return func(w *Response, r *Request) error {
+		started := time.Now()
+		err := next(w, r)
+
+		outcome := "ok"
+		if err != nil {
+			outcome = err.Error()
+		}
+		fmt.Printf("Handled %s %s in %s: %s\n", r.Method, r.Target, time.Since(started).Round(time.Microsecond), outcome)
+		return err
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+)
+
+// multipartBoundary returns the boundary of a multipart/form-data request body
+func multipartBoundary(head *Request) (string, bool) {
+	contentType := head.Header.Get("Content-Type")
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), "multipart/form-data") {
+		return "", false
+	}
+	return httpparse.Boundary(contentType)
+}
+
+// handleMultipartUpload will store every file part of a multipart/form-data POST in the directory name,
+// under the part's sanitized file name. Parts are streamed to disk one at a time as the body arrives,
+// and fields without a file name are skipped. When a part fails, the files the request created before it
+// are removed again, the client gets an error and can't tell which of them were stored.
+func handleMultipartUpload(reader *bufio.Reader, writer *bufio.Writer, head *Request, directory string, name string, boundary string, query url.Values) (err error) {
+	var segments []string
+	if name != "" {
+		var err error
+		segments, err = splitUploadPath(name)
+		if err == nil {
+			segments, err = uploadNameSanitizer.sanitizeSegments(segments)
+		}
+		if err != nil {
+			return ErrBadRequest.WithDetail(err.Error())
+		}
+	}
+	if err := ensureParentDirs(directory, segments, *createDirFlag); err != nil {
+		return ErrConflict
+	}
+	dirPath := filepath.Join(directory, filepath.Join(segments...))
+	overwrite := query.Get("overwrite") == "true"
+
+	progress := trackUpload(head.Header.Get("X-Request-ID"), 0, 0)
+	defer progress.finish()
+
+	form := httpparse.NewMultipartReader(limitUploadBody(progress.reader(reader)), boundary, headerLimits)
+	var locations []string
+	created := map[string]int64{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for path, size := range created {
+			if os.Remove(path) == nil {
+				filesQuota.release(size)
+			}
+		}
+	}()
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bodyError(err)
+		}
+
+		// Some browsers send the whole path the file was picked from
+		fileName := part.FileName()
+		fileName = fileName[strings.LastIndexAny(fileName, `/\`)+1:]
+		if fileName == "" {
+			continue
+		}
+		fileName, err = uploadNameSanitizer.sanitize(fileName)
+		if err != nil {
+			return ErrBadRequest.WithDetail(err.Error())
+		}
+
+		filePath := filepath.Join(dirPath, fileName)
+		_, statErr := os.Stat(filePath)
+		if statErr == nil && !overwrite {
+			return ErrConflict.WithDetail(fileName + " already exists")
+		}
+		size, err := storeUpload(part, filePath, -1, overwrite, func() error { return nil })
+		if errors.Is(err, os.ErrExist) {
+			return ErrConflict.WithDetail(fileName + " already exists")
+		}
+		if err != nil {
+			return err
+		}
+		// A file that was overwritten can't be restored, only a new one is removed when a later part fails
+		if statErr != nil {
+			created[filePath] = size
+		}
+
+		escaped := make([]string, 0, len(segments)+1)
+		for _, segment := range append(segments, fileName) {
+			escaped = append(escaped, url.PathEscape(segment))
+		}
+		locations = append(locations, "/files/"+strings.Join(escaped, "/"))
+	}
+
+	if len(locations) == 0 {
+		return ErrBadRequest.WithDetail("the form has no file parts")
+	}
+
+	body, err := json.Marshal(locations)
+	if err != nil {
+		return err
+	}
+	header := newResponseHeader("201 Created").add("Content-Type", "application/json")
+	if len(locations) == 1 {
+		header.add("Location", locations[0])
+	}
+	header.addInt("Content-Length", int64(len(body))).write(writer, body)
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookie is the cookie holding the signed session established after an OIDC login
+	sessionCookie = "session"
+
+	// oidcLoginCookie is the cookie holding the signed login in progress, tying the callback to the
+	// browser that was sent to the IdP
+	oidcLoginCookie = "oidc_login"
+
+	// oidcLoginTimeout is how long a user has to complete a login at the IdP
+	oidcLoginTimeout = 10 * time.Minute
+)
+
+// oidcProvider is the IdP configuration found through OIDC discovery, plus its signing keys
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// oidcLogin is a login in progress, kept in the login cookie of the browser until the IdP calls back
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	ReturnTo string `json:"return_to"`
+	Expires  int64  `json:"exp"`
+}
+
+// sessionClaims are what a session vouches for, they are kept in the session store under the ID held
+// in the session cookie
+type sessionClaims struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+var (
+	// oidc is the discovered IdP, nil when --oidc-issuer isn't set
+	oidc *oidcProvider
+
+	oidcClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// discoverOIDC fetches the IdP configuration of issuer and its signing keys
+func discoverOIDC(issuer string) (*oidcProvider, error) {
+	var provider oidcProvider
+	if err := fetchJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, err
+	}
+	if provider.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s", provider.Issuer)
+	}
+
+	if err := provider.refreshKeys(); err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// fetchJSON decodes the JSON document at url into v
+func fetchJSON(url string, v any) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// refreshKeys replaces the provider's signing keys with the RSA keys currently published at its jwks_uri
+func (p *oidcProvider) refreshKeys() error {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := fetchJSON(p.JWKSURI, &jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// key returns the signing key with the given ID, refetching the key set once when the IdP may have rotated
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, bool) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, true
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		fmt.Printf("Failed to refresh OIDC signing keys: %s\n", err.Error())
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok = p.keys[kid]
+	return key, ok
+}
+
+// verifyIDToken checks the RS256 signature and the iss, aud, exp, and nonce claims of an ID token
+func (p *oidcProvider) verifyIDToken(token string, nonce string) (sessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return sessionClaims{}, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return sessionClaims{}, err
+	}
+	if header.Alg != "RS256" {
+		return sessionClaims{}, fmt.Errorf("unsupported ID token algorithm %s", header.Alg)
+	}
+
+	key, ok := p.key(header.Kid)
+	if !ok {
+		return sessionClaims{}, fmt.Errorf("unknown ID token signing key %s", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return sessionClaims{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return sessionClaims{}, errors.New("invalid ID token signature")
+	}
+
+	var claims struct {
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"`
+		Subject  string          `json:"sub"`
+		Email    string          `json:"email"`
+		Expires  int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return sessionClaims{}, err
+	}
+
+	// aud is either a single client ID or a list of them
+	var audience []string
+	if err := json.Unmarshal(claims.Audience, &audience); err != nil {
+		var single string
+		json.Unmarshal(claims.Audience, &single)
+		audience = []string{single}
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return sessionClaims{}, errors.New("ID token issuer mismatch")
+	case !slices.Contains(audience, *oidcClientIDFlag):
+		return sessionClaims{}, errors.New("ID token audience mismatch")
+	case time.Now().Unix() >= claims.Expires:
+		return sessionClaims{}, errors.New("ID token expired")
+	case !hmac.Equal([]byte(claims.Nonce), []byte(nonce)):
+		return sessionClaims{}, errors.New("ID token nonce mismatch")
+	}
+
+	return sessionClaims{Subject: claims.Subject, Email: claims.Email}, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT into v
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// withOIDC runs handler only for requests carrying a valid session cookie. Browsers without one are
+// redirected to the IdP to log in, other clients get 401. Without --oidc-issuer handler runs unchecked.
+func withOIDC(writer *bufio.Writer, head *Request, handler func(writer *bufio.Writer) error) error {
+	if oidc == nil {
+		return handler(writer)
+	}
+
+	if _, ok := readSession(head); ok {
+		return handler(writer)
+	}
+
+	if !strings.Contains(head.Header.Get("Accept"), "text/html") {
+		return ErrUnauthorized
+	}
+
+	// The login is kept by the browser rather than the server, so logins that are never completed
+	// take no memory, and a callback only succeeds in the browser that started the login
+	login := oidcLogin{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		ReturnTo: head.Target,
+		Expires:  time.Now().Add(oidcLoginTimeout).Unix(),
+	}
+	payload, _ := json.Marshal(login)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {*oidcClientIDFlag},
+		"redirect_uri":  {*oidcRedirectFlag},
+		"scope":         {"openid email"},
+		"state":         {login.State},
+		"nonce":         {login.Nonce},
+	}
+	newResponseHeader("302 Found").
+		add("Set-Cookie", secureCookie(oidcLoginCookie, payload, oidcLoginTimeout, strings.HasPrefix(*oidcRedirectFlag, "https://"))).
+		add("Location", oidc.AuthorizationEndpoint+"?"+query.Encode()).
+		add("Cache-Control", "no-store").
+		add("Content-Length", "0").
+		write(writer, nil)
+	return nil
+}
+
+// handleOIDCCallback will complete a login: exchange the authorization code for an ID token, verify it,
+// and set the session cookie before sending the user back to the page they first asked for. The state
+// must match the login cookie, so a callback can't log in a browser that never started the login.
+func handleOIDCCallback(writer *bufio.Writer, head *Request) error {
+	if oidc == nil {
+		return ErrNotFound
+	}
+
+	query := head.Query()
+	login, ok := readOIDCLogin(head)
+	if !ok || !hmac.Equal([]byte(login.State), []byte(query.Get("state"))) {
+		fmt.Println("Rejected OIDC callback with an unknown or expired state")
+		return ErrBadRequest
+	}
+	if query.Get("error") != "" {
+		fmt.Printf("OIDC login failed: %s %s\n", query.Get("error"), query.Get("error_description"))
+		return ErrForbidden
+	}
+
+	resp, err := oidcClient.PostForm(oidc.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {*oidcRedirectFlag},
+		"client_id":     {*oidcClientIDFlag},
+		"client_secret": {*oidcClientSecretFlag},
+	})
+	if err != nil {
+		fmt.Printf("Error exchanging OIDC authorization code: %s\n", err.Error())
+		return ErrBadGateway
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Printf("OIDC token endpoint answered %s\n", resp.Status)
+		return ErrBadGateway
+	}
+
+	claims, err := oidc.verifyIDToken(tokens.IDToken, login.Nonce)
+	if err != nil {
+		fmt.Printf("Rejected OIDC ID token: %s\n", err.Error())
+		return ErrForbidden
+	}
+	claims.Expires = time.Now().Add(*sessionTTLFlag).Unix()
+
+	payload, _ := json.Marshal(claims)
+	id := randomToken()
+	if err := sessions.Set(id, payload, *sessionTTLFlag); err != nil {
+		return fmt.Errorf("storing session: %w", err)
+	}
+
+	newResponseHeader("302 Found").
+		add("Set-Cookie", secureCookie(sessionCookie, []byte(id), *sessionTTLFlag, strings.HasPrefix(*oidcRedirectFlag, "https://"))).
+		add("Set-Cookie", oidcLoginCookie+"=; Max-Age=0; Path=/; HttpOnly; SameSite=Lax").
+		add("Location", login.ReturnTo).
+		add("Cache-Control", "no-store").
+		add("Content-Length", "0").
+		write(writer, nil)
+	return nil
+}
+
+// readOIDCLogin returns the login in progress of the request's login cookie if it is authentic and unexpired
+func readOIDCLogin(head *Request) (oidcLogin, bool) {
+	payload, ok := getSecureCookie(head, oidcLoginCookie)
+	if !ok {
+		return oidcLogin{}, false
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(payload, &login); err != nil || login.State == "" || time.Now().Unix() >= login.Expires {
+		return oidcLogin{}, false
+	}
+	return login, true
+}
+
+// readSession returns the claims of the request's session cookie if it is authentic and unexpired
+func readSession(head *Request) (sessionClaims, bool) {
+	id, ok := getSecureCookie(head, sessionCookie)
+	if !ok {
+		return sessionClaims{}, false
+	}
+
+	payload, ok, err := sessions.Get(string(id))
+	if err != nil {
+		fmt.Printf("Error loading session: %s\n", err.Error())
+	}
+	if !ok {
+		return sessionClaims{}, false
+	}
+
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || time.Now().Unix() >= claims.Expires {
+		return sessionClaims{}, false
+	}
+	return claims, true
+}
+
+// randomToken returns an unguessable URL safe token
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressRetention is how long a finished upload stays queryable so pollers can observe its completion
+const progressRetention = time.Minute
+
+// uploadProgress tracks how many bytes of an in-flight upload have been received
+type uploadProgress struct {
+	id       string
+	total    int64
+	received atomic.Int64
+	done     atomic.Bool
+}
+
+// uploadProgresses maps upload IDs to their *uploadProgress
+var uploadProgresses sync.Map
+
+// trackUpload starts tracking the upload with the given ID, total size, and bytes already received.
+// An empty ID returns nil, which is safe to use but isn't queryable.
+func trackUpload(id string, total int64, received int64) *uploadProgress {
+	if id == "" {
+		return nil
+	}
+
+	progress := &uploadProgress{id: id, total: total}
+	progress.received.Store(received)
+	uploadProgresses.Store(id, progress)
+
+	return progress
+}
+
+// reader wraps r so that every byte read from it is counted towards the upload
+func (p *uploadProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, progress: p}
+}
+
+// finish marks the upload as no longer in flight and forgets it after progressRetention
+func (p *uploadProgress) finish() {
+	if p == nil {
+		return
+	}
+
+	p.done.Store(true)
+	time.AfterFunc(progressRetention, func() {
+		uploadProgresses.CompareAndDelete(p.id, p)
+	})
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	progress *uploadProgress
+}
+
+// Read implements io.Reader
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.progress.received.Add(int64(n))
+	return n, err
+}
+
+// handleProgressRequest will respond with the progress of the upload with the given ID
+func handleProgressRequest(writer *bufio.Writer, id string) error {
+	value, ok := uploadProgresses.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	progress := value.(*uploadProgress)
+
+	body, err := json.Marshal(struct {
+		ID       string `json:"id"`
+		Received int64  `json:"received"`
+		Total    int64  `json:"total"`
+		Done     bool   `json:"done"`
+	}{progress.id, progress.received.Load(), progress.total, progress.done.Load()})
+	if err != nil {
+		return err
+	}
+
+	newResponseHeader("200 OK").
+		add("Content-Type", "application/json").
+		add("Cache-Control", "no-store").
+		addInt("Content-Length", int64(len(body))).
+		write(writer, body)
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// upstreamTimeout bounds connecting to an upstream and every read and write on the connection, so a
+// stalled upstream can't hold a client forever
+const upstreamTimeout = 30 * time.Second
+
+// proxyRoute forwards the requests under a path prefix to an upstream server
+type proxyRoute struct {
+	// prefix is the path prefix without its surrounding slashes, like api, or empty for every path
+	prefix string
+	// upstream is the server requests are forwarded to, its path replaces the prefix
+	upstream *url.URL
+}
+
+// proxyRoutes are the routes parsed from --proxy
+var proxyRoutes []proxyRoute
+
+// parseProxyRoutes parses a comma separated list of prefix=>upstream entries, like /api=>http://localhost:9000
+func parseProxyRoutes(value string) ([]proxyRoute, error) {
+	var routes []proxyRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, target, ok := strings.Cut(entry, "=>")
+		prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("%q is not a non-empty /prefix=>upstream", entry)
+		}
+		route, err := newProxyRoute(prefix, strings.TrimSpace(target))
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// newProxyRoute returns the route forwarding requests under prefix, without its surrounding slashes and
+// empty for every path, to the upstream URL target
+func newProxyRoute(prefix string, target string) (proxyRoute, error) {
+	upstream, err := url.Parse(target)
+	if err != nil {
+		return proxyRoute{}, err
+	}
+	if upstream.Scheme != "http" && upstream.Scheme != "https" || upstream.Host == "" {
+		return proxyRoute{}, fmt.Errorf("upstream of /%s must be an http:// or https:// URL with a host", prefix)
+	}
+	if upstream.RawQuery != "" || upstream.Fragment != "" || upstream.User != nil {
+		return proxyRoute{}, fmt.Errorf("upstream of /%s must not have a query, fragment, or user info", prefix)
+	}
+	return proxyRoute{prefix: prefix, upstream: upstream}, nil
+}
+
+// upstreamConn is a connection to an upstream that renews its deadline before every read and write and
+// remembers the first error, so a failing upstream can be told apart from a failing client
+type upstreamConn struct {
+	net.Conn
+	err error
+}
+
+// Read implements io.Reader
+func (c *upstreamConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(upstreamTimeout))
+	n, err := c.Conn.Read(p)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Write implements io.Writer
+func (c *upstreamConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(upstreamTimeout))
+	n, err := c.Conn.Write(p)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// failure returns the error to answer the client with once the upstream failed with c.err
+func (c *upstreamConn) failure() error {
+	if errors.Is(c.err, os.ErrDeadlineExceeded) {
+		return ErrGatewayTimeout.WithDetail("the upstream server didn't answer in time")
+	}
+	return ErrBadGateway
+}
+
+// dial connects to the route's upstream, over TLS for an https upstream
+func (p proxyRoute) dial() (*upstreamConn, error) {
+	host, port := p.upstream.Hostname(), p.upstream.Port()
+	if port == "" {
+		port = "80"
+		if p.upstream.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+
+	dialer := &net.Dialer{Timeout: upstreamTimeout}
+	var conn net.Conn
+	var err error
+	if p.upstream.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamConn{Conn: conn}, nil
+}
+
+// upstreamTarget returns the target the request is forwarded with: the route's upstream path in place
+// of the prefix, followed by the rest of the path and the query as the client sent them
+func (p proxyRoute) upstreamTarget(r *Request) string {
+	path, query, hasQuery := strings.Cut(r.Target, "?")
+	segments := strings.Split(strings.TrimLeft(path, "/"), "/")
+	skip := 0
+	if p.prefix != "" {
+		skip = strings.Count(p.prefix, "/") + 1
+	}
+	rest := strings.Join(segments[min(len(segments), skip):], "/")
+
+	target := strings.TrimSuffix(p.upstream.EscapedPath(), "/") + "/" + rest
+	if hasQuery {
+		target += "?" + query
+	}
+	return target
+}
+
+// hasDotSegment reports whether a . or .. segment, plain or percent-encoded, is in rawPath. The target is
+// forwarded as sent, so the upstream would resolve one against the route's base path and could escape it.
+func hasDotSegment(rawPath string) bool {
+	for _, segment := range strings.Split(rawPath, "/") {
+		if decoded := httpserver.DecodedPath(segment); decoded == "." || decoded == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeader returns the header the request is forwarded with: its end-to-end fields, the upstream
+// as Host, and X-Forwarded fields describing the client. The client's X-Forwarded-For is only extended
+// when it came from a trusted proxy, anyone else could claim to forward for any address.
+func forwardedHeader(r *Request, upstream *url.URL) httpserver.Header {
+	header := httpserver.EndToEnd(r.Header)
+	delete(header, "Content-Length")
+	delete(header, "Expect")
+
+	peer := remoteIP(r.Conn).String()
+	if forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ", "); forwarded != "" && isTrustedProxy(remoteIP(r.Conn)) {
+		peer = forwarded + ", " + peer
+	}
+	proto := "http"
+	if requestTLS(r) != nil {
+		proto = "https"
+	}
+
+	header["Host"] = []string{upstream.Host}
+	header["X-Forwarded-For"] = []string{peer}
+	header["X-Forwarded-Proto"] = []string{proto}
+	header["X-Forwarded-Host"] = []string{r.Header.Get("Host")}
+	return header
+}
+
+// handle forwards the request to the route's upstream over a new connection and relays its response,
+// streaming both bodies. A request the upstream can't be reached for or doesn't answer is refused with
+// 502 Bad Gateway or 504 Gateway Timeout, a response cut short ends the connection so the client can tell.
+// Paths with dot segments are refused before anything is forwarded.
+func (p proxyRoute) handle(w *Response, r *Request) error {
+	if hasDotSegment(r.RawPath) {
+		return ErrBadRequest.WithDetail("paths with . or .. segments aren't forwarded")
+	}
+
+	upstream, err := p.dial()
+	if err != nil {
+		fmt.Printf("Failed to connect to upstream %s: %s\n", p.upstream.Host, err.Error())
+		return ErrBadGateway
+	}
+	defer upstream.Close()
+
+	if err := p.forwardRequest(upstream, r); err != nil {
+		if upstream.err != nil {
+			fmt.Printf("Failed to forward request to upstream %s: %s\n", p.upstream.Host, upstream.err.Error())
+			return upstream.failure()
+		}
+		return err
+	}
+	return p.relayResponse(w.Raw(), upstream, r)
+}
+
+// forwardRequest writes the request line, header, and body of the request to upstream. A body of
+// unknown length is sent chunked, and the connection is closed after the response since it isn't reused.
+func (p proxyRoute) forwardRequest(upstream *upstreamConn, r *Request) error {
+	writer := bufio.NewWriterSize(upstream, *writeBufferFlag)
+	writer.WriteString(r.Method + " " + p.upstreamTarget(r) + " HTTP/1.1\r\n")
+
+	header := forwardedHeader(r, p.upstream)
+	for _, name := range fieldNames(header) {
+		for _, value := range header[name] {
+			writer.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+
+	_, chunked := r.Header["Transfer-Encoding"]
+	length := r.Header.Get("Content-Length")
+	switch {
+	case chunked:
+		writer.WriteString("Transfer-Encoding: chunked\r\nConnection: close\r\n\r\n")
+	case length != "":
+		writer.WriteString("Content-Length: " + length + "\r\nConnection: close\r\n\r\n")
+	default:
+		writer.WriteString("Connection: close\r\n\r\n")
+	}
+
+	var body io.Writer = writer
+	if chunked {
+		body = newChunkedWriter(writer)
+	}
+	if _, err := copyAll(body, r.Body); err != nil {
+		return err
+	}
+	if chunked {
+		body.(*chunkedWriter).Close()
+	}
+	return writer.Flush()
+}
+
+// relayResponse reads the upstream's response and writes it to the client with its hop-by-hop fields
+// replaced by this connection's framing. Interim responses are skipped, the engine answers Expect itself.
+func (p proxyRoute) relayResponse(writer *bufio.Writer, upstream *upstreamConn, r *Request) error {
+	reader := bufio.NewReaderSize(upstream, *readBufferFlag)
+
+	var status httpparse.StatusLine
+	var fields httpparse.Header
+	for status.Code < 200 {
+		var err error
+		status, fields, err = httpparse.ReadResponseHead(reader, headerLimits)
+		if err == nil && status.Code == 101 {
+			err = errors.New("unexpected 101 Switching Protocols")
+		}
+		if err != nil {
+			fmt.Printf("Invalid response from upstream %s: %s\n", p.upstream.Host, err.Error())
+			if upstream.err != nil {
+				return upstream.failure()
+			}
+			return ErrBadGateway
+		}
+	}
+
+	statusText := strconv.Itoa(status.Code) + " " + status.Reason
+	if status.Reason == "" {
+		statusText = httpserver.StatusLine(status.Code)
+	}
+	header := httpserver.EndToEnd(httpserver.Header(fields))
+	out := newResponseHeader(statusText)
+
+	// An upstream hands a file of the files directory back for this server to send, the internal path
+	// never reaches the client. The upstream's own body is left unread, its connection is closed anyway.
+	accel, sendfile := header.Get("X-Accel-Redirect"), header.Get("X-Sendfile")
+	delete(header, "X-Accel-Redirect")
+	delete(header, "X-Sendfile")
+	if name, query, ok, err := internalRedirect(accel, sendfile); ok {
+		if err != nil {
+			fmt.Printf("Invalid internal redirect from upstream %s: %s\n", p.upstream.Host, err.Error())
+			return ErrBadGateway
+		}
+		return serveInternalRedirect(writer, r, name, query)
+	}
+
+	// A response to HEAD, 204, and 304 never has a body, whatever its fields claim
+	if r.Method == "HEAD" || status.Code == 204 || status.Code == 304 {
+		addFields(out, header).write(writer, nil)
+		return nil
+	}
+
+	var body io.Reader
+	var length int64 = -1
+	switch {
+	case strings.Contains(strings.ToLower(fields.Get("Transfer-Encoding")), "chunked"):
+		body = httpparse.NewChunkedReader(reader, headerLimits)
+		delete(header, "Content-Length")
+	case header.Get("Content-Length") != "":
+		n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil || n < 0 {
+			fmt.Printf("Invalid response from upstream %s: bad Content-Length\n", p.upstream.Host)
+			return ErrBadGateway
+		}
+		body, length = io.LimitReader(reader, n), n
+	default:
+		// Without framing the body lasts until the upstream closes the connection
+		body = reader
+	}
+
+	var bodyWriter io.Writer = writer
+	end := func() {}
+	if length >= 0 {
+		addFields(out, header).write(writer, nil)
+	} else {
+		bodyWriter, end = startStreamedBody(writer, r, addFields(out, header))
+	}
+
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	copied, err := relayBody(bodyWriter, writer, body, *buf)
+	if err != nil || (length >= 0 && copied < length) {
+		fmt.Printf("Response from upstream %s was cut short\n", p.upstream.Host)
+		r.CloseAfterResponse()
+		return nil
+	}
+	end()
+	return nil
+}
+
+// addFields appends every value of header to out, in sorted order so responses are reproducible
+func addFields(out *responseHeader, header httpserver.Header) *responseHeader {
+	for _, name := range fieldNames(header) {
+		for _, value := range header[name] {
+			out.add(name, value)
+		}
+	}
+	return out
+}
+
+// fieldNames returns the names of the fields of header in sorted order
+func fieldNames(header httpserver.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// relayBody copies body to dst through buf, flushing writer after every read so the client receives
+// what the upstream sent right away instead of once the buffer fills. It returns the bytes copied and
+// the first error other than the body's end.
+func relayBody(dst io.Writer, writer *bufio.Writer, body io.Reader, buf []byte) (int64, error) {
+	var copied int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return copied, werr
+			}
+			if werr := writer.Flush(); werr != nil {
+				return copied, werr
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+}
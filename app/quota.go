@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// storageQuota tracks the bytes stored under a mount against its configured limit.
+// A nil *storageQuota places no limit and tracks nothing.
+type storageQuota struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// filesQuota is the quota of the files directory, nil when --quota isn't set
+var filesQuota *storageQuota
+
+// newStorageQuota computes the current usage of root and returns a quota enforcing limit on it
+func newStorageQuota(root string, limit int64) (*storageQuota, error) {
+	used, err := directorySize(root)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &storageQuota{limit: limit}
+	q.used.Store(used)
+	return q, nil
+}
+
+// reserve claims n bytes of the quota, reporting false without claiming anything if they don't fit
+func (q *storageQuota) reserve(n int64) bool {
+	if q == nil {
+		return true
+	}
+
+	for {
+		used := q.used.Load()
+		if used+n > q.limit {
+			return false
+		}
+		if q.used.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+// release returns n previously reserved bytes to the quota
+func (q *storageQuota) release(n int64) {
+	if q == nil {
+		return
+	}
+	q.used.Add(-n)
+}
+
+// quotaWriter writes to w, reserving quota for every byte written and adding it to *reserved. Writes
+// that don't fit fail with ErrInsufficientStorage.
+type quotaWriter struct {
+	w        io.Writer
+	quota    *storageQuota
+	reserved *int64
+}
+
+// Write implements io.Writer
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if !qw.quota.reserve(int64(len(p))) {
+		return 0, ErrInsufficientStorage
+	}
+	*qw.reserved += int64(len(p))
+	return qw.w.Write(p)
+}
+
+// directorySize returns the total size of the regular files under root
+func directorySize(root string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
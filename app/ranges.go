@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// byteRange is the part of a file a range request asks for
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange returns the Content-Range value of the range within a file of size bytes
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// parseRange parses the Range header value of a request for a file of size bytes. ok is false when the
+// header doesn't ask for a single valid byte range, it is ignored then and the whole file is served.
+// A range that starts past the end of the file is refused with ErrRangeNotSatisfiable.
+func parseRange(value string, size int64) (byteRange, bool, error) {
+	unit, spec, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	// A suffix range like bytes=-500 asks for the last 500 bytes
+	if first == "" {
+		n, ok := parseRangeInt(last)
+		if !ok {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, unsatisfiableRange(size)
+		}
+		n = min(n, size)
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+
+	start, ok := parseRangeInt(first)
+	if !ok {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, ok = parseRangeInt(last); !ok || end < start {
+			return byteRange{}, false, nil
+		}
+	}
+
+	if start >= size {
+		return byteRange{}, false, unsatisfiableRange(size)
+	}
+	end = min(end, size-1)
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// parseRangeInt parses a position of a byte range, which is a non-negative decimal without a sign
+func parseRangeInt(s string) (int64, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// unsatisfiableRange returns the error refusing a range outside of a file of size bytes
+func unsatisfiableRange(size int64) error {
+	return ErrRangeNotSatisfiable.WithHeader("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// exchangeCounter numbers recorded exchanges so names stay unique within the same second
+var exchangeCounter atomic.Int64
+
+// recording holds the files the raw bytes of one connection are recorded into
+type recording struct {
+	request  *os.File
+	response *os.File
+}
+
+// startRecording creates the NAME.request and NAME.response files of a new exchange in dir
+func startRecording(dir string) (*recording, error) {
+	name := fmt.Sprintf("%s-%06d", time.Now().UTC().Format("20060102T150405"), exchangeCounter.Add(1))
+
+	request, err := os.Create(filepath.Join(dir, name+".request"))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := os.Create(filepath.Join(dir, name+".response"))
+	if err != nil {
+		request.Close()
+		return nil, err
+	}
+
+	return &recording{request: request, response: response}, nil
+}
+
+// wrap returns conn with everything passing through it copied into the recording
+func (r *recording) wrap(conn net.Conn) net.Conn {
+	return &recordedConn{
+		Conn: conn,
+		r:    io.TeeReader(conn, bestEffortWriter{r.request}),
+		w:    io.MultiWriter(conn, bestEffortWriter{r.response}),
+	}
+}
+
+// recordedConn is a connection being recorded
+type recordedConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+// Read implements io.Reader
+func (c *recordedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write implements io.Writer
+func (c *recordedConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// CloseWrite shuts down the sending side of the recorded connection, when it has one
+func (c *recordedConn) CloseWrite() error {
+	if halfCloser, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// Unwrap returns the connection being recorded, the engine finds TLS connections through it
+func (c *recordedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// netConn returns the connection a recording wraps, or conn itself when it isn't recorded, for
+// code that needs the TLS or TCP connection underneath
+func netConn(conn net.Conn) net.Conn {
+	if recorded, ok := conn.(*recordedConn); ok {
+		return recorded.Conn
+	}
+	return conn
+}
+
+// Close closes both recording files
+func (r *recording) Close() {
+	r.request.Close()
+	r.response.Close()
+}
+
+// bestEffortWriter never reports errors, so a failing recording can't break the connection it records
+type bestEffortWriter struct {
+	w io.Writer
+}
+
+// Write implements io.Writer
+func (b bestEffortWriter) Write(p []byte) (int, error) {
+	b.w.Write(p)
+	return len(p), nil
+}
+
+// runReplay implements the replay subcommand: every recorded request in the directory is sent to the
+// target server in order and its response compared with the recorded one
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("target", "localhost:4221", "address of the server to replay against")
+	timeout := flags.Duration("timeout", 10*time.Second, "how long to wait for each response")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: replay [--target host:port] [--timeout 10s] <directory>")
+		os.Exit(2)
+	}
+	dir := flags.Arg(0)
+
+	requests, err := filepath.Glob(filepath.Join(dir, "*.request"))
+	if err != nil {
+		fmt.Printf("Failed to list recordings: %s\n", err.Error())
+		os.Exit(1)
+	}
+	slices.Sort(requests)
+
+	mismatches := 0
+	for _, requestPath := range requests {
+		name := strings.TrimSuffix(filepath.Base(requestPath), ".request")
+
+		got, err := replayExchange(*target, requestPath, *timeout)
+		if err != nil {
+			fmt.Printf("%s: error: %s\n", name, err.Error())
+			mismatches++
+			continue
+		}
+
+		want, err := os.ReadFile(strings.TrimSuffix(requestPath, ".request") + ".response")
+		if err != nil {
+			fmt.Printf("%s: error: %s\n", name, err.Error())
+			mismatches++
+			continue
+		}
+
+		if bytes.Equal(got, want) {
+			fmt.Printf("%s: ok\n", name)
+		} else {
+			fmt.Printf("%s: response differs (got %d bytes, recorded %d bytes)\n", name, len(got), len(want))
+			mismatches++
+		}
+	}
+
+	fmt.Printf("Replayed %d exchanges, %d mismatched\n", len(requests), mismatches)
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+// replayExchange sends the recorded request to target and returns everything it answers
+func replayExchange(target string, requestPath string, timeout time.Duration) ([]byte, error) {
+	request, err := os.ReadFile(requestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	return io.ReadAll(conn)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisSessionPrefix namespaces session keys in a Redis database shared with other data
+	redisSessionPrefix = "session:"
+
+	// redisTimeout bounds connecting to Redis and every command sent to it
+	redisTimeout = 5 * time.Second
+)
+
+// redisSessionStore keeps sessions in Redis, which expires them itself, so they can be shared by replicas
+type redisSessionStore struct {
+	addr     string
+	password string
+	db       int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisSessionStore connects to the Redis server described by u, e.g. redis://:secret@localhost:6379/2
+func newRedisSessionStore(u *url.URL) (*redisSessionStore, error) {
+	store := &redisSessionStore{addr: u.Host}
+	if !strings.Contains(store.addr, ":") {
+		store.addr += ":6379"
+	}
+	if password, ok := u.User.Password(); ok {
+		store.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+		store.db = n
+	}
+
+	// Connect eagerly so a misconfigured store is reported at startup
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.connect(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// connect dials Redis and authenticates and selects the database, the caller must hold mu
+func (s *redisSessionStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+
+	if s.password != "" {
+		if _, err := s.roundTrip("AUTH", s.password); err != nil {
+			s.close()
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.roundTrip("SELECT", strconv.Itoa(s.db)); err != nil {
+			s.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// close drops the connection so the next command reconnects, the caller must hold mu
+func (s *redisSessionStore) close() {
+	s.conn.Close()
+	s.conn, s.reader = nil, nil
+}
+
+// do sends a command, reconnecting first if the previous connection was lost
+func (s *redisSessionStore) do(args ...string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := s.roundTrip(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state after an I/O error
+		s.close()
+	}
+	return reply, err
+}
+
+// roundTrip writes a command as a RESP array of bulk strings and reads its reply, the caller must hold mu
+func (s *redisSessionStore) roundTrip(args ...string) (any, error) {
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return readRESP(s.reader)
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+// Error implements error
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readRESP reads one reply: a simple string, error, integer, bulk string (nil when absent), or array
+func readRESP(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// Get implements SessionStore
+func (s *redisSessionStore) Get(id string) ([]byte, bool, error) {
+	reply, err := s.do("GET", redisSessionPrefix+id)
+	if err != nil {
+		return nil, false, err
+	}
+	data, ok := reply.([]byte)
+	return data, ok, nil
+}
+
+// Set implements SessionStore
+func (s *redisSessionStore) Set(id string, data []byte, ttl time.Duration) error {
+	_, err := s.do("SET", redisSessionPrefix+id, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete implements SessionStore
+func (s *redisSessionStore) Delete(id string) error {
+	_, err := s.do("DEL", redisSessionPrefix+id)
+	return err
+}
+
+// DeleteExpired implements SessionStore, Redis expires keys on its own
+func (s *redisSessionStore) DeleteExpired() error {
+	return nil
+}
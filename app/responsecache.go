@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// maxCachedResponseSize is the largest response kept in the response cache, bigger ones are passed through
+const maxCachedResponseSize = 1 << 20
+
+// cacheSweepInterval is how often responses that expired without being asked for again are dropped
+const cacheSweepInterval = time.Minute
+
+// responseCache holds complete GET responses keyed by request target and negotiated encoding. Once it
+// holds more than --cache-max-entries responses or --cache-max-bytes bytes, the least recently used ones
+// are evicted.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheSlot
+	// recent lists every cached response, the most recently used first
+	recent *list.List
+	bytes  int64
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// cacheSlot holds the variants of one cache key, distinguished by the request headers named in Vary
+type cacheSlot struct {
+	vary     []string
+	variants map[string]*cachedResponse
+}
+
+// cachedResponse is a raw response along with the time it stops being served
+type cachedResponse struct {
+	raw     []byte
+	expires time.Time
+
+	// key and variant locate the response in the cache, element in the recently used list
+	key     string
+	variant string
+	element *list.Element
+}
+
+// respCache is the response cache used for the routes listed in --cache-routes
+var respCache = &responseCache{entries: map[string]*cacheSlot{}, recent: list.New()}
+
+// isCachedRoute reports whether GET responses for path are cached
+func isCachedRoute(path string) bool {
+	if *cacheFlag == "" {
+		return false
+	}
+
+	for _, prefix := range strings.Split(*cacheFlag, ",") {
+		prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+		if prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCacheableRequest reports whether the response to head may be served from the cache and stored in it.
+// A request with credentials gets a response meant for its client alone, and routes protected by API keys
+// or SSO must check every request, so neither goes through the cache. Only whole 200 responses are cached,
+// which would be wrong for ranges and conditional requests answered with 206 or 304.
+func isCacheableRequest(head *Request) bool {
+	for _, name := range []string{"X-Api-Key", "Authorization", "Cookie", "Range", "If-Range",
+		"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		if head.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return !isProtectedRoute(head.RawPath)
+}
+
+// isProtectedRoute reports whether requests for path must pass the API key or session check. Static files
+// may be served for any path, so with --protect-static every path counts as protected.
+func isProtectedRoute(path string) bool {
+	protected := apiKeys != nil || oidc != nil
+	return protected && (*protectStaticFlag || path == "files" || strings.HasPrefix(path, "files/"))
+}
+
+// withResponseCache serves the response for target from the cache, or runs handler and caches its response
+func withResponseCache(writer *bufio.Writer, head *Request, target string, handler func(writer *bufio.Writer) error) error {
+	encoding := "identity"
+	if encoder, _ := httpserver.NegotiateEncoding(head); encoder != nil {
+		encoding = encoder.Name()
+	}
+	key := target + "|" + encoding
+
+	if raw, ok := respCache.lookup(key, head); ok {
+		respCache.hits.Add(1)
+		writer.Write(raw)
+		return nil
+	}
+	respCache.misses.Add(1)
+
+	// The response is passed through as it is written so large or streamed responses aren't held back,
+	// and only copied for the cache while it stays small enough to keep
+	capture := &limitedBuffer{limit: maxCachedResponseSize}
+	tee := bufio.NewWriter(io.MultiWriter(writer, capture))
+	if err := handler(tee); err != nil {
+		return err
+	}
+	tee.Flush()
+
+	if !capture.overflowed {
+		respCache.store(key, head, capture.Bytes())
+	}
+	return nil
+}
+
+// lookup returns the unexpired response cached for key matching the request's Vary headers
+func (c *responseCache) lookup(key string, head *Request) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	variantKey := varyKey(slot.vary, head)
+	response, ok := slot.variants[variantKey]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(response.expires) {
+		c.remove(response)
+		return nil, false
+	}
+
+	c.recent.MoveToFront(response.element)
+	return response.raw, true
+}
+
+// store caches raw for key if it is a complete, cacheable 200 response
+func (c *responseCache) store(key string, head *Request, raw []byte) {
+	status, header, ok := httpserver.ParseResponseHead(raw)
+	if !ok || status != "HTTP/1.1 200 OK" || header.Get("Content-Length") == "" {
+		return
+	}
+
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") || header.Get("Set-Cookie") != "" {
+		return
+	}
+
+	var vary []string
+	for _, name := range strings.Split(strings.Join(header.Values("Vary"), ","), ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			return
+		}
+		if name != "" {
+			vary = append(vary, name)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot, ok := c.entries[key]
+	if ok && strings.Join(slot.vary, ",") != strings.Join(vary, ",") {
+		c.removeSlot(slot)
+		ok = false
+	}
+	if !ok {
+		slot = &cacheSlot{vary: vary, variants: map[string]*cachedResponse{}}
+		c.entries[key] = slot
+	}
+
+	variant := varyKey(vary, head)
+	if old, ok := slot.variants[variant]; ok {
+		c.remove(old)
+		c.entries[key] = slot
+	}
+	response := &cachedResponse{raw: bytes.Clone(raw), expires: time.Now().Add(cacheTTLFlag.Get()), key: key, variant: variant}
+	response.element = c.recent.PushFront(response)
+	slot.variants[variant] = response
+	c.bytes += int64(len(raw))
+
+	for c.recent.Len() > *cacheMaxEntriesFlag || c.bytes > *cacheMaxBytesFlag {
+		c.remove(c.recent.Back().Value.(*cachedResponse))
+	}
+}
+
+// remove drops response from the cache, along with its slot once it has no variants left. The cache
+// must be locked.
+func (c *responseCache) remove(response *cachedResponse) {
+	c.recent.Remove(response.element)
+	c.bytes -= int64(len(response.raw))
+	slot := c.entries[response.key]
+	delete(slot.variants, response.variant)
+	if len(slot.variants) == 0 {
+		delete(c.entries, response.key)
+	}
+}
+
+// removeSlot drops every variant of slot from the cache, which must be locked
+func (c *responseCache) removeSlot(slot *cacheSlot) {
+	for _, response := range slot.variants {
+		c.remove(response)
+	}
+}
+
+// sweep drops every expired response, returning how many were dropped
+func (c *responseCache) sweep(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	swept := 0
+	for element := c.recent.Front(); element != nil; {
+		next := element.Next()
+		if response := element.Value.(*cachedResponse); now.After(response.expires) {
+			c.remove(response)
+			swept++
+		}
+		element = next
+	}
+	return swept
+}
+
+// sweepResponseCache will periodically drop the cached responses that expired
+func sweepResponseCache() {
+	for now := range time.Tick(cacheSweepInterval) {
+		respCache.sweep(now)
+	}
+}
+
+// purge drops every cached response whose target is path, or starts with it when prefix is set,
+// returning how many keys were removed
+func (c *responseCache) purge(path string, prefix bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key, slot := range c.entries {
+		target, _, _ := strings.Cut(key, "|")
+		targetPath, _, _ := strings.Cut(target, "?")
+		if targetPath == path || (prefix && strings.HasPrefix(targetPath, path)) {
+			c.removeSlot(slot)
+			purged++
+		}
+	}
+	return purged
+}
+
+// purgeFilesInvalidator returns a watcher invalidator that purges cached /files/ responses for changed paths under root
+func purgeFilesInvalidator(root string) func(path string) {
+	return func(path string) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return
+		}
+		// The root itself is notified when the watcher lost track of what changed, so everything goes
+		if rel == "." {
+			respCache.purge("/files", true)
+			return
+		}
+		respCache.purge("/files/"+filepath.ToSlash(rel), true)
+
+		// Listings of the parent directory change as well, and may be cached with or without a trailing slash
+		parent := "/files/"
+		if dir := filepath.Dir(rel); dir != "." {
+			parent += filepath.ToSlash(dir) + "/"
+		}
+		respCache.purge(parent, false)
+		respCache.purge(strings.TrimSuffix(parent, "/"), false)
+	}
+}
+
+// varyKey combines the values of the named request headers into a variant key
+func varyKey(vary []string, head *Request) string {
+	values := make([]string, len(vary))
+	for i, name := range vary {
+		values[i] = head.Header.Get(name)
+	}
+	return strings.Join(values, "\x00")
+}
+
+// handleCacheStatsRequest will respond with the response cache's hit and miss counters
+func handleCacheStatsRequest(writer *bufio.Writer) error {
+	respCache.mu.Lock()
+	entries, size := respCache.recent.Len(), respCache.bytes
+	respCache.mu.Unlock()
+
+	hits, misses := respCache.hits.Load(), respCache.misses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	return writeJSON(writer, struct {
+		Hits    int64   `json:"hits"`
+		Misses  int64   `json:"misses"`
+		HitRate float64 `json:"hit_rate"`
+		Entries int     `json:"entries"`
+		Bytes   int64   `json:"bytes"`
+	}{hits, misses, hitRate, entries, size})
+}
+
+// limitedBuffer collects written bytes until they exceed limit, after which it drops them all
+type limitedBuffer struct {
+	bytes.Buffer
+	limit      int
+	overflowed bool
+}
+
+// Write implements io.Writer, it never fails so the response it shadows is never interrupted
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if !b.overflowed {
+		if b.Len()+len(p) > b.limit {
+			b.overflowed = true
+			b.Reset()
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
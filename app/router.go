@@ -0,0 +1,21 @@
+package main
+
+import "github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+
+// The engine's request, response, and routing types are what the server's handlers are written against
+type (
+	// Request is a request routed to a handler
+	Request = httpserver.Request
+
+	// Response collects what a handler answers with
+	Response = httpserver.Response
+
+	// Handler answers a request, returning the error to answer it with instead when it refuses it
+	Handler = httpserver.Handler
+
+	// Middleware wraps a handler with behavior shared by many routes
+	Middleware = httpserver.Middleware
+
+	// Router dispatches requests to the handlers registered for their method and path
+	Router = httpserver.Router
+)
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// nameSanitizer is the policy applied to every uploaded file name segment before it touches the filesystem
+type nameSanitizer struct {
+	// charset is "unicode" (any printable character), "ascii" (printable ASCII),
+	// or "portable" (the POSIX portable filename set A-Z a-z 0-9 . _ -)
+	charset string
+	// maxLength is the maximum length of a segment in bytes
+	maxLength int
+}
+
+// uploadNameSanitizer is configured from --name-charset and --max-name-length at startup
+var uploadNameSanitizer = nameSanitizer{charset: "unicode", maxLength: 255}
+
+// windowsReservedNames can't be used as file names on Windows even with an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// compositions maps a combining mark to the base letters it composes with and their precomposed forms,
+// covering the Latin-1 Supplement and Latin Extended-A blocks
+var compositions = map[rune]struct{ bases, composed string }{
+	'\u0300': {"AEIOUaeiou", "ÀÈÌÒÙàèìòù"},                             // combining grave accent
+	'\u0301': {"AEIOUYaeiouyCcLlNnRrSsZz", "ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹź"}, // combining acute accent
+	'\u0302': {"AEIOUaeiouCcGgHhJjSsWwYy", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷ"}, // combining circumflex accent
+	'\u0303': {"ANOanoIiUu", "ÃÑÕãñõĨĩŨũ"},                             // combining tilde
+	'\u0304': {"AaEeIiOoUu", "ĀāĒēĪīŌōŪū"},                             // combining macron
+	'\u0306': {"AaEeGgIiOoUu", "ĂăĔĕĞğĬĭŎŏŬŭ"},                         // combining breve
+	'\u0307': {"CcEeGgIZz", "ĊċĖėĠġİŻż"},                               // combining dot above
+	'\u0308': {"AEIOUaeiouyY", "ÄËÏÖÜäëïöüÿŸ"},                         // combining diaeresis
+	'\u030A': {"AaUu", "ÅåŮů"},                                         // combining ring above
+	'\u030B': {"OoUu", "ŐőŰű"},                                         // combining double acute accent
+	'\u030C': {"CcDdEeLlNnRrSsTtZz", "ČčĎďĚěĽľŇňŘřŠšŤťŽž"},             // combining caron
+	'\u0327': {"CcGgKkLlNnRrSsTt", "ÇçĢģĶķĻļŅņŖŗŞşŢţ"},                 // combining cedilla
+	'\u0328': {"AaEeIiUu", "ĄąĘęĮįŲų"},                                 // combining ogonek
+}
+
+// validCharsets are the accepted values of --name-charset
+var validCharsets = map[string]bool{"unicode": true, "ascii": true, "portable": true}
+
+// sanitizeSegments applies the sanitizer to every segment of an upload path
+func (s nameSanitizer) sanitizeSegments(segments []string) ([]string, error) {
+	sanitized := make([]string, len(segments))
+	for i, segment := range segments {
+		name, err := s.sanitize(segment)
+		if err != nil {
+			return nil, err
+		}
+		sanitized[i] = name
+	}
+	return sanitized, nil
+}
+
+// sanitize returns the normalized form of a single path segment, or an error if it can't be used
+func (s nameSanitizer) sanitize(segment string) (string, error) {
+	if !utf8.ValidString(segment) {
+		return "", errors.New("file name is not valid UTF-8")
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, segment)
+
+	name = composeLatin(name)
+
+	// Leading and trailing spaces and trailing dots are silently dropped by some filesystems
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+
+	name = strings.Map(s.replaceDisallowed, name)
+
+	switch {
+	case name == "":
+		return "", fmt.Errorf("file name %q is empty after sanitization", segment)
+	case len(name) > s.maxLength:
+		return "", fmt.Errorf("file name %q is longer than %d bytes", segment, s.maxLength)
+	case isReservedName(name):
+		return "", fmt.Errorf("file name %q is reserved", name)
+	}
+
+	return name, nil
+}
+
+// replaceDisallowed maps characters outside of the sanitizer's charset to an underscore
+func (s nameSanitizer) replaceDisallowed(r rune) rune {
+	switch s.charset {
+	case "ascii":
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+	case "portable":
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return '_'
+		}
+	}
+	return r
+}
+
+// composeLatin replaces a Latin letter followed by a combining mark with its precomposed (NFC) form
+func composeLatin(name string) string {
+	runes := []rune(name)
+	composed := make([]rune, 0, len(runes))
+
+	for _, r := range runes {
+		if n := len(composed); n > 0 {
+			if table, ok := compositions[r]; ok {
+				if i := strings.IndexRune(table.bases, composed[n-1]); i >= 0 {
+					composed[n-1] = []rune(table.composed)[utf8.RuneCountInString(table.bases[:i])]
+					continue
+				}
+			}
+		}
+		composed = append(composed, r)
+	}
+
+	return string(composed)
+}
+
+// isReservedName reports whether name is reserved by Windows or used internally by the files API
+func isReservedName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReservedNames[strings.ToUpper(base)] {
+		return true
+	}
+
+	return name == tusDirName || strings.HasPrefix(name, uploadTempPrefix) || isVersionName(name)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/safepath"
+)
+
+// withInternalRedirect wraps handler so that, if its response carries an X-Accel-Redirect or X-Sendfile
+// header, the response is discarded and the named file is served in its place
+func withInternalRedirect(handler Handler) Handler {
+	return func(w *Response, r *Request) error {
+		if err := handler(w, r); err != nil {
+			return err
+		}
+
+		name, query, ok, err := internalRedirect(w.Header("X-Accel-Redirect"), w.Header("X-Sendfile"))
+		if !ok {
+			return nil
+		}
+		w.Reset()
+		if err != nil {
+			return err
+		}
+		return serveInternalRedirect(w.Raw(), r, name, query)
+	}
+}
+
+// internalRedirect returns the file relative to the files directory, and its query, named by the value of
+// an X-Accel-Redirect (a /files/ URI) or X-Sendfile (a path inside the files directory) header. It
+// reports false when both are empty.
+func internalRedirect(accel string, sendfile string) (string, url.Values, bool, error) {
+	switch {
+	case accel != "":
+		target, rawQuery, _ := strings.Cut(accel, "?")
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil || !strings.HasPrefix(target, "/files/") {
+			return "", nil, true, fmt.Errorf("invalid X-Accel-Redirect target: %s", accel)
+		}
+		return strings.TrimPrefix(target, "/files/"), query, true, nil
+	case sendfile != "":
+		if filepath.IsAbs(sendfile) {
+			rel, err := filepath.Rel(filesDirectory(), sendfile)
+			if err != nil {
+				return "", nil, true, fmt.Errorf("invalid X-Sendfile path: %s", sendfile)
+			}
+			sendfile = rel
+		}
+		return filepath.ToSlash(sendfile), url.Values{}, true, nil
+	default:
+		return "", nil, false, nil
+	}
+}
+
+// serveInternalRedirect will serve the file named relative to the files directory to the original request
+func serveInternalRedirect(writer *bufio.Writer, head *Request, name string, query url.Values) error {
+	if filesDirectory() == "" {
+		return fmt.Errorf("internal redirect to %s without --directory", name)
+	}
+
+	// A redirect outside of the files directory is the handler's fault, not the client's
+	filePath, err := safepath.Resolve(filesDirectory(), name)
+	if err != nil {
+		return fmt.Errorf("rejected internal redirect to %s: %v", name, err)
+	}
+
+	return serveFile(writer, head, filePath, query, filesServeOptions)
+}
@@ -2,251 +2,825 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"compress/gzip"
-	"errors"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/safepath"
 )
 
 const (
-	StatusOK                  = "HTTP/1.1 200 OK\r\n\r\n"
-	StatusCreated             = "HTTP/1.1 201 Created\r\n\r\n"
-	StatusNotFound            = "HTTP/1.1 404 Not Found\r\n\r\n"
-	StatusBadRequest          = "HTTP/1.1 400 Bad Request\r\n\r\n"
-	StatusInternalServerError = "HTTP/1.1 500 Internal Server Error\r\n\r\n"
+	StatusCreated = "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"
+)
+
+var (
+	addrFlag             = flag.String("addr", "0.0.0.0", "address of the interface to listen on, empty for all of them")
+	portFlag             = flag.Int("port", 4221, "port to serve plaintext HTTP on")
+	directoryFlag        = flag.String("directory", "", "directory to serve /files from, which are disabled when empty")
+	staticFlag           = flag.String("static", "", "directory to serve every other GET request from as static files, with index.html for directories")
+	staticListingFlag    = flag.Bool("static-listing", false, "render an HTML listing of --static directories without an index.html")
+	protectStaticFlag    = flag.Bool("protect-static", false, "require the --oidc-issuer session and --api-keys key for --static and virtual host static files too")
+	versionsFlag         = newSetting("versions", 0, "number of previous versions to keep when a file is overwritten (0 disables versioning)", parseNonNegativeInt)
+	createDirFlag        = flag.Bool("create-dirs", false, "create missing parent directories when uploading a file")
+	extensionFlag        = flag.String("allowed-extensions", "", "comma separated list of file extensions accepted for upload (empty allows all)")
+	errorsFlag           = flag.String("error-pages", "", "directory of custom error documents such as 404.html or 50x.html")
+	templateFlag         = flag.String("listing-template", "", "html/template file used to render directory listings instead of the built-in one")
+	mmapFlag             = flag.Bool("mmap", false, "serve large files under the files directory from memory mappings")
+	staticMmapFlag       = flag.Bool("static-mmap", false, "serve large files under --static from memory mappings")
+	checksumTrailerFlag  = flag.Bool("checksum-trailer", false, "stream whole file downloads chunked with the SHA-256 of their content in an X-Checksum trailer")
+	immutableFlag        = flag.Bool("immutable-assets", false, "serve files with a content hash in their name with an immutable Cache-Control")
+	cacheFlag            = flag.String("cache-routes", "", "comma separated path prefixes whose GET responses are cached in memory, e.g. echo/,files/")
+	cacheMaxEntriesFlag  = flag.Int("cache-max-entries", 10000, "most responses kept in the response cache, the least recently used are evicted")
+	cacheMaxBytesFlag    = flag.Int64("cache-max-bytes", 64<<20, "most bytes of responses kept in the response cache, the least recently used are evicted")
+	cacheTTLFlag         = newSetting("cache-ttl", time.Minute, "how long a cached response is served", parseNonNegativeDuration)
+	adminTokenFlag       = flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token required by the /admin/ API, which is disabled when empty (defaults to $ADMIN_TOKEN)")
+	maintenanceFileFlag  = flag.String("maintenance-file", "", "enter maintenance mode whenever this file exists")
+	maintenanceRetryFlag = newSetting("maintenance-retry-after", time.Minute, "Retry-After sent with 503 responses during maintenance", parseNonNegativeDuration)
+	chaosFlag            = flag.Bool("chaos", false, "enable the /chaos/ fault-injection endpoints")
+	chaosRateFlag        = newSetting("chaos-error-rate", 0.0, "fraction of requests (0-1) answered with a random 5xx", parseFraction)
+	adminSocketFlag      = flag.String("admin-socket", "", "unix socket to serve the admin API on instead of the public port")
+	maxConnectionsFlag   = flag.Int("max-connections", 0, "maximum simultaneous public connections, more are answered with 503 and closed (0 disables the limit)")
+	maxConnsPerIPFlag    = flag.Int("max-conns-per-ip", 0, "maximum simultaneous connections per client IP (0 disables the limit)")
+	proxiesFlag          = flag.String("trusted-proxies", "", "comma separated CIDRs of proxies whose X-Forwarded-For identifies the client")
+	vhostConfigFlag      = flag.String("config", "", "JSON file of virtual hosts and their routes, reloaded on SIGHUP")
+	corsOriginsFlag      = flag.String("cors-origins", "", "comma separated origins allowed to make cross-origin requests, like https://app.example.com, or * for any")
+	corsCredentialsFlag  = flag.Bool("cors-credentials", false, "allow cross-origin requests to send cookies and credentials")
+	proxyFlag            = flag.String("proxy", "", "comma separated path prefixes forwarded to upstream servers, like /api=>http://localhost:9000")
+	apiKeysFlag          = flag.String("api-keys", "", "JSON file of API keys and their quotas, required to use the file API when set")
+	oidcIssuerFlag       = flag.String("oidc-issuer", "", "OpenID Connect issuer URL, protects the file API with SSO when set")
+	oidcClientIDFlag     = flag.String("oidc-client-id", "", "client ID registered with the OpenID Connect issuer")
+	oidcClientSecretFlag = flag.String("oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "client secret registered with the OpenID Connect issuer")
+	oidcRedirectFlag     = flag.String("oidc-redirect-url", "", "external URL of /oidc/callback, as registered with the issuer")
+	cookieSecretsFlag    = flag.String("cookie-secrets", os.Getenv("COOKIE_SECRETS"), "comma separated secrets protecting cookies, newest first (random per process when empty)")
+	encryptCookiesFlag   = flag.Bool("encrypt-cookies", false, "encrypt cookies as well as signing them")
+	sessionStoreFlag     = flag.String("session-store", "memory", "where sessions are kept: memory, file:<directory>, or redis://[:password@]host:port[/db]")
+	sessionTTLFlag       = flag.Duration("session-ttl", 12*time.Hour, "how long a login session lasts")
+	syslogFlag           = flag.String("syslog", "", "send access and error logs to syslog at udp://host:port, tcp://host:port, or unixgram:///dev/log")
+	accessLogFlag        = flag.String("access-log", "", "file to append the access log to, - for stdout (defaults to syslog with --syslog, otherwise none)")
+	logStyleFlag         = flag.String("log-format", "text", "access log style: text in --access-log-format, common for the Common Log Format, or json")
+	logRequestsFlag      = flag.Bool("log-requests", false, "log each routed request with how long its handler took and the error it returned")
+	logFormatFlag        = flag.String("access-log-format", combinedLogFormat, "access log line format with placeholders like {remote}, {status}, and {header:X-Request-ID}")
+	facilityFlag         = flag.String("syslog-facility", "local0", "syslog facility of the server's messages")
+	readBufferFlag       = flag.Int("read-buffer-size", 4096, "size in bytes of each connection's read buffer")
+	writeBufferFlag      = flag.Int("write-buffer-size", 4096, "size in bytes of each connection's write buffer")
+	copyBufferFlag       = flag.Int("copy-buffer-size", 32*1024, "size in bytes of the buffer used to copy file contents")
+	workersFlag          = flag.Int("workers", 0, "serve connections from a pool of this many goroutines (0 starts one per connection)")
+	printVersionFlag     = flag.Bool("version", false, "print the version, commit, and build date of the binary and exit")
+	versionRouteFlag     = flag.Bool("version-endpoint", false, "serve the build details of the binary at /version")
+	readTimeoutFlag      = flag.Duration("read-timeout", 30*time.Second, "how long a client may take to send a request head (0 disables the timeout)")
+	maxHeaderSizeFlag    = flag.Int("max-header-size", 64<<10, "maximum size in bytes of a request line and header fields together")
+	idleTimeoutFlag      = flag.Duration("idle-timeout", 60*time.Second, "how long a persistent connection may stay idle between requests (0 disables the timeout)")
+	writeTimeoutFlag     = flag.Duration("write-timeout", 60*time.Second, "how long a write may block on a client that stops reading its response before it is disconnected (0 disables the timeout)")
+	tlsCertFlag          = flag.String("tls-cert", "", "PEM certificate chain to serve HTTPS with, together with --tls-key")
+	tlsKeyFlag           = flag.String("tls-key", "", "PEM private key of the --tls-cert certificate")
+	tlsClientCAFlag      = flag.String("tls-client-ca", "", "PEM CA certificates that client certificates are verified against, clients without one are still served")
+	tlsPortFlag          = flag.Int("tls-port", 0, "port to serve HTTPS on next to plaintext HTTP on --port (0 serves HTTPS on --port instead)")
+	httpsRedirectFlag    = flag.Bool("https-redirect", false, "redirect every plaintext request to the same URL on --tls-port")
+	shutdownGraceFlag    = flag.Duration("shutdown-grace", 30*time.Second, "how long connections in flight may take to finish on shutdown before they are closed (0 waits indefinitely)")
+	stubStatusFlag       = flag.Bool("stub-status", false, "serve nginx-style connection counters at /stub_status")
+	metricsFlag          = flag.Bool("metrics", false, "serve request, byte, and latency metrics at /metrics in the Prometheus text format")
+	auditLogFlag         = flag.String("audit-log", "", "file that runtime setting changes made through the admin API are appended to")
+	recordFlag           = flag.String("record", "", "directory to record every raw request and response into, for the replay subcommand")
+	watchFlag            = flag.Bool("watch", false, "cache file metadata and listings, invalidating them when the files directory changes")
+	tusPathFlag          = flag.String("tus-path", "", "path of the tus resumable upload endpoint, e.g. uploads (empty disables it)")
+	charsetFlag          = flag.String("name-charset", "unicode", "characters allowed in uploaded file names: unicode, ascii, or portable")
+	nameLenFlag          = flag.Int("max-name-length", 255, "maximum length in bytes of each segment of an uploaded file name")
+	maxUploadSizeFlag    = flag.Int64("max-upload-size", 0, "maximum size in bytes of an upload body, larger ones are refused with 413 (0 is unlimited)")
+	maxBodySizeFlag      = flag.Int64("max-body-size", 0, "maximum size in bytes of any request body, larger ones are refused with 413 (0 is unlimited)")
+	quotaFlag            = flag.Int64("quota", 0, "maximum number of bytes stored under the files directory (0 is unlimited)")
+	tusExpiryFlag        = flag.Duration("tus-expiration", 24*time.Hour, "how long an unfinished tus upload is kept")
 )
 
 func main() {
-	l, err := net.Listen("tcp", "0.0.0.0:4221")
-	if err != nil {
-		fmt.Println("Failed to bind to port 4221")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "manifest":
+			runManifest(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		}
+	}
+
+	flag.Parse()
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	defer l.Close()
 
-	for {
-		conn, err := l.Accept()
+	if *printVersionFlag {
+		fmt.Println(currentBuild())
+		return
+	}
+
+	go handleDiagnosticSignals()
+	go handleShutdownSignals()
+
+	writeAccessLine := func(line string) { fmt.Println(line) }
+	if *syslogFlag != "" {
+		w, err := newSyslogWriter(*syslogFlag, *facilityFlag)
 		if err != nil {
-			fmt.Printf("Error accepting connection: %s\n", err.Error())
-			continue
+			fmt.Printf("Invalid --syslog: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := redirectStdoutToSyslog(w); err != nil {
+			fmt.Printf("Failed to redirect logs to syslog: %s\n", err.Error())
+			os.Exit(1)
 		}
-		go handleConnection(conn)
+		writeAccessLine = func(line string) { w.send(severityInfo, "access", line) }
 	}
-}
 
-// handleConnection handles the incoming connection
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
+	// The access log goes to --access-log, or to syslog when only --syslog is set
+	switch *accessLogFlag {
+	case "", "-":
+	default:
+		file, err := os.OpenFile(*accessLogFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("Failed to open access log: %s\n", err.Error())
+			os.Exit(1)
+		}
+		writeAccessLine = func(line string) { fmt.Fprintln(file, line) }
+	}
+	if *accessLogFlag != "" || *syslogFlag != "" {
+		sink, err := newLineSink(*logStyleFlag, *logFormatFlag, writeAccessLine)
+		if err != nil {
+			fmt.Printf("Invalid --log-format or --access-log-format: %s\n", err.Error())
+			os.Exit(1)
+		}
+		accessLog = sink
+	}
 
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	if err := validateConfig(); err != nil {
+		fmt.Printf("Invalid configuration: %s\n", err.Error())
+		os.Exit(1)
+	}
+	headerLimits.MaxHeaderBytes = *maxHeaderSizeFlag
+
+	if !validCharsets[*charsetFlag] || *nameLenFlag <= 0 {
+		fmt.Println("Flag --name-charset must be unicode, ascii, or portable and --max-name-length must be positive")
+		os.Exit(1)
+	}
+	uploadNameSanitizer = nameSanitizer{charset: *charsetFlag, maxLength: *nameLenFlag}
 
-	lines, request, path, err := readRequest(reader)
+	proxies, err := parseTrustedProxies(*proxiesFlag)
 	if err != nil {
-		fmt.Printf("Error reading request: %s\n", err.Error())
-		writer.WriteString(StatusBadRequest)
-		writer.Flush()
-		return
+		fmt.Printf("Invalid --trusted-proxies: %s\n", err.Error())
+		os.Exit(1)
 	}
+	trustedProxies = proxies
 
-	switch {
-	case path == "":
-		writer.WriteString(StatusOK)
-	case path == "user-agent":
-		handleUserAgentRequest(writer, lines)
-	case strings.HasPrefix(path, "echo/"):
-		handleEchoRequest(writer, lines, path)
-	case strings.HasPrefix(path, "files/"):
-		handleFileRequest(reader, writer, request[0], lines, path)
-	default:
-		writer.WriteString(StatusNotFound)
+	upstreams, err := parseProxyRoutes(*proxyFlag)
+	if err != nil {
+		fmt.Printf("Invalid --proxy: %s\n", err.Error())
+		os.Exit(1)
 	}
+	proxyRoutes = upstreams
 
-	writer.Flush()
-}
+	origins, anyOrigin, err := parseCORSOrigins(*corsOriginsFlag)
+	if err != nil {
+		fmt.Printf("Invalid --cors-origins: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if anyOrigin && *corsCredentialsFlag {
+		fmt.Println("Flag --cors-credentials needs --cors-origins to list origins, every origin could read credentialed responses with *")
+		os.Exit(1)
+	}
+	corsOrigins, corsAnyOrigin = origins, anyOrigin
 
-// readRequest reads the HTTP request from the client
-func readRequest(reader *bufio.Reader) ([]string, []string, string, error) {
-	var lines []string
-	for {
-		line, err := reader.ReadString('\n')
+	if *apiKeysFlag != "" {
+		keys, err := loadAPIKeys(*apiKeysFlag)
 		if err != nil {
-			if err != io.EOF {
-				return nil, nil, "", err
-			}
-			break
+			fmt.Printf("Failed to load API keys: %s\n", err.Error())
+			os.Exit(1)
+		}
+		apiKeys = keys
+	}
+
+	secrets := strings.FieldsFunc(*cookieSecretsFlag, func(r rune) bool { return r == ',' })
+	if len(secrets) == 0 {
+		secrets = []string{randomToken()}
+	}
+	codec, err := newCookieCodec(secrets, *encryptCookiesFlag)
+	if err != nil {
+		fmt.Printf("Failed to set up cookie keys: %s\n", err.Error())
+		os.Exit(1)
+	}
+	cookies = codec
+
+	if *oidcIssuerFlag != "" {
+		if *oidcClientIDFlag == "" || *oidcRedirectFlag == "" {
+			fmt.Println("Flags --oidc-client-id and --oidc-redirect-url are required with --oidc-issuer")
+			os.Exit(1)
 		}
 
-		line = strings.TrimSuffix(line, "\r\n")
-		lines = append(lines, line)
+		provider, err := discoverOIDC(*oidcIssuerFlag)
+		if err != nil {
+			fmt.Printf("Failed to discover OpenID Connect issuer: %s\n", err.Error())
+			os.Exit(1)
+		}
+		oidc = provider
 
-		// If the line is empty, we have reached the end of the HTTP request header
-		if line == "" {
-			break
+		store, err := openSessionStore(*sessionStoreFlag)
+		if err != nil {
+			fmt.Printf("Failed to open session store: %s\n", err.Error())
+			os.Exit(1)
 		}
+		sessions = store
+		go sweepSessions(store)
 	}
 
-	if len(lines) == 0 {
-		return nil, nil, "", errors.New("empty request")
+	if *extensionFlag != "" {
+		uploadValidators = append(uploadValidators, extensionValidator(strings.Split(*extensionFlag, ",")))
 	}
 
-	request := strings.Split(lines[0], " ")
-	if len(request) == 0 {
-		return nil, nil, "", errors.New("invalid request")
+	if *quotaFlag > 0 {
+		quota, err := newStorageQuota(filesDirectory(), *quotaFlag)
+		if err != nil {
+			fmt.Printf("Failed to compute storage usage: %s\n", err.Error())
+			os.Exit(1)
+		}
+		filesQuota = quota
 	}
 
-	path := strings.Trim(request[1], "/")
-
-	return lines, request, path, nil
-}
+	filesServeOptions = fileServeOptions{mmap: *mmapFlag, immutable: *immutableFlag}
+	staticServeOptions = fileServeOptions{mmap: *staticMmapFlag, immutable: *immutableFlag, detectType: true}
 
-// handleUserAgentRequest will handle requests for user-agent
-func handleUserAgentRequest(writer *bufio.Writer, lines []string) {
-	userAgent := ""
-	for _, line := range lines {
-		if strings.HasPrefix(line, "User-Agent: ") {
-			userAgent = strings.TrimPrefix(line, "User-Agent: ")
-			break
+	if *templateFlag != "" {
+		if err := loadListingTemplate(*templateFlag); err != nil {
+			fmt.Printf("Failed to load listing template: %s\n", err.Error())
+			os.Exit(1)
 		}
 	}
 
-	res := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(userAgent), userAgent)
-	writer.WriteString(res)
-}
+	if *errorsFlag != "" {
+		if err := loadErrorPages(*errorsFlag); err != nil {
+			fmt.Printf("Failed to load error pages: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
 
-// handleEchoRequest will handle requests for echo
-func handleEchoRequest(writer *bufio.Writer, lines []string, path string) {
-	acceptEncoding := ""
-	for _, line := range lines {
-		if strings.HasPrefix(line, "Accept-Encoding: ") {
-			acceptEncoding = strings.TrimPrefix(line, "Accept-Encoding: ")
-			break
+	if *watchFlag {
+		if err := startWatcher(filesDirectory()); err != nil {
+			fmt.Printf("Failed to watch files directory: %s\n", err.Error())
+			os.Exit(1)
 		}
+		invalidators = append(invalidators, purgeFilesInvalidator(filepath.Clean(filesDirectory())))
 	}
 
-	contentEncodingHeader := ""
-	for _, encoding := range strings.Split(acceptEncoding, " ") {
-		encoding = strings.TrimSuffix(encoding, ",")
-		if encoding == "gzip" {
-			contentEncodingHeader = "Content-Encoding: gzip\r\n"
+	if *recordFlag != "" {
+		if err := os.MkdirAll(*recordFlag, 0755); err != nil {
+			fmt.Printf("Failed to create recording directory: %s\n", err.Error())
+			os.Exit(1)
 		}
 	}
 
-	word := strings.TrimPrefix(path, "echo/")
+	if *tusPathFlag != "" {
+		*tusPathFlag = strings.Trim(*tusPathFlag, "/")
+		if filesQuota != nil {
+			reserveTusUploads(filepath.Join(filesDirectory(), tusDirName))
+		}
+		go expireTusUploads()
+	}
 
-	var body bytes.Buffer
-	if contentEncodingHeader != "" {
-		zw := gzip.NewWriter(&body)
-		zw.Write([]byte(word))
-		zw.Close()
+	if *cacheFlag != "" {
+		go sweepResponseCache()
+	}
 
-	} else {
-		body.Write([]byte(word))
+	if *metricsFlag {
+		registerConnectionMetrics()
 	}
 
-	res := fmt.Sprintf("HTTP/1.1 200 OK\r\n%sContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n", contentEncodingHeader, body.Len())
+	if *vhostConfigFlag != "" {
+		hosts, err := loadVirtualHosts(*vhostConfigFlag)
+		if err != nil {
+			fmt.Printf("Failed to load %s: %s\n", *vhostConfigFlag, err.Error())
+			os.Exit(1)
+		}
+		currentVirtualHosts.Store(hosts)
+		go handleReloadSignals(*vhostConfigFlag)
+	}
 
-	writer.WriteString(res)
-	writer.Write(body.Bytes())
-}
+	routes = newRoutes()
+	publicServer = newPublicServer()
 
-// handleFileRequest will handle requests for files
-func handleFileRequest(reader *bufio.Reader, writer *bufio.Writer, method string, lines []string, path string) {
-	if len(os.Args) != 3 || os.Args[1] != "--directory" {
-		fmt.Println("Flag --directory <directory> is required")
+	var tlsConfig *tls.Config
+	if *tlsCertFlag != "" || *tlsKeyFlag != "" {
+		if *tlsCertFlag == "" || *tlsKeyFlag == "" {
+			fmt.Println("Flags --tls-cert and --tls-key must be set together")
+			os.Exit(1)
+		}
+		config, err := loadTLSConfig(*tlsCertFlag, *tlsKeyFlag, *tlsClientCAFlag)
+		if err != nil {
+			fmt.Printf("Failed to load TLS certificate: %s\n", err.Error())
+			os.Exit(1)
+		}
+		tlsConfig = config
+	}
+	if *tlsClientCAFlag != "" && tlsConfig == nil {
+		fmt.Println("Flag --tls-client-ca requires --tls-cert and --tls-key")
 		os.Exit(1)
 	}
-
-	directory := os.Args[2]
-	_, err := os.Stat(directory)
-	if os.IsNotExist(err) {
-		fmt.Println("Directory does not exist")
+	if *httpsRedirectFlag && (tlsConfig == nil || *tlsPortFlag == 0) {
+		fmt.Println("Flag --https-redirect requires --tls-cert, --tls-key, and --tls-port")
 		os.Exit(1)
 	}
 
-	filePath := fmt.Sprintf("%s%s", directory, strings.TrimPrefix(path, "files/"))
+	if *adminSocketFlag != "" {
+		if err := listenAdminSocket(*adminSocketFlag); err != nil {
+			fmt.Printf("Failed to listen on admin socket: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer os.Remove(*adminSocketFlag)
+	}
 
-	switch method {
-	case "GET":
-		file, err := os.Open(filePath)
+	// Plaintext is served on --port unless TLS takes that port over, TLS on --tls-port next to it
+	if tlsConfig == nil || *tlsPortFlag != 0 {
+		publicListeners = append(publicListeners, listenPublic(listenAddr(*portFlag), nil))
+	}
+	if tlsConfig != nil {
+		port := *tlsPortFlag
+		if port == 0 {
+			port = *portFlag
+		}
+		publicListeners = append(publicListeners, listenPublic(listenAddr(port), tlsConfig))
+	}
+
+	var workers chan<- net.Conn
+	if *workersFlag > 0 {
+		workers = startWorkers(*workersFlag)
+		defer close(workers)
+	}
+
+	var accepting sync.WaitGroup
+	for _, l := range publicListeners {
+		accepting.Add(1)
+		go func() {
+			defer accepting.Done()
+			acceptConnections(l, workers)
+		}()
+	}
+	accepting.Wait()
+
+	waitForConnections()
+}
+
+// acceptConnections will accept connections on the public listener l until drain closes it, handing
+// them to the workers when there is a pool
+func acceptConnections(l net.Listener, workers chan<- net.Conn) {
+	for {
+		conn, err := l.Accept()
 		if err != nil {
-			writer.WriteString(StatusNotFound)
+			if draining.Load() {
+				return
+			}
+			fmt.Printf("Error accepting connection: %s\n", err.Error())
+			continue
+		}
+
+		acceptedConnections.Add(1)
+		if active := activeConnections.Add(1); *maxConnectionsFlag > 0 && active > int64(*maxConnectionsFlag) {
+			activeConnections.Add(-1)
+			rejectConnection(conn)
+			continue
+		}
+		connections.Add(1)
+		if workers != nil {
+			workers <- conn
+		} else {
+			go serveConnection(conn)
+		}
+	}
+}
+
+// handledUpgrades lists the protocols a client may ask to switch to with Upgrade, requests for any
+// other protocol lose their Upgrade field
+var handledUpgrades = []string{"websocket"}
+
+// publicServer serves the requests on public connections, main sets it up once the flags are parsed
+var publicServer *httpserver.Server
+
+// newPublicServer returns the engine serving public connections with the limits and timeouts of the flags
+func newPublicServer() *httpserver.Server {
+	return httpserver.New(httpserver.Config{
+		Handler:         serveRequest,
+		ReadTimeout:     *readTimeoutFlag,
+		IdleTimeout:     *idleTimeoutFlag,
+		WriteTimeout:    *writeTimeoutFlag,
+		MaxHeaderBytes:  *maxHeaderSizeFlag,
+		MaxHeaderFields: maxHeaderFields,
+		MaxBodySize:     *maxBodySizeFlag,
+		ReadBufferSize:  *readBufferFlag,
+		WriteBufferSize: *writeBufferFlag,
+		Upgrades:        handledUpgrades,
+		WriteError:      writeHTTPError,
+		ConnState:       setConnectionState,
+		RequestDone:     requestDone,
+		Logf:            func(format string, args ...any) { fmt.Printf(format, args...) },
+	})
+}
+
+// handleConnection handles the incoming connection, serving requests on it until the client or a
+// response asks to close it, or the client stays idle for longer than --idle-timeout
+func handleConnection(conn net.Conn) {
+	// Direct clients over their limit are dropped before anything is read, clients behind a
+	// trusted proxy are only known once the request's X-Forwarded-For has been parsed
+	peer := remoteIP(conn)
+	if !isTrustedProxy(peer) {
+		if !acquireIPSlot(peer) {
+			fmt.Printf("Closed connection from %s: too many concurrent connections\n", peer)
+			conn.Close()
 			return
 		}
-		defer file.Close()
+		defer releaseIPSlot(peer)
+	}
 
-		fileInfo, err := file.Stat()
+	if *recordFlag != "" {
+		rec, err := startRecording(*recordFlag)
 		if err != nil {
-			writer.WriteString(StatusInternalServerError)
-			return
+			fmt.Printf("Error starting recording: %s\n", err.Error())
+		} else {
+			defer rec.Close()
+			conn = rec.wrap(conn)
 		}
+	}
 
-		res := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", fileInfo.Size())
-		writer.WriteString(res)
+	handledConnections.Add(1)
+
+	untrack := trackConnection(conn)
+	defer untrack()
+
+	publicServer.ServeConn(conn)
+}
 
-		buffer := make([]byte, 4096)
-		for {
-			n, err := file.Read(buffer)
-			if err != nil {
-				break
+// serveRequest answers a request on a public connection, adding the CORS fields of --cors-origins to
+// its response
+func serveRequest(w *Response, r *Request) error {
+	handledRequests.Add(1)
+	requestsInFlight.Add(1)
+	defer requestsInFlight.Add(-1)
+	connTLS := requestTLS(r)
+	if info := connectionInfo(r.Conn); info != nil {
+		info.setRequest(r.RequestLine())
+		info.setTLS(connTLS)
+	}
+
+	if peer := remoteIP(r.Conn); isTrustedProxy(peer) {
+		client := forwardedClientIP(r, peer)
+		if !acquireIPSlot(client) {
+			r.CloseAfterResponse()
+			return tooManyConnections(client)
+		}
+		defer releaseIPSlot(client)
+	}
+
+	// The CORS fields are added to whatever response the request gets, errors included
+	if fields := corsFields(r); fields != nil {
+		writer := bufio.NewWriterSize(httpserver.WithFields(w.Raw(), fields...), *writeBufferFlag)
+		response := httpserver.NewResponse(writer)
+		if err := routeRequest(response, r, connTLS); err != nil {
+			writeHTTPError(writer, err)
+		} else {
+			response.Finish()
+		}
+		return writer.Flush()
+	}
+	return routeRequest(w, r, connTLS)
+}
+
+// routeRequest answers a request with the handler of its route. Before it is routed it may be redirected
+// to HTTPS, refused for maintenance or by chaos, or answered as a CORS preflight. Requests for a virtual
+// host of --config go to its routes, others may be answered from the response cache.
+func routeRequest(w *Response, r *Request, connTLS *tlsDetails) error {
+	hostRoutes := virtualHostRoutes(r.Header.Get("Host"))
+	switch {
+	case connTLS == nil && *httpsRedirectFlag:
+		return redirectToHTTPS(w.Raw(), r)
+	case inMaintenance() && !isMaintenanceExempt(r.RawPath):
+		return maintenanceError()
+	case !isMaintenanceExempt(r.RawPath) && chaosFail():
+		return chaosError()
+	case isPreflight(r):
+		return handlePreflight(w, r)
+	case hostRoutes != nil:
+		return hostRoutes.Serve(w, r)
+	case r.Method == "GET" && isCachedRoute(r.RawPath) && isCacheableRequest(r):
+		return withResponseCache(w.Raw(), r, r.Target, func(writer *bufio.Writer) error {
+			response := httpserver.NewResponse(writer)
+			if err := routes.Serve(response, r); err != nil {
+				return err
 			}
-			writer.Write(buffer[:n])
+			response.Finish()
+			return nil
+		})
+	default:
+		return routes.Serve(w, r)
+	}
+}
+
+// requestDone will record the answered request in the metrics and hand it to the access log
+func requestDone(r *Request, info httpserver.ResponseInfo) {
+	observeRequest(info)
+	logAccess(requestClient(r).String(), r, info)
+}
+
+// routes dispatches requests on the public listener to their handlers, main sets it up once the
+// flags are parsed
+var routes *Router
+
+// newRoutes returns a router with a route for every endpoint the flags enable
+func newRoutes() *Router {
+	router := httpserver.NewRouter()
+	router.Use(recoverPanics)
+	if *logRequestsFlag {
+		router.Use(logRequests)
+	}
+
+	if *staticFlag == "" {
+		router.Handle("GET", "/", func(w *Response, r *Request) error {
+			w.SetStatus(200)
+			return nil
+		})
+	}
+	router.Handle("GET", "/healthz", func(w *Response, r *Request) error {
+		handleHealthRequest(w.Raw())
+		return nil
+	})
+	if *stubStatusFlag {
+		router.Handle("GET", "/stub_status", func(w *Response, r *Request) error {
+			handleStubStatusRequest(w.Raw())
+			return nil
+		})
+	}
+	if *metricsFlag {
+		router.Handle("GET", "/metrics", func(w *Response, r *Request) error {
+			return handleMetricsRequest(w.Raw())
+		})
+	}
+	if *versionRouteFlag {
+		router.Handle("GET", "/version", func(w *Response, r *Request) error {
+			return handleVersionRequest(w.Raw())
+		})
+	}
+	router.Handle("GET", "/user-agent", withInternalRedirect(httpserver.WithContentEncoding(handleUserAgentRequest)))
+	router.Handle("GET", "/echo/{msg}", withInternalRedirect(httpserver.WithContentEncoding(handleEchoRequest)))
+	router.Handle("GET", "/ws", handleWebSocketRequest)
+	if filesDirectory() != "" {
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+			router.Handle(method, "/files/{name...}", func(w *Response, r *Request) error {
+				return withOIDC(w.Raw(), r, func(writer *bufio.Writer) error {
+					return withAPIKey(writer, r, func(writer *bufio.Writer) error {
+						return handleFileRequest(r.Body, writer, r.Method, r, r.RawPath, r.Query())
+					})
+				})
+			})
 		}
+	}
+	router.Handle("GET", "/oidc/callback", func(w *Response, r *Request) error {
+		return handleOIDCCallback(w.Raw(), r)
+	})
+	router.Handle("GET", "/usage", func(w *Response, r *Request) error {
+		return handleUsageRequest(w.Raw(), r)
+	})
+	router.Handle("*", "/admin/{path...}", func(w *Response, r *Request) error {
+		return handleAdminRequest(w.Raw(), r.Method, r, r.Param("path"), r.Query(), r.RemoteAddr)
+	})
+	router.Handle("GET", "/chaos/{fault}", func(w *Response, r *Request) error {
+		return handleChaosRequest(netConn(r.Conn), w.Raw(), r.Path, r.Query())
+	})
+	router.Handle("GET", "/progress/{id}", func(w *Response, r *Request) error {
+		return handleProgressRequest(w.Raw(), r.Param("id"))
+	})
+	if *tusPathFlag != "" {
+		router.Handle("*", "/"+*tusPathFlag+"/{id...}", func(w *Response, r *Request) error {
+			return withOIDC(w.Raw(), r, func(writer *bufio.Writer) error {
+				return withAPIKey(writer, r, func(writer *bufio.Writer) error {
+					return handleTusRequest(r.Body, writer, r.Method, r, r.Param("id"))
+				})
+			})
+		})
+	}
+	for _, proxy := range proxyRoutes {
+		router.Handle("*", "/"+proxy.prefix+"/{path...}", proxy.handle)
+	}
+	// Static files are served for whatever path no other route matched
+	if *staticFlag != "" {
+		router.Handle("GET", "/{path...}", withStaticAuth(handleStaticRequest))
+	}
+
+	return router
+}
+
+// maxHeaderFields bounds the number of header fields in a request
+const maxHeaderFields = 128
+
+// headerLimits bounds the head of multipart body parts like the engine bounds request heads, main sets its
+// MaxHeaderBytes from --max-header-size
+var headerLimits = httpparse.Limits{MaxHeaderFields: maxHeaderFields}
+
+// handleUserAgentRequest will handle requests for user-agent
+func handleUserAgentRequest(w *Response, r *Request) error {
+	w.SetHeader("Content-Type", "text/plain")
+	w.WriteString(r.Header.Get("User-Agent"))
+	return nil
+}
+
+// handleEchoRequest will handle requests for echo
+func handleEchoRequest(w *Response, r *Request) error {
+	word := r.Param("msg")
+	w.SetHeader("Content-Type", "text/plain")
+	w.WriteString(word)
+	return nil
+}
+
+// filesDirectory returns the directory files are served from, validateConfig has checked it exists.
+// It is empty when --directory isn't set, and the file routes aren't registered then.
+func filesDirectory() string {
+	return *directoryFlag
+}
+
+// handleFileRequest will handle requests for files, rawPath is the target's path as sent
+func handleFileRequest(reader *bufio.Reader, writer *bufio.Writer, method string, head *Request, rawPath string, query url.Values) error {
+	directory := filesDirectory()
+
+	// Escapes are checked before decoding, an encoded dot-dot or slash must not become a real one
+	rawName := strings.TrimPrefix(strings.TrimPrefix(rawPath, "files"), "/")
+	if err := safepath.CheckEscapes(rawName); err != nil {
+		return err
+	}
+	name := httpserver.DecodedPath(rawName)
+	filePath, err := safepath.Resolve(directory, name)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case "GET", "HEAD":
+		return serveFile(writer, head, filePath, query, filesServeOptions)
 	case "POST":
-		file, err := os.Create(filePath)
-		if err != nil {
-			writer.WriteString(StatusInternalServerError)
-			return
+		if boundary, ok := multipartBoundary(head); ok {
+			return handleMultipartUpload(reader, writer, head, directory, name, boundary, query)
 		}
-		defer file.Close()
+		return handleFileUpload(reader, writer, head, directory, name, query, false)
+	case "PUT":
+		return handleFileUpload(reader, writer, head, directory, name, query, true)
+	case "DELETE":
+		return handleFileDelete(writer, head, directory, name)
+	}
+	return ErrMethodNotAllowed
+}
 
-		contentLengthHeader := ""
-		for _, line := range lines {
-			if strings.HasPrefix(line, "Content-Length: ") {
-				contentLengthHeader = strings.TrimPrefix(line, "Content-Length: ")
-				contentLengthHeader = strings.TrimSpace(contentLengthHeader)
-				break
-			}
+// writeStreamedFile will write header and stream the file, encoded with encoder unless it is nil. With
+// --checksum-trailer a chunked body ends with an X-Checksum trailer holding the hex SHA-256 of the file's
+// unencoded content, which is only known once the whole file was read.
+func writeStreamedFile(writer *bufio.Writer, head *Request, header *responseHeader, file *os.File, encoder Encoder) error {
+	trailer := *checksumTrailerFlag && head.Proto == "HTTP/1.1"
+	if trailer {
+		header.add("Trailer", "X-Checksum")
+	}
+	body, end := startStreamedBody(writer, head, header)
+	// A HEAD response has the same head, the file isn't read or hashed for a body that is never sent
+	if head.Method == "HEAD" {
+		end()
+		return nil
+	}
+
+	var dst io.Writer = body
+	var encoded io.WriteCloser
+	if encoder != nil {
+		encoded = encoder.NewWriter(body)
+		dst = encoded
+	}
+	checksum := sha256.New()
+	if _, err := copyAll(dst, io.TeeReader(file, checksum)); err != nil {
+		fmt.Printf("Error streaming %s: %s\n", file.Name(), err.Error())
+		head.CloseAfterResponse()
+		return nil
+	}
+	if encoded != nil {
+		encoded.Close()
+	}
+
+	if trailer {
+		body.(*chunkedWriter).CloseWithTrailer("X-Checksum", hex.EncodeToString(checksum.Sum(nil)))
+		return nil
+	}
+	end()
+	return nil
+}
+
+// serveFile will respond with the file or directory at filePath using the serving options of its mount
+func serveFile(writer *bufio.Writer, head *Request, filePath string, query url.Values, options fileServeOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ErrNotFound
+	}
+	defer file.Close()
+
+	fileInfo, err := statFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.IsDir() {
+		switch {
+		case query.Get("format") != "":
+			return handleArchiveRequest(writer, head, filePath, query.Get("format"))
+		case strings.Contains(head.Header.Get("Accept"), "application/json"):
+			return handleListingRequest(writer, head, filePath, query)
+		case strings.Contains(head.Header.Get("Accept"), "text/html"):
+			target, _, _ := strings.Cut(head.Target, "?")
+			return handleHTMLListingRequest(writer, head, filePath, target, query)
+		default:
+			return ErrNotFound
 		}
+	}
 
-		if contentLengthHeader == "" {
-			writer.WriteString(StatusBadRequest)
-			return
+	// Text files are encoded as they are read, so the encoded length is only known at the end. The
+	// coding is negotiated first since each one is a representation with its own entity tag.
+	size := fileInfo.Size()
+	identityETag, modified := fileETag(fileInfo), fileInfo.ModTime()
+	compressible := size >= minEncodedFileSize && isCompressible(fileInfo.Name())
+	var encoder Encoder
+	if compressible {
+		if encoder, err = httpserver.NegotiateEncoding(head); err != nil {
+			return err
 		}
+	}
+	etag := identityETag
+	if encoder != nil {
+		etag = encodedETag(identityETag, encoder.Name())
+	}
+
+	notModified, err := checkPreconditions(head, identityETag, modified, true)
+	if err != nil {
+		return err
+	}
+	if notModified {
+		writeNotModified(writer, etag, modified)
+		return nil
+	}
 
-		contentLength, err := strconv.Atoi(contentLengthHeader)
+	// Ranges are always served unencoded
+	part, partial := byteRange{start: 0, length: size}, false
+	if value := head.Header.Get("Range"); value != "" && ifRangeMatches(head.Header.Get("If-Range"), identityETag, modified) {
+		r, ok, err := parseRange(value, size)
 		if err != nil {
-			writer.WriteString(StatusBadRequest)
-			return
+			return err
+		}
+		if ok {
+			part, partial = r, true
+			encoder, etag = nil, identityETag
 		}
+	}
 
-		if contentLength > 0 {
-			buffer := make([]byte, 4096)
-			remaining := contentLength
-			for remaining > 0 {
-				n, err := reader.Read(buffer)
-				if err != nil && err != io.EOF {
-					writer.WriteString(StatusInternalServerError)
-					return
-				}
-				if n == 0 {
-					break
-				}
-
-				if _, err := file.Write(buffer[:n]); err != nil {
-					writer.WriteString(StatusInternalServerError)
-					return
-				}
-
-				remaining -= n
-			}
+	status := "200 OK"
+	if partial {
+		status = "206 Partial Content"
+	}
+	header := newResponseHeader(status).
+		add("Content-Type", options.contentType(fileInfo.Name())).
+		add("Accept-Ranges", "bytes").
+		add("ETag", etag).
+		add("Last-Modified", httpDate(modified))
+	if partial {
+		header.add("Content-Range", part.contentRange(size))
+	}
+	if options.immutable && isHashedAssetName(fileInfo.Name()) {
+		header.add("Cache-Control", immutableCacheControl)
+	}
+
+	if compressible && !partial {
+		header.add("Vary", "Accept-Encoding")
+		if encoder != nil {
+			header.add("Content-Encoding", encoder.Name())
+			return writeStreamedFile(writer, head, header, file, encoder)
 		}
+	}
+	// A whole file is streamed chunked when its checksum is to follow it
+	if *checksumTrailerFlag && !partial && head.Proto == "HTTP/1.1" {
+		return writeStreamedFile(writer, head, header, file, nil)
+	}
+
+	header.addInt("Content-Length", part.length).write(writer, nil)
 
-		writer.WriteString(StatusCreated)
+	// A body cut short leaves the client waiting for the rest, so the connection is closed after it
+	if head.Method != "HEAD" {
+		if err := writeFileBody(writer, file, part.start, part.length, options); err != nil {
+			head.CloseAfterResponse()
+		}
 	}
+	return nil
 }
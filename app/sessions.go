@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionSweepInterval is how often expired sessions are removed from stores that don't expire them on their own
+const sessionSweepInterval = time.Minute
+
+// SessionStore keeps session data on the server, keyed by the session ID held in the client's cookie
+type SessionStore interface {
+	// Get returns the data of an unexpired session, ok is false when there is no such session
+	Get(id string) (data []byte, ok bool, err error)
+	// Set stores the data of a session for ttl
+	Set(id string, data []byte, ttl time.Duration) error
+	// Delete removes a session
+	Delete(id string) error
+	// DeleteExpired removes every expired session
+	DeleteExpired() error
+}
+
+// sessions is the store chosen with --session-store
+var sessions SessionStore
+
+// openSessionStore opens the store described by spec: "memory", "file:<directory>", or "redis://[:password@]host:port[/db]"
+func openSessionStore(spec string) (SessionStore, error) {
+	switch {
+	case spec == "memory":
+		return &memorySessionStore{sessions: map[string]storedSession{}}, nil
+	case strings.HasPrefix(spec, "file:"):
+		dir := strings.TrimPrefix(spec, "file:")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		return &fileSessionStore{dir: dir}, nil
+	case strings.HasPrefix(spec, "redis://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		return newRedisSessionStore(u)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", spec)
+	}
+}
+
+// sweepSessions periodically removes expired sessions from store
+func sweepSessions(store SessionStore) {
+	for range time.Tick(sessionSweepInterval) {
+		if err := store.DeleteExpired(); err != nil {
+			fmt.Printf("Error removing expired sessions: %s\n", err.Error())
+		}
+	}
+}
+
+// storedSession is a session as kept by the memory and file stores
+type storedSession struct {
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+// memorySessionStore keeps sessions in process, they are lost on restart
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]storedSession
+}
+
+// Get implements SessionStore
+func (s *memorySessionStore) Get(id string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		return nil, false, nil
+	}
+	return session.Data, true, nil
+}
+
+// Set implements SessionStore
+func (s *memorySessionStore) Set(id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[id] = storedSession{Data: data, Expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Delete implements SessionStore
+func (s *memorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// DeleteExpired implements SessionStore
+func (s *memorySessionStore) DeleteExpired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// fileSessionStore keeps every session as a JSON file in dir, so sessions survive restarts and can be
+// shared by instances mounting the same directory
+type fileSessionStore struct {
+	dir string
+}
+
+// path returns the file of session id, rejecting IDs that aren't safe file names
+func (s *fileSessionStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", errors.New("invalid session ID")
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Get implements SessionStore
+func (s *fileSessionStore) Get(id string) ([]byte, bool, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	session, err := readStoredSession(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if time.Now().After(session.Expires) {
+		return nil, false, nil
+	}
+	return session.Data, true, nil
+}
+
+// Set implements SessionStore
+func (s *fileSessionStore) Set(id string, data []byte, ttl time.Duration) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(storedSession{Data: data, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename so readers never see a partial session
+	tmp, err := os.CreateTemp(s.dir, ".session-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete implements SessionStore
+func (s *fileSessionStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpired implements SessionStore
+func (s *fileSessionStore) DeleteExpired() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		if session, err := readStoredSession(path); err == nil && now.After(session.Expires) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// readStoredSession reads a session file written by fileSessionStore.Set
+func readStoredSession(path string) (storedSession, error) {
+	var session storedSession
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return session, err
+	}
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// auditLogSize is how many setting changes are kept in memory for GET /admin/settings/audit
+const auditLogSize = 100
+
+// setting is a flag that stays safe to read while it is changed at runtime through the admin API
+type setting[T any] struct {
+	value atomic.Pointer[T]
+	parse func(string) (T, error)
+}
+
+// runtimeSetting is a value the admin API may change while the server runs
+type runtimeSetting interface {
+	flag.Value
+	// Validate reports whether Set would accept value, without changing anything
+	Validate(value string) error
+}
+
+// runtimeSettings are the settings the admin API may change, keyed by flag name
+var runtimeSettings = map[string]runtimeSetting{
+	"maintenance": maintenanceSetting{},
+}
+
+// newSetting defines a flag whose value can also be changed at runtime, parse validates every new value
+func newSetting[T any](name string, value T, usage string, parse func(string) (T, error)) *setting[T] {
+	s := &setting[T]{parse: parse}
+	s.value.Store(&value)
+	flag.Var(s, name, usage)
+	runtimeSettings[name] = s
+	return s
+}
+
+// Get returns the current value
+func (s *setting[T]) Get() T {
+	return *s.value.Load()
+}
+
+// String implements flag.Value
+func (s *setting[T]) String() string {
+	if s == nil || s.value.Load() == nil {
+		return ""
+	}
+	return fmt.Sprint(s.Get())
+}
+
+// Set implements flag.Value
+func (s *setting[T]) Set(value string) error {
+	v, err := s.parse(value)
+	if err != nil {
+		return err
+	}
+	s.value.Store(&v)
+	return nil
+}
+
+// Validate implements runtimeSetting
+func (s *setting[T]) Validate(value string) error {
+	_, err := s.parse(value)
+	return err
+}
+
+// maintenanceSetting exposes the maintenance mode as a runtime setting
+type maintenanceSetting struct{}
+
+// String implements flag.Value
+func (maintenanceSetting) String() string {
+	return strconv.FormatBool(maintenanceMode.Load())
+}
+
+// Set implements flag.Value
+func (maintenanceSetting) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	maintenanceMode.Store(enabled)
+	return nil
+}
+
+// Validate implements runtimeSetting
+func (maintenanceSetting) Validate(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// parseNonNegativeInt parses a setting that must be an integer of at least zero
+func parseNonNegativeInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = errors.New("must not be negative")
+	}
+	return n, err
+}
+
+// parseNonNegativeDuration parses a setting that must be a duration of at least zero
+func parseNonNegativeDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = errors.New("must not be negative")
+	}
+	return d, err
+}
+
+// parseFraction parses a setting that must be a number between 0 and 1
+func parseFraction(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err == nil && (f < 0 || f > 1) {
+		err = errors.New("must be between 0 and 1")
+	}
+	return f, err
+}
+
+// auditEntry records a single runtime setting change
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Setting string    `json:"setting"`
+	Old     string    `json:"old"`
+	New     string    `json:"new"`
+}
+
+var (
+	auditMu  sync.Mutex
+	auditLog []auditEntry
+)
+
+// recordAudit keeps the change in memory and appends it to --audit-log when set
+func recordAudit(entry auditEntry) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	auditLog = append(auditLog, entry)
+	if len(auditLog) > auditLogSize {
+		auditLog = auditLog[len(auditLog)-auditLogSize:]
+	}
+
+	if *auditLogFlag == "" {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(*auditLogFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("Error opening audit log: %s\n", err.Error())
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(line, '\n'))
+}
+
+// handleSettingsRequest will respond with the runtime settings, changing the ones given as query
+// parameters first. Every value is validated before any of them is applied.
+func handleSettingsRequest(writer *bufio.Writer, method string, query url.Values, actor string) error {
+	if method == "POST" {
+		for name, values := range query {
+			value, ok := runtimeSettings[name]
+			if !ok || len(values) != 1 {
+				return ErrBadRequest.WithDetail(name + " is not a runtime setting")
+			}
+			if err := value.Validate(values[0]); err != nil {
+				return ErrBadRequest.WithDetail(fmt.Sprintf("invalid value for %s: %s", name, err.Error()))
+			}
+		}
+
+		names := make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			value := runtimeSettings[name]
+			old := value.String()
+			value.Set(query.Get(name))
+			recordAudit(auditEntry{Time: time.Now().UTC(), Actor: actor, Setting: name, Old: old, New: value.String()})
+		}
+	}
+
+	current := map[string]string{}
+	for name, value := range runtimeSettings {
+		current[name] = value.String()
+	}
+	return writeJSON(writer, current)
+}
+
+// handleAuditRequest will respond with the most recent runtime setting changes
+func handleAuditRequest(writer *bufio.Writer) error {
+	auditMu.Lock()
+	entries := slices.Clone(auditLog)
+	auditMu.Unlock()
+
+	if entries == nil {
+		entries = []auditEntry{}
+	}
+	return writeJSON(writer, entries)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// handleShutdownSignals will drain the server on SIGINT or SIGTERM. A second signal closes the
+// connections still in flight right away instead of waiting out --shutdown-grace.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	fmt.Printf("Received %s, draining connections\n", sig)
+	drain()
+
+	sig = <-signals
+	fmt.Printf("Received %s again, closing connections\n", sig)
+	abandonConnections()
+}
+
+var (
+	// abandoned is closed once the connections in flight have been given up on, main stops waiting for
+	// their handlers then
+	abandoned     = make(chan struct{})
+	abandonedOnce sync.Once
+)
+
+// abandonConnections will close every public connection in flight and stop main from waiting for them
+func abandonConnections() int {
+	n := 0
+	abandonedOnce.Do(func() {
+		n = closeConnections(false)
+		close(abandoned)
+	})
+	return n
+}
+
+// waitForConnections will wait until the public connections in flight are done or abandoned
+func waitForConnections() {
+	done := make(chan struct{})
+	go func() {
+		connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-abandoned:
+	}
+}
+
+// closeConnections closes the public connections in flight, or with idleOnly only those waiting for
+// another request, and returns how many it closed. Idle connections are woken with an expired read
+// deadline instead of being closed under the goroutine serving them, so they are still closed cleanly.
+func closeConnections(idleOnly bool) int {
+	closed := 0
+	connTable.Range(func(key, value any) bool {
+		conn, info := key.(net.Conn), value.(*connInfo)
+		if state := info.state.Load(); state != nil && *state == connIdle {
+			conn.SetReadDeadline(time.Now())
+			closed++
+		} else if !idleOnly {
+			conn.Close()
+			closed++
+		}
+		return true
+	})
+	return closed
+}
+
+// forceCloseAfterGrace will close the connections still in flight once --shutdown-grace has passed,
+// a zero grace period waits for them indefinitely
+func forceCloseAfterGrace() {
+	if *shutdownGraceFlag <= 0 {
+		return
+	}
+
+	time.AfterFunc(*shutdownGraceFlag, func() {
+		if n := abandonConnections(); n > 0 {
+			fmt.Printf("Shutdown grace period over, closed %d connections\n", n)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/safepath"
+)
+
+// staticIndexName is the file served for a request for a --static directory
+const staticIndexName = "index.html"
+
+// staticServeOptions are the serving options of the --static directory
+var staticServeOptions fileServeOptions
+
+// handleStaticRequest will serve the file under the --static directory the request path names. A
+// directory is served by its index.html, or by an HTML listing with --static-listing.
+func handleStaticRequest(w *Response, r *Request) error {
+	return serveStaticDirectory(w, r, *staticFlag, r.Path, *staticListingFlag)
+}
+
+// withStaticAuth wraps a static file handler in the session and API key checks of the files routes
+// when --protect-static is set, and returns it unchanged otherwise
+func withStaticAuth(handler Handler) Handler {
+	if !*protectStaticFlag {
+		return handler
+	}
+	return func(w *Response, r *Request) error {
+		return withOIDC(w.Raw(), r, func(writer *bufio.Writer) error {
+			return withAPIKey(writer, r, func(writer *bufio.Writer) error {
+				response := httpserver.NewResponse(writer)
+				if err := handler(response, r); err != nil {
+					return err
+				}
+				response.Finish()
+				return nil
+			})
+		})
+	}
+}
+
+// serveStaticDirectory will serve the file under root that path names, like handleStaticRequest does
+// for --static and listing directories when listing is set
+func serveStaticDirectory(w *Response, r *Request, root string, path string, listing bool) error {
+	if err := safepath.CheckEscapes(r.RawPath); err != nil {
+		return err
+	}
+	filePath, err := safepath.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ErrNotFound
+	}
+	if !info.IsDir() {
+		return serveFile(w.Raw(), r, filePath, r.Query(), staticServeOptions)
+	}
+
+	// Relative links in an index or listing only resolve correctly below a URL ending in a slash
+	target, rawQuery, hasQuery := strings.Cut(r.Target, "?")
+	if !strings.HasSuffix(target, "/") {
+		location := target + "/"
+		if hasQuery {
+			location += "?" + rawQuery
+		}
+		w.SetStatus(301)
+		w.SetHeader("Location", location)
+		return nil
+	}
+
+	index := filepath.Join(filePath, staticIndexName)
+	if info, err := os.Stat(index); err == nil && info.Mode().IsRegular() {
+		return serveFile(w.Raw(), r, index, r.Query(), staticServeOptions)
+	}
+	if listing {
+		return handleHTMLListingRequest(w.Raw(), r, filePath, target, r.Query())
+	}
+	return ErrNotFound
+}
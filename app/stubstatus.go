@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// handleStubStatusRequest will respond with the connection counters in the plaintext format of nginx's
+// stub_status module, so monitoring scripts written against nginx can scrape this server unchanged
+func handleStubStatusRequest(writer *bufio.Writer) {
+	active := activeConnections.Load()
+	reading := readingConnections.Load()
+	writing := writingConnections.Load()
+
+	body := fmt.Sprintf("Active connections: %d \nserver accepts handled requests\n %d %d %d \nReading: %d Writing: %d Waiting: %d \n",
+		active, acceptedConnections.Load(), handledConnections.Load(), handledRequests.Load(),
+		reading, writing, max(active-reading-writing, 0))
+
+	newResponseHeader("200 OK").
+		add("Content-Type", "text/plain").
+		add("Cache-Control", "no-store").
+		addInt("Content-Length", int64(len(body))).
+		writeString(writer, body)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog severities used by the server
+const (
+	severityError = 3
+	severityInfo  = 6
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends RFC 5424 messages to a syslog daemon over UDP, TCP (with octet counting framing),
+// or a unix datagram socket, reconnecting when a send fails
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogWriter parses a destination like udp://host:514, tcp://host:601, or unixgram:///dev/log
+func newSyslogWriter(destination string, facility string) (*syslogWriter, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &syslogWriter{network: u.Scheme, facility: code, appName: filepath.Base(os.Args[0])}
+	switch u.Scheme {
+	case "udp", "tcp":
+		w.address = u.Host
+	case "unixgram":
+		w.address = u.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog transport %q", u.Scheme)
+	}
+
+	if w.hostname, err = os.Hostname(); err != nil || w.hostname == "" {
+		w.hostname = "-"
+	}
+	return w, nil
+}
+
+// send formats a message with the given severity and message ID and delivers it, retrying once on a
+// fresh connection. Failures are reported on stderr since the log itself is what failed.
+func (w *syslogWriter) send(severity int, msgID string, message string) {
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		w.facility*8+severity, time.Now().UTC().Format(time.RFC3339Nano), w.hostname, w.appName, os.Getpid(), msgID, message)
+	if w.network == "tcp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			conn, err := net.DialTimeout(w.network, w.address, 5*time.Second)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error connecting to syslog: %s\n", err.Error())
+				return
+			}
+			w.conn = conn
+		}
+
+		w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := w.conn.Write([]byte(line)); err == nil {
+			return
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	fmt.Fprintf(os.Stderr, "Error sending to syslog, dropped: %s\n", message)
+}
+
+// redirectStdoutToSyslog replaces os.Stdout with a pipe whose lines are sent to w as error messages,
+// so every log line the server prints ends up in syslog
+func redirectStdoutToSyslog(w *syslogWriter) error {
+	r, pipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = pipe
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				w.send(severityError, "error", line)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "crypto/tls"
+
+// tlsDetails describes the security of a TLS connection
+type tlsDetails struct {
+	Version    string
+	Cipher     string
+	ServerName string
+	// Protocol is the ALPN protocol, empty when none was negotiated
+	Protocol string
+	// ClientSubject is the subject of the client certificate, empty without one
+	ClientSubject string
+	// ClientVerified reports whether the client certificate chained to a CA of --tls-client-ca
+	ClientVerified bool
+}
+
+// requestTLS returns the TLS details of the connection r arrived on, or nil when it came in plaintext,
+// for handlers and the access log to decide on transport security
+func requestTLS(r *Request) *tlsDetails {
+	if r.TLS == nil {
+		return nil
+	}
+
+	state := r.TLS
+	details := &tlsDetails{
+		Version:        tls.VersionName(state.Version),
+		Cipher:         tls.CipherSuiteName(state.CipherSuite),
+		ServerName:     state.ServerName,
+		Protocol:       state.NegotiatedProtocol,
+		ClientVerified: len(state.VerifiedChains) > 0,
+	}
+	if len(state.PeerCertificates) > 0 {
+		details.ClientSubject = state.PeerCertificates[0].Subject.String()
+	}
+	return details
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadTLSConfig returns the configuration of the HTTPS listener, serving the PEM encoded certificate
+// chain in certFile with the private key in keyFile. With clientCAFile, clients may present a certificate,
+// which must chain to one of the PEM encoded CAs in it.
+func loadTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"http/1.1"},
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificates in " + clientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// listenPublic returns a listener for the public address, accepting TLS connections when config is set,
+// exiting if the address can't be bound
+func listenPublic(addr string, config *tls.Config) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Printf("Failed to bind to %s: %s\n", addr, err.Error())
+		os.Exit(1)
+	}
+
+	if config != nil {
+		return tls.NewListener(l, config)
+	}
+	return l
+}
+
+// redirectToHTTPS will redirect a plaintext request to the same target on the HTTPS port. The redirect
+// is permanent and keeps the method, so uploads are sent again over TLS.
+func redirectToHTTPS(writer *bufio.Writer, head *Request) error {
+	host := head.Header.Get("Host")
+	if host == "" {
+		return ErrBadRequest
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	authority := net.JoinHostPort(host, strconv.Itoa(*tlsPortFlag))
+	if *tlsPortFlag == 443 {
+		authority = strings.TrimSuffix(authority, ":443")
+	}
+
+	newResponseHeader("308 Permanent Redirect").
+		add("Location", "https://"+authority+head.Target).
+		add("Content-Length", "0").
+		write(writer, nil)
+	return nil
+}
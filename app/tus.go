@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration"
+
+	// tusDirName is the directory inside the files directory holding unfinished uploads
+	tusDirName = ".tus"
+)
+
+// tusUpload is the persisted state of an unfinished tus upload, the offset is the size of its data file
+type tusUpload struct {
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// tusLocks holds a *sync.Mutex per upload ID so concurrent PATCH requests can't interleave writes
+var tusLocks sync.Map
+
+// handleTusRequest will handle requests for the tus resumable upload endpoint, id is empty for the endpoint itself
+func handleTusRequest(reader *bufio.Reader, writer *bufio.Writer, method string, head *Request, id string) error {
+	if method == "OPTIONS" {
+		newResponseHeader("204 No Content").
+			add("Tus-Resumable", tusVersion).
+			add("Tus-Version", tusVersion).
+			add("Tus-Extension", tusExtensions).
+			write(writer, nil)
+		return nil
+	}
+
+	if head.Header.Get("Tus-Resumable") != tusVersion {
+		return ErrPreconditionFailed.WithHeader("Tus-Version", tusVersion)
+	}
+
+	dir := filepath.Join(filesDirectory(), tusDirName)
+
+	switch {
+	case id == "" && method == "POST":
+		return handleTusCreate(writer, head, dir)
+	case id != "" && !isTusID(id):
+		return ErrNotFound
+	case id != "" && method == "HEAD":
+		return handleTusHead(writer, dir, id)
+	case id != "" && method == "PATCH":
+		return handleTusPatch(reader, writer, head, dir, id)
+	default:
+		return ErrNotFound
+	}
+}
+
+// handleTusCreate will handle the creation of a new upload
+func handleTusCreate(writer *bufio.Writer, head *Request, dir string) error {
+	length, err := strconv.ParseInt(head.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return ErrBadRequest
+	}
+
+	metadata, err := parseTusMetadata(head.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		return err
+	}
+
+	// The whole upload is reserved up front so it can't run out of space halfway through
+	if !filesQuota.reserve(length) {
+		return ErrInsufficientStorage
+	}
+
+	upload := tusUpload{
+		Length:   length,
+		Metadata: metadata,
+		Expires:  time.Now().Add(*tusExpiryFlag).UTC(),
+	}
+
+	if err := os.WriteFile(tusDataPath(dir, id), nil, 0644); err != nil {
+		filesQuota.release(length)
+		return err
+	}
+	if err := writeTusUpload(dir, id, upload); err != nil {
+		os.Remove(tusDataPath(dir, id))
+		filesQuota.release(length)
+		return err
+	}
+
+	newResponseHeader("201 Created").
+		add("Tus-Resumable", tusVersion).
+		add("Location", "/"+*tusPathFlag+"/"+id).
+		add("Upload-Expires", httpDate(upload.Expires)).
+		add("Content-Length", "0").
+		write(writer, nil)
+	return nil
+}
+
+// handleTusHead will report the current offset of an upload
+func handleTusHead(writer *bufio.Writer, dir string, id string) error {
+	upload, offset, err := readTusUpload(dir, id)
+	if err != nil {
+		return tusNotFound(err)
+	}
+
+	newResponseHeader("200 OK").
+		add("Tus-Resumable", tusVersion).
+		addInt("Upload-Offset", offset).
+		addInt("Upload-Length", upload.Length).
+		add("Upload-Expires", httpDate(upload.Expires)).
+		add("Cache-Control", "no-store").
+		write(writer, nil)
+	return nil
+}
+
+// handleTusPatch will append the request body to an upload at the offset given by the client
+func handleTusPatch(reader *bufio.Reader, writer *bufio.Writer, head *Request, dir string, id string) error {
+	if head.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return ErrUnsupportedMedia
+	}
+
+	clientOffset, err := strconv.ParseInt(head.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	contentLength, err := strconv.ParseInt(head.Header.Get("Content-Length"), 10, 64)
+	if err != nil || contentLength < 0 {
+		return ErrBadRequest
+	}
+
+	// Only uploads that exist get a lock, a lock for every ID asked about would never be removed
+	if _, _, err := readTusUpload(dir, id); err != nil {
+		return tusNotFound(err)
+	}
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return ErrConflict
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	upload, offset, err := readTusUpload(dir, id)
+	if err != nil {
+		return tusNotFound(err)
+	}
+
+	if clientOffset != offset {
+		return ErrConflict
+	}
+	if offset+contentLength > upload.Length {
+		return ErrBadRequest
+	}
+
+	file, err := os.OpenFile(tusDataPath(dir, id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Whatever arrived before a dropped connection is kept, that is the point of resuming
+	progress := trackUpload(id, upload.Length, offset)
+	defer progress.finish()
+
+	n, copyErr := copyN(file, progress.reader(reader), contentLength)
+	offset += n
+	if copyErr != nil {
+		fmt.Printf("Error reading tus upload %s: %s\n", id, copyErr.Error())
+		return bodyError(copyErr)
+	}
+
+	if offset == upload.Length {
+		if err := completeTusUpload(file, dir, id, upload); err != nil {
+			return err
+		}
+	}
+
+	newResponseHeader("204 No Content").
+		add("Tus-Resumable", tusVersion).
+		addInt("Upload-Offset", offset).
+		add("Upload-Expires", httpDate(upload.Expires)).
+		write(writer, nil)
+	return nil
+}
+
+// completeTusUpload validates a finished upload and moves it into the files directory under its
+// metadata filename (or its ID), returning the error to answer the last PATCH with when that fails
+func completeTusUpload(file *os.File, dir string, id string, upload tusUpload) error {
+	name := upload.Metadata["filename"]
+	if name == "" {
+		name = id
+	}
+
+	segments, err := splitUploadPath(name)
+	if err == nil {
+		segments, err = uploadNameSanitizer.sanitizeSegments(segments)
+	}
+	if err != nil {
+		return ErrBadRequest.WithDetail(err.Error())
+	}
+
+	if err := validateUpload(file, segments[len(segments)-1], upload.Length); err != nil {
+		return ErrUnprocessable.WithDetail(err.Error())
+	}
+
+	root := filepath.Dir(dir)
+	if err := ensureParentDirs(root, segments[:len(segments)-1], *createDirFlag); err != nil {
+		fmt.Printf("Error creating parent directories: %s\n", err.Error())
+		return ErrConflict
+	}
+
+	if err := commitUpload(tusDataPath(dir, id), filepath.Join(root, filepath.Join(segments...)), false); err != nil {
+		fmt.Printf("Error committing tus upload %s: %s\n", id, err.Error())
+		return conflictError(false)
+	}
+
+	removeTusUpload(dir, id)
+	return nil
+}
+
+// expireTusUploads periodically removes unfinished uploads that have passed their expiration time
+func expireTusUploads() {
+	for range time.Tick(time.Minute) {
+		dir := filepath.Join(*directoryFlag, tusDirName)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			id, ok := strings.CutSuffix(entry.Name(), ".json")
+			if !ok {
+				continue
+			}
+			if upload, _, err := readTusUpload(dir, id); errors.Is(err, errTusExpired) {
+				removeTusUpload(dir, id)
+				filesQuota.release(upload.Length)
+			}
+		}
+	}
+}
+
+// reserveTusUploads will reserve the whole length of the unfinished uploads in dir, as creating them did
+// before a restart. The usage computed at startup only counts the bytes on disk, and expiring an upload
+// releases its whole length.
+func reserveTusUploads(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		upload, _, err := readTusUpload(dir, id)
+		if err != nil && !errors.Is(err, errTusExpired) {
+			continue
+		}
+		data, err := os.Stat(tusDataPath(dir, id))
+		if err != nil {
+			continue
+		}
+		state, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// The state file was counted too, but nothing reserved it or releases it
+		filesQuota.used.Add(upload.Length - data.Size() - state.Size())
+	}
+}
+
+// errTusExpired is returned by readTusUpload for uploads past their expiration time
+var errTusExpired = errors.New("upload expired")
+
+// readTusUpload loads the state of an upload and its current offset
+func readTusUpload(dir string, id string) (tusUpload, int64, error) {
+	var upload tusUpload
+
+	data, err := os.ReadFile(tusInfoPath(dir, id))
+	if err != nil {
+		return upload, 0, err
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+
+	if time.Now().After(upload.Expires) {
+		return upload, 0, errTusExpired
+	}
+
+	info, err := os.Stat(tusDataPath(dir, id))
+	if err != nil {
+		return upload, 0, err
+	}
+
+	return upload, info.Size(), nil
+}
+
+// writeTusUpload persists the state of an upload
+func writeTusUpload(dir string, id string, upload tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusInfoPath(dir, id), data, 0644)
+}
+
+// removeTusUpload deletes every trace of an upload
+func removeTusUpload(dir string, id string) {
+	os.Remove(tusDataPath(dir, id))
+	os.Remove(tusInfoPath(dir, id))
+	tusLocks.Delete(id)
+}
+
+// tusNotFound returns the error for a request for an upload that couldn't be loaded, 410 once it has expired
+func tusNotFound(err error) error {
+	if errors.Is(err, errTusExpired) {
+		return ErrGone
+	}
+	return err
+}
+
+// parseTusMetadata decodes an Upload-Metadata header of comma separated "key base64value" pairs
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// newTusID returns a random upload ID
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isTusID reports whether id has the shape of an ID returned by newTusID
+func isTusID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return len(id) == 32 && err == nil
+}
+
+// tusDataPath returns the path of the bytes received so far for an upload
+func tusDataPath(dir string, id string) string {
+	return filepath.Join(dir, id+".bin")
+}
+
+// tusInfoPath returns the path of the persisted state of an upload
+func tusInfoPath(dir string, id string) string {
+	return filepath.Join(dir, id+".json")
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// Upload describes a completed upload that is about to be committed to the files directory
+type Upload struct {
+	Name        string
+	Size        int64
+	ContentType string
+	Body        io.Reader
+}
+
+// UploadValidator inspects an upload before it is committed and rejects it by returning an error
+type UploadValidator interface {
+	ValidateUpload(upload Upload) error
+}
+
+// UploadValidatorFunc adapts an ordinary function to the UploadValidator interface
+type UploadValidatorFunc func(upload Upload) error
+
+// ValidateUpload calls f(upload)
+func (f UploadValidatorFunc) ValidateUpload(upload Upload) error {
+	return f(upload)
+}
+
+// uploadTempPrefix is the name prefix of in-flight uploads inside the files directory
+const uploadTempPrefix = ".upload-"
+
+// uploadValidators are run in order on every upload, the first error rejects it with 422
+var uploadValidators []UploadValidator
+
+// extensionValidator only accepts uploads whose file extension is one of extensions
+func extensionValidator(extensions []string) UploadValidator {
+	allowed := make(map[string]bool, len(extensions))
+	for _, extension := range extensions {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension != "" && !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		allowed[extension] = true
+	}
+
+	return UploadValidatorFunc(func(upload Upload) error {
+		if !allowed[strings.ToLower(filepath.Ext(upload.Name))] {
+			return fmt.Errorf("file extension %q is not allowed", filepath.Ext(upload.Name))
+		}
+		return nil
+	})
+}
+
+// handleFileUpload will handle POST and, with put set, PUT requests that upload a file to name inside
+// the directory. A PUT replacing an existing file is answered with 204, creating one with 201 as for POST.
+//
+// The body is written to a temporary file next to its destination and only moved into place
+// once every upload validator has accepted it, so rejected or interrupted uploads leave no trace.
+func handleFileUpload(reader *bufio.Reader, writer *bufio.Writer, head *Request, directory string, name string, query url.Values, put bool) error {
+	segments, err := splitUploadPath(name)
+	if err == nil {
+		segments, err = uploadNameSanitizer.sanitizeSegments(segments)
+	}
+	if err != nil {
+		return ErrBadRequest.WithDetail(err.Error())
+	}
+
+	filePath := filepath.Join(directory, filepath.Join(segments...))
+
+	if err := ensureParentDirs(directory, segments[:len(segments)-1], *createDirFlag); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrConflict
+		}
+		fmt.Printf("Error creating parent directories: %s\n", err.Error())
+		return ErrBadRequest
+	}
+
+	// Existing files are replaced by a PUT, or a POST when the client opts in with ?overwrite=true,
+	// and never when it sent If-None-Match: * to ask for creation only
+	createOnly := head.Header.Get("If-None-Match") == "*"
+	overwrite := !createOnly && (put || query.Get("overwrite") == "true")
+
+	info, statErr := os.Stat(filePath)
+	existed := statErr == nil
+	if err := checkWritePreconditions(head, info, existed); err != nil {
+		return err
+	}
+	if existed && !overwrite {
+		return conflictError(createOnly)
+	}
+
+	// A chunked body's length is only known once it has been received, so its quota is reserved as it
+	// is written instead of up front
+	chunked := head.Header.Get("Transfer-Encoding") != ""
+
+	var contentLength int64
+	if !chunked {
+		contentLengthHeader := head.Header.Get("Content-Length")
+
+		if contentLengthHeader == "" {
+			return ErrBadRequest
+		}
+
+		contentLength, err = strconv.ParseInt(contentLengthHeader, 10, 64)
+		if err != nil || contentLength < 0 {
+			return ErrBadRequest
+		}
+	}
+	if *maxUploadSizeFlag > 0 && contentLength > *maxUploadSizeFlag {
+		return ErrEntityTooLarge
+	}
+
+	// Uploads sent with an X-Request-ID can be followed through GET /progress/<id>, chunked ones
+	// report a total of 0 since it isn't known
+	progress := trackUpload(head.Header.Get("X-Request-ID"), contentLength, 0)
+	defer progress.finish()
+
+	size := contentLength
+	if chunked {
+		size = -1
+	}
+	_, err = storeUpload(limitUploadBody(progress.reader(reader)), filePath, size, overwrite, func() error {
+		// The file may have changed while the body was received, a conditional upload must not lose that change
+		if head.Header.Get("If-Match") == "" && head.Header.Get("If-Unmodified-Since") == "" {
+			return nil
+		}
+		info, statErr := os.Stat(filePath)
+		return checkWritePreconditions(head, info, statErr == nil)
+	})
+	if errors.Is(err, os.ErrExist) {
+		return conflictError(createOnly)
+	}
+	if err != nil {
+		return err
+	}
+
+	if put && existed {
+		newResponseHeader("204 No Content").write(writer, nil)
+		return nil
+	}
+
+	// The sanitized name may differ from the requested one, so tell the client where the file ended up
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	newResponseHeader("201 Created").
+		add("Location", "/files/"+strings.Join(escaped, "/")).
+		add("Content-Length", "0").
+		write(writer, nil)
+	return nil
+}
+
+// storeUpload streams body into a temporary file next to filePath and moves it there once it is complete
+// and passes the upload validators, returning its size. size is the declared length of body, or -1 when
+// it is only known once the body ends, in which case its quota is reserved as it is written. check runs
+// right before the file is committed. An error wrapping os.ErrExist means filePath was taken without
+// overwrite.
+func storeUpload(body io.Reader, filePath string, size int64, overwrite bool, check func() error) (int64, error) {
+	reserved := max(size, 0)
+	if !filesQuota.reserve(reserved) {
+		return 0, ErrInsufficientStorage
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			filesQuota.release(reserved)
+		}
+	}()
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), uploadTempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := file.Chmod(0644); err != nil {
+		return 0, err
+	}
+
+	if size < 0 {
+		size, err = copyAll(&quotaWriter{w: file, quota: filesQuota, reserved: &reserved}, body)
+	} else {
+		_, err = copyN(file, body, size)
+	}
+	if err != nil {
+		return 0, bodyError(err)
+	}
+
+	if err := validateUpload(file, filepath.Base(filePath), size); err != nil {
+		return 0, ErrUnprocessable.WithDetail(err.Error())
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := check(); err != nil {
+		return 0, err
+	}
+
+	if err := commitUpload(file.Name(), filePath, overwrite); err != nil {
+		return 0, fmt.Errorf("committing upload: %w", err)
+	}
+	committed = true
+	return size, nil
+}
+
+// handleFileDelete will handle DELETE requests removing the file name inside the directory, which is
+// versioned first like a file about to be overwritten
+func handleFileDelete(writer *bufio.Writer, head *Request, directory string, name string) error {
+	segments, err := splitUploadPath(name)
+	if err != nil {
+		return ErrBadRequest.WithDetail(err.Error())
+	}
+	// Previous versions and unfinished uploads are hidden from listings, and can't be deleted by name either
+	for _, segment := range segments {
+		if segment == tusDirName || isInternalFile(segment) {
+			return ErrNotFound
+		}
+	}
+	if err := ensureParentDirs(directory, segments[:len(segments)-1], false); err != nil {
+		return ErrNotFound
+	}
+
+	filePath := filepath.Join(directory, filepath.Join(segments...))
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrConflict.WithDetail("directories can't be deleted")
+	}
+	if err := checkWritePreconditions(head, info, true); err != nil {
+		return err
+	}
+
+	if err := versionFile(filePath, versionsFlag.Get()); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(filePath); err == nil {
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		filesQuota.release(info.Size())
+	}
+
+	newResponseHeader("204 No Content").write(writer, nil)
+	return nil
+}
+
+// splitUploadPath splits the slash separated upload name into segments, rejecting any segment
+// that could escape the directory or is otherwise unusable as a file name
+func splitUploadPath(name string) ([]string, error) {
+	segments := strings.Split(name, "/")
+	for _, segment := range segments {
+		switch {
+		case segment == "", segment == ".", segment == "..":
+			return nil, fmt.Errorf("invalid path segment %q", segment)
+		case strings.ContainsAny(segment, "\\\x00"):
+			return nil, fmt.Errorf("invalid character in path segment %q", segment)
+		}
+	}
+	return segments, nil
+}
+
+// ensureParentDirs makes sure every directory in segments exists below root, creating the
+// missing ones when create is set. Existing segments must be real directories and not symlinks,
+// so the resulting path can never leave root.
+func ensureParentDirs(root string, segments []string, create bool) error {
+	dir := root
+	for _, segment := range segments {
+		dir = filepath.Join(dir, segment)
+
+		info, err := os.Lstat(dir)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && create:
+			if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+				return err
+			}
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// validateUpload runs every registered upload validator against the uploaded file
+func validateUpload(file *os.File, name string, size int64) error {
+	if len(uploadValidators) == 0 {
+		return nil
+	}
+
+	sniff := make([]byte, 512)
+	n, err := file.ReadAt(sniff, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	contentType := http.DetectContentType(sniff[:n])
+
+	for _, validator := range uploadValidators {
+		upload := Upload{
+			Name:        name,
+			Size:        size,
+			ContentType: contentType,
+			Body:        io.NewSectionReader(file, 0, size),
+		}
+		if err := validator.ValidateUpload(upload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commitUpload moves the temporary upload at tmpPath to filePath, versioning the previous content when overwriting
+func commitUpload(tmpPath string, filePath string, overwrite bool) error {
+	if !overwrite {
+		// Link fails if filePath was created while the upload was in flight, unlike Rename
+		if err := os.Link(tmpPath, filePath); err != nil {
+			return err
+		}
+		return os.Remove(tmpPath)
+	}
+
+	if err := versionFile(filePath, versionsFlag.Get()); err != nil {
+		return err
+	}
+
+	// Unless it was just versioned the previous content is gone once the upload replaces it
+	var replaced int64
+	if info, err := os.Stat(filePath); err == nil {
+		replaced = info.Size()
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	filesQuota.release(replaced)
+
+	return nil
+}
+
+// bodyError classifies a failure to receive a request body: a timeout or a full disk keep their own
+// status, anything else means the client sent less than it announced
+func bodyError(err error) error {
+	if errorStatus(err) == ErrInternal {
+		return ErrBadRequest
+	}
+	return err
+}
+
+// checkWritePreconditions evaluates the conditional fields of an upload or delete against the file it
+// replaces, info is only used when the file exists
+func checkWritePreconditions(head *Request, info os.FileInfo, exists bool) error {
+	if !exists {
+		_, err := checkPreconditions(head, "", time.Time{}, false)
+		return err
+	}
+	_, err := checkPreconditions(head, fileETag(info), info.ModTime(), true)
+	return err
+}
+
+// conflictError returns the error for an upload that would replace an existing file without permission
+func conflictError(createOnly bool) error {
+	if createOnly {
+		return ErrPreconditionFailed
+	}
+	return ErrConflict
+}
+
+// limitUploadBody bounds body to --max-upload-size, when set
+func limitUploadBody(body io.Reader) io.Reader {
+	if *maxUploadSizeFlag <= 0 {
+		return body
+	}
+	return httpserver.LimitBody(body, *maxUploadSizeFlag)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// versionFile moves the current content of path aside as path.~N~ before it is overwritten or deleted,
+// keeping at most retention previous versions. A retention of 0 disables versioning entirely.
+func versionFile(path string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	versions, err := listVersions(path)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1] + 1
+	}
+
+	if err := os.Rename(path, versionPath(path, next)); err != nil {
+		return err
+	}
+	versions = append(versions, next)
+
+	// Prune the oldest versions beyond the retention count
+	for len(versions) > retention {
+		if err := removeVersion(versionPath(path, versions[0])); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+
+	return nil
+}
+
+// removeVersion deletes a pruned version and returns its space to the files quota
+func removeVersion(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	filesQuota.release(info.Size())
+
+	return nil
+}
+
+// listVersions returns the version numbers that exist for path in ascending order
+func listVersions(path string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(path)
+
+	var versions []int
+	for _, entry := range entries {
+		name, n, ok := parseVersionName(entry.Name())
+		if ok && name == base {
+			versions = append(versions, n)
+		}
+	}
+	slices.Sort(versions)
+
+	return versions, nil
+}
+
+// versionPath returns the path under which version n of path is stored
+func versionPath(path string, n int) string {
+	return fmt.Sprintf("%s.~%d~", path, n)
+}
+
+// parseVersionName splits a version file name like "report.txt.~3~" into "report.txt" and 3
+func parseVersionName(name string) (string, int, bool) {
+	if !strings.HasSuffix(name, "~") {
+		return "", 0, false
+	}
+
+	i := strings.LastIndex(name, ".~")
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	n, err := strconv.Atoi(name[i+2 : len(name)-1])
+	if err != nil || n <= 0 {
+		return "", 0, false
+	}
+
+	return name[:i], n, true
+}
+
+// isVersionName reports whether name is a stored previous version of another file
+func isVersionName(name string) bool {
+	_, _, ok := parseVersionName(name)
+	return ok
+}
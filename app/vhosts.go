@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpserver"
+)
+
+// virtualHostsFile is the --config file describing the virtual hosts, like
+//
+//	{"hosts": [{"names": ["site.localhost", "*.site.localhost"], "routes": [
+//		{"prefix": "/", "static": "./site", "listing": true},
+//		{"prefix": "/echo", "echo": true},
+//		{"prefix": "/api", "proxy": "http://localhost:9000"}
+//	]}]}
+type virtualHostsFile struct {
+	Hosts []virtualHostConfig `json:"hosts"`
+}
+
+// virtualHostConfig is a virtual host, a name starting with *. matches every subdomain of the rest
+type virtualHostConfig struct {
+	Names  []string      `json:"names"`
+	Routes []routeConfig `json:"routes"`
+}
+
+// routeConfig maps the requests under a path prefix to exactly one of a static directory, relative to
+// the config file, an echo of the path segment after the prefix, or an upstream to proxy to
+type routeConfig struct {
+	Prefix  string `json:"prefix"`
+	Static  string `json:"static"`
+	Listing bool   `json:"listing"`
+	Echo    bool   `json:"echo"`
+	Proxy   string `json:"proxy"`
+}
+
+// virtualHosts maps host names to the routes of their virtual host
+type virtualHosts struct {
+	exact    map[string]*Router
+	wildcard map[string]*Router
+}
+
+// currentVirtualHosts are the virtual hosts last loaded from --config, nil without one. Requests look
+// them up once, so a reload never changes the routes of a request in flight.
+var currentVirtualHosts atomic.Pointer[virtualHosts]
+
+// loadVirtualHosts reads and checks the virtual hosts of the config file at path, building their routes
+func loadVirtualHosts(path string) (*virtualHosts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var file virtualHostsFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	hosts := &virtualHosts{exact: map[string]*Router{}, wildcard: map[string]*Router{}}
+	for i, host := range file.Hosts {
+		if len(host.Names) == 0 {
+			return nil, fmt.Errorf("host %d has no names", i+1)
+		}
+		router, err := newVirtualHostRoutes(host.Routes, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host.Names[0], err)
+		}
+
+		for _, name := range host.Names {
+			name = strings.ToLower(strings.TrimSpace(name))
+			names := hosts.exact
+			if suffix, ok := strings.CutPrefix(name, "*."); ok {
+				name, names = suffix, hosts.wildcard
+			}
+			if name == "" || strings.ContainsAny(name, "*/:") {
+				return nil, fmt.Errorf("%q is not a host name like site.localhost or *.site.localhost", name)
+			}
+			if names[name] != nil {
+				return nil, fmt.Errorf("host name %q is listed twice", name)
+			}
+			names[name] = router
+		}
+	}
+	return hosts, nil
+}
+
+// newVirtualHostRoutes returns a router with the routes of a virtual host, relative static directories
+// are taken from dir. Longer prefixes are registered first so they win over the prefixes they extend.
+func newVirtualHostRoutes(configs []routeConfig, dir string) (*Router, error) {
+	configs = append([]routeConfig(nil), configs...)
+	for i := range configs {
+		configs[i].Prefix = strings.Trim(configs[i].Prefix, "/")
+	}
+	sort.SliceStable(configs, func(i, j int) bool {
+		return len(splitPrefix(configs[i].Prefix)) > len(splitPrefix(configs[j].Prefix))
+	})
+
+	router := httpserver.NewRouter()
+	router.Use(recoverPanics)
+	if *logRequestsFlag {
+		router.Use(logRequests)
+	}
+
+	for _, config := range configs {
+		pattern := "/" + config.Prefix + "/{path...}"
+		targets := 0
+		for _, set := range []bool{config.Static != "", config.Echo, config.Proxy != ""} {
+			if set {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return nil, fmt.Errorf("route /%s must have exactly one of static, echo, or proxy", config.Prefix)
+		}
+
+		switch {
+		case config.Static != "":
+			root := config.Static
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(dir, root)
+			}
+			if info, err := os.Stat(root); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("static directory %s of route /%s doesn't exist", root, config.Prefix)
+			}
+			listing := config.Listing
+			router.Handle("GET", pattern, withStaticAuth(func(w *Response, r *Request) error {
+				return serveStaticDirectory(w, r, root, "/"+r.Param("path"), listing)
+			}))
+		case config.Echo:
+			router.Handle("GET", "/"+config.Prefix+"/{msg}", withInternalRedirect(httpserver.WithContentEncoding(handleEchoRequest)))
+		default:
+			proxy, err := newProxyRoute(config.Prefix, config.Proxy)
+			if err != nil {
+				return nil, err
+			}
+			router.Handle("*", pattern, proxy.handle)
+		}
+	}
+	return router, nil
+}
+
+// splitPrefix splits a prefix without its surrounding slashes into its segments, none for the root
+func splitPrefix(prefix string) []string {
+	if prefix == "" {
+		return nil
+	}
+	return strings.Split(prefix, "/")
+}
+
+// virtualHostRoutes returns the routes of the virtual host serving requests for host, a Host header
+// value, or nil when no virtual host has its name and the routes of the flags serve it
+func virtualHostRoutes(host string) *Router {
+	hosts := currentVirtualHosts.Load()
+	if hosts == nil {
+		return nil
+	}
+
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if router := hosts.exact[host]; router != nil {
+		return router
+	}
+	// The most specific wildcard wins, *.a.site.localhost over *.site.localhost
+	for suffix := host; ; {
+		_, parent, ok := strings.Cut(suffix, ".")
+		if !ok {
+			return nil
+		}
+		if router := hosts.wildcard[parent]; router != nil {
+			return router
+		}
+		suffix = parent
+	}
+}
+
+// handleReloadSignals will reload the virtual hosts of the config file at path every time the process
+// receives SIGHUP. A file that fails to load is logged and the virtual hosts already loaded are kept.
+func handleReloadSignals(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		hosts, err := loadVirtualHosts(path)
+		if err != nil {
+			fmt.Printf("Failed to reload %s, keeping the previous configuration: %s\n", path, err.Error())
+			continue
+		}
+		currentVirtualHosts.Store(hosts)
+		fmt.Printf("Reloaded %d virtual host names from %s\n", hosts.names(), path)
+	}
+}
+
+// names returns how many host names the virtual hosts answer for
+func (h *virtualHosts) names() int {
+	return len(h.exact) + len(h.wildcard)
+}
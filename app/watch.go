@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/fswatch"
+)
+
+// invalidators are called with the path of every file or directory the watcher saw change
+var invalidators []func(path string)
+
+// infoCache holds stat results of served files, it is nil unless --watch keeps it fresh
+var infoCache *fileInfoCache
+
+// listingCache holds rendered directory listings, it is nil unless --watch keeps it fresh
+var listingCache *bodyCache
+
+// watchedRoot is the cleaned directory the watcher keeps the caches fresh for
+var watchedRoot string
+
+// startWatcher enables the file info and listing caches and invalidates them whenever
+// something under root changes, including changes made outside of the server
+func startWatcher(root string) error {
+	infoCache = &fileInfoCache{entries: map[string]fs.FileInfo{}}
+	listingCache = &bodyCache{entries: map[string][]byte{}}
+	watchedRoot = filepath.Clean(root)
+
+	invalidators = append(invalidators, infoCache.invalidate, func(string) { listingCache.clear() })
+
+	return fswatch.Watch(watchedRoot, notifyChange)
+}
+
+// notifyChange runs every invalidator for path
+func notifyChange(path string) {
+	for _, invalidate := range invalidators {
+		invalidate(path)
+	}
+}
+
+// statFile returns the file info of path, from the cache when the watcher is running. Only paths below
+// the watched root are cached, nothing would tell the cache when files elsewhere change.
+func statFile(path string) (fs.FileInfo, error) {
+	path = filepath.Clean(path)
+	watched := isWatchedPath(path)
+
+	if watched {
+		if info, ok := infoCache.get(path); ok {
+			return info, nil
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if watched {
+		infoCache.put(path, info)
+	}
+	return info, nil
+}
+
+// isWatchedPath reports whether the cleaned path is the watched root or below it
+func isWatchedPath(path string) bool {
+	if watchedRoot == "" {
+		return false
+	}
+	return path == watchedRoot || strings.HasPrefix(path, strings.TrimSuffix(watchedRoot, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// fileInfoCache maps cleaned paths to their last known file info
+type fileInfoCache struct {
+	mu      sync.RWMutex
+	entries map[string]fs.FileInfo
+}
+
+// get returns the cached info for path, a nil cache never has any
+func (c *fileInfoCache) get(path string) (fs.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	info, ok := c.entries[path]
+	return info, ok
+}
+
+// put caches the info for path, it is a no-op on a nil cache
+func (c *fileInfoCache) put(path string, info fs.FileInfo) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[path] = info
+}
+
+// invalidate forgets path, its parent directory, and everything below it in case it was a directory
+func (c *fileInfoCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, path)
+	delete(c.entries, filepath.Dir(path))
+
+	prefix := path + string(filepath.Separator)
+	for cached := range c.entries {
+		if strings.HasPrefix(cached, prefix) {
+			delete(c.entries, cached)
+		}
+	}
+}
+
+// bodyCache maps keys to rendered response bodies
+type bodyCache struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+// get returns the cached body for key, a nil cache never has any
+func (c *bodyCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	body, ok := c.entries[key]
+	return body, ok
+}
+
+// put caches the body for key, it is a no-op on a nil cache
+func (c *bodyCache) put(key string, body []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = body
+}
+
+// clear forgets every cached body
+func (c *bodyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/websocket"
+)
+
+// maxWebSocketMessage bounds the size of a message a WebSocket client may send
+const maxWebSocketMessage = 1 << 20
+
+// hasToken reports whether one of the comma separated values lists token, ignoring case
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, option := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptWebSocket checks the opening handshake of RFC 6455 section 4.2.1 and answers it with 101
+// Switching Protocols, returning the connection taken over for WebSocket frames
+func acceptWebSocket(w *Response, r *Request) (*websocket.Conn, error) {
+	if r.Proto != "HTTP/1.1" || !hasToken(r.Header.Values("Upgrade"), "websocket") || !hasToken(r.Header.Values("Connection"), "upgrade") {
+		return nil, ErrUpgradeRequired.WithHeader("Upgrade", "websocket").WithHeader("Connection", "Upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrUpgradeRequired.WithHeader("Sec-WebSocket-Version", "13")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !websocket.ValidKey(key) {
+		return nil, ErrBadRequest.WithDetail("missing or malformed Sec-WebSocket-Key")
+	}
+
+	newResponseHeader("101 Switching Protocols").
+		add("Upgrade", "websocket").
+		add("Connection", "Upgrade").
+		add("Sec-WebSocket-Accept", websocket.AcceptKey(key)).
+		write(w.Raw(), nil)
+	reader, writer := r.Hijack()
+	return websocket.NewConn(reader, writer, maxWebSocketMessage), nil
+}
+
+// handleWebSocketRequest will upgrade requests for ws to a WebSocket and echo every message back to the
+// client until it closes the connection or stays silent for --idle-timeout
+func handleWebSocketRequest(w *Response, r *Request) error {
+	ws, err := acceptWebSocket(w, r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if *idleTimeoutFlag > 0 {
+			r.Conn.SetReadDeadline(time.Now().Add(*idleTimeoutFlag))
+		}
+		op, message, err := ws.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		switch {
+		case errors.As(err, &closeErr), errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			ws.Close(websocket.CloseGoingAway, "idle timeout")
+			return nil
+		case err != nil:
+			fmt.Printf("WebSocket connection from %s failed: %s\n", r.RemoteAddr, err.Error())
+			return nil
+		}
+
+		if err := ws.WriteMessage(op, message); err != nil {
+			return nil
+		}
+	}
+}
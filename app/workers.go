@@ -0,0 +1,25 @@
+package main
+
+import "net"
+
+// startWorkers starts n long-lived goroutines that serve the connections sent on the returned channel,
+// which blocks the sender once every worker is busy and n more connections are queued. The workers exit
+// when the channel is closed.
+func startWorkers(n int) chan<- net.Conn {
+	queue := make(chan net.Conn, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for conn := range queue {
+				serveConnection(conn)
+			}
+		}()
+	}
+	return queue
+}
+
+// serveConnection handles an accepted public connection and releases its place in the connection counters
+func serveConnection(conn net.Conn) {
+	defer connections.Done()
+	defer activeConnections.Add(-1)
+	handleConnection(conn)
+}
@@ -0,0 +1,3 @@
+// Package fswatch reports changes to the files and directories below a root directory,
+// using inotify on Linux and periodic rescans everywhere else.
+package fswatch
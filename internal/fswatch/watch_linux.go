@@ -0,0 +1,112 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyWatcher watches a directory tree with one inotify watch per directory
+type inotifyWatcher struct {
+	fd   int
+	root string
+	dirs map[int32]string
+}
+
+// Watch calls notify with the path of every change under root until the process exits
+func Watch(root string, notify func(path string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	w := &inotifyWatcher{fd: fd, root: root, dirs: map[int32]string{}}
+	if err := w.addTree(root); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go w.run(notify)
+	return nil
+}
+
+// addTree adds a watch for root and every directory below it
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// run reads events until the inotify descriptor fails
+func (w *inotifyWatcher) run(notify func(path string)) {
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := syscall.Read(w.fd, buffer)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Error reading inotify events: %s\n", err.Error())
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buffer[offset:]))
+			mask := binary.NativeEndian.Uint32(buffer[offset+4:])
+			length := int(binary.NativeEndian.Uint32(buffer[offset+12:]))
+
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buffer[nameStart:nameStart+length]), "\x00")
+			offset = nameStart + length
+
+			// When the queue overflows, events were lost, including those of directories created since
+			// that still need a watch. Re-walking the tree adds them, and the root stands for every change.
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				fmt.Printf("Inotify queue overflowed, rewatching %s\n", w.root)
+				if err := w.addTree(w.root); err != nil {
+					fmt.Printf("Error watching %s: %s\n", w.root, err.Error())
+				}
+				notify(w.root)
+				continue
+			}
+
+			dir, ok := w.dirs[wd]
+			if !ok {
+				continue
+			}
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+
+			// New directories need their own watch, and may already contain files by the time it is added
+			if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := w.addTree(path); err != nil {
+					fmt.Printf("Error watching %s: %s\n", path, err.Error())
+				}
+			}
+
+			notify(path)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !linux
+
+package fswatch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// pollInterval is how often the directory tree is rescanned on platforms without inotify
+const pollInterval = time.Second
+
+// fileState is the part of a file's info that is compared between scans
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watch calls notify with the path of every change under root until the process exits
+func Watch(root string, notify func(path string)) error {
+	previous, err := scanTree(root)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(pollInterval) {
+			current, err := scanTree(root)
+			if err != nil {
+				continue
+			}
+
+			for path, state := range current {
+				if old, ok := previous[path]; !ok || old != state {
+					notify(path)
+				}
+			}
+			for path := range previous {
+				if _, ok := current[path]; !ok {
+					notify(path)
+				}
+			}
+
+			previous = current
+		}
+	}()
+
+	return nil
+}
+
+// scanTree records the state of every file and directory under root
+func scanTree(root string) (map[string]fileState, error) {
+	states := map[string]fileState{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		states[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+
+	return states, err
+}
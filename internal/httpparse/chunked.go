@@ -0,0 +1,144 @@
+package httpparse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxChunkLineBytes bounds a chunk size line, extensions included
+const maxChunkLineBytes = 4096
+
+// ChunkedReader decodes a body sent with the chunked transfer coding
+type ChunkedReader struct {
+	r       *bufio.Reader
+	limits  Limits
+	left    int64
+	started bool
+	done    bool
+	err     error
+	trailer Header
+}
+
+// NewChunkedReader returns a reader of the decoded body, trailer fields are bounded by limits
+func NewChunkedReader(r *bufio.Reader, limits Limits) *ChunkedReader {
+	return &ChunkedReader{r: r, limits: limits}
+}
+
+// Read implements io.Reader
+func (c *ChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.left == 0 {
+		if c.started {
+			if err := c.readCRLF(); err != nil {
+				return 0, c.fail(err)
+			}
+		}
+		c.started = true
+
+		size, err := c.readSize()
+		if err != nil {
+			return 0, c.fail(err)
+		}
+		if size == 0 {
+			trailer, err := ReadHeader(c.r, c.limits)
+			if err != nil {
+				return 0, c.fail(err)
+			}
+			c.trailer, c.done = trailer, true
+			return 0, io.EOF
+		}
+		c.left = size
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, c.fail(err)
+	}
+	return n, nil
+}
+
+// Trailer returns the trailer fields, available once Read has returned io.EOF
+func (c *ChunkedReader) Trailer() Header {
+	return c.trailer
+}
+
+// fail makes err sticky, so reads after a broken chunk keep failing
+func (c *ChunkedReader) fail(err error) error {
+	c.err = err
+	return err
+}
+
+// readSize reads a chunk size line, ignoring chunk extensions
+func (c *ChunkedReader) readSize() (int64, error) {
+	line, err := newReader(c.r, Limits{MaxHeaderBytes: maxChunkLineBytes}).readLine()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err == ErrHeaderTooLarge {
+		return 0, ErrMalformedChunk
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	digits, _, _ := strings.Cut(line, ";")
+	digits = strings.TrimRight(digits, " \t")
+	if digits == "" || len(digits) > 15 {
+		return 0, ErrMalformedChunk
+	}
+
+	var size int64
+	for i := 0; i < len(digits); i++ {
+		d := unhex(digits[i])
+		if d < 0 {
+			return 0, ErrMalformedChunk
+		}
+		size = size<<4 | int64(d)
+	}
+	return size, nil
+}
+
+// readCRLF consumes the line ending that follows chunk data
+func (c *ChunkedReader) readCRLF() error {
+	b, err := c.r.ReadByte()
+	if err == nil && b == '\r' {
+		b, err = c.r.ReadByte()
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if b != '\n' {
+		return ErrMalformedChunk
+	}
+	return nil
+}
+
+// unhex returns the value of a hexadecimal digit, or -1 for any other byte
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
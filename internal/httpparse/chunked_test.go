@@ -0,0 +1,96 @@
+package httpparse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		body    string
+		trailer Header
+		err     error
+	}{
+		{name: "single chunk", input: "5\r\nhello\r\n0\r\n\r\n", body: "hello", trailer: Header{}},
+		{name: "several chunks", input: "5\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\n\r\n", body: "hello world", trailer: Header{}},
+		{name: "empty body", input: "0\r\n\r\n", body: "", trailer: Header{}},
+		{name: "hex sizes", input: "A\r\n0123456789\r\n10\r\n0123456789abcdef\r\n0\r\n\r\n", body: "0123456789" + "0123456789abcdef", trailer: Header{}},
+		{name: "leading zeros", input: "0005\r\nhello\r\n000\r\n\r\n", body: "hello", trailer: Header{}},
+		{name: "chunk extensions", input: "5;name=value\r\nhello\r\n0;last\r\n\r\n", body: "hello", trailer: Header{}},
+		{name: "bare LF", input: "5\nhello\n0\n\n", body: "hello", trailer: Header{}},
+		{
+			name:    "trailer",
+			input:   "5\r\nhello\r\n0\r\nDigest: sha-256=abc\r\nx-checksum: 1\r\n\r\n",
+			body:    "hello",
+			trailer: Header{"Digest": {"sha-256=abc"}, "X-Checksum": {"1"}},
+		},
+		{name: "missing size", input: "\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+		{name: "invalid size", input: "5x\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+		{name: "negative size", input: "-5\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+		{name: "size overflow", input: "ffffffffffffffff\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+		{name: "data longer than size", input: "3\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+		{name: "truncated data", input: "5\r\nhel", err: io.ErrUnexpectedEOF},
+		{name: "truncated after data", input: "5\r\nhello", err: io.ErrUnexpectedEOF},
+		{name: "missing last chunk", input: "5\r\nhello\r\n", err: io.ErrUnexpectedEOF},
+		{name: "missing trailer end", input: "5\r\nhello\r\n0\r\n", err: io.ErrUnexpectedEOF},
+		{name: "malformed trailer", input: "0\r\nnot a field\r\n\r\n", err: ErrMalformedHeader},
+		{name: "size line too long", input: "5;" + strings.Repeat("x", maxChunkLineBytes) + "\r\nhello\r\n0\r\n\r\n", err: ErrMalformedChunk},
+	}
+
+	for _, test := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(test.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			chunked := NewChunkedReader(bufio.NewReader(r), Limits{})
+			body, err := io.ReadAll(chunked)
+
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s (one byte %v): error %v, want %v", test.name, oneByte, err, test.err)
+				continue
+			}
+			if test.err != nil {
+				continue
+			}
+			if string(body) != test.body || !reflect.DeepEqual(chunked.Trailer(), test.trailer) {
+				t.Errorf("%s (one byte %v): got %q %v, want %q %v", test.name, oneByte, body, chunked.Trailer(), test.body, test.trailer)
+			}
+		}
+	}
+}
+
+func TestChunkedReaderStopsAtEnd(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("3\r\nabc\r\n0\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	if _, err := io.ReadAll(NewChunkedReader(r, Limits{})); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("rest = %q, the next request should be left unread", rest)
+	}
+}
+
+func TestChunkedReaderErrorIsSticky(t *testing.T) {
+	chunked := NewChunkedReader(bufio.NewReader(strings.NewReader("zz\r\n")), Limits{})
+	for i := 0; i < 2; i++ {
+		if _, err := chunked.Read(make([]byte, 8)); !errors.Is(err, ErrMalformedChunk) {
+			t.Errorf("read %d: error %v, want %v", i, err, ErrMalformedChunk)
+		}
+	}
+}
+
+func TestChunkedReaderTrailerLimits(t *testing.T) {
+	input := "0\r\nA: 1\r\nB: 2\r\n\r\n"
+	_, err := io.ReadAll(NewChunkedReader(bufio.NewReader(strings.NewReader(input)), Limits{MaxHeaderFields: 1}))
+	if !errors.Is(err, ErrTooManyFields) {
+		t.Errorf("error %v, want %v", err, ErrTooManyFields)
+	}
+}
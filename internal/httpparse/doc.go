@@ -0,0 +1,29 @@
+// Package httpparse parses the HTTP/1.1 wire format: request and status lines, header fields, chunked
+// transfer coding, and multipart bodies. It depends only on the standard library's I/O
+// primitives, so it can be shared by the server and any future client or proxy.
+package httpparse
+
+import "errors"
+
+var (
+	// ErrEmptyRequest is returned when the input ends before a request line was read
+	ErrEmptyRequest = errors.New("httpparse: empty request")
+	// ErrMalformedRequestLine is returned for a request line that isn't method, target, and protocol
+	ErrMalformedRequestLine = errors.New("httpparse: malformed request line")
+	// ErrMalformedStatusLine is returned for a status line that isn't protocol, status code, and reason
+	ErrMalformedStatusLine = errors.New("httpparse: malformed status line")
+	// ErrHeadNotTerminated is returned, along with everything read so far, when the input ends right after
+	// a complete line instead of the empty line that ends a head. A client that shut down its sending side
+	// after the last field does this, callers may choose to go on with what was read.
+	ErrHeadNotTerminated = errors.New("httpparse: input ended before the end of the head")
+	// ErrMalformedHeader is returned for a header field line that isn't a name, a colon, and a value
+	ErrMalformedHeader = errors.New("httpparse: malformed header field")
+	// ErrHeaderTooLarge is returned once a request head exceeds Limits.MaxHeaderBytes
+	ErrHeaderTooLarge = errors.New("httpparse: header too large")
+	// ErrTooManyFields is returned once a request head exceeds Limits.MaxHeaderFields
+	ErrTooManyFields = errors.New("httpparse: too many header fields")
+	// ErrMalformedChunk is returned for a chunked body that doesn't follow the chunked coding
+	ErrMalformedChunk = errors.New("httpparse: malformed chunked encoding")
+	// ErrMalformedMultipart is returned for a multipart body that doesn't follow its boundaries
+	ErrMalformedMultipart = errors.New("httpparse: malformed multipart body")
+)
@@ -0,0 +1,155 @@
+package httpparse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// Header holds header field values by canonical field name, in the order they were received
+type Header map[string][]string
+
+// Get returns the first value of the named field, or an empty string if it is not present
+func (h Header) Get(name string) string {
+	if values := h[textproto.CanonicalMIMEHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of the named field
+func (h Header) Values(name string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// Add appends value to the named field
+func (h Header) Add(name string, value string) {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	h[key] = append(h[key], value)
+}
+
+// Limits bound how much of the input a head may use. A zero field is unlimited.
+type Limits struct {
+	// MaxHeaderBytes bounds the request line and header fields together, line endings included
+	MaxHeaderBytes int
+	// MaxHeaderFields bounds the number of header fields
+	MaxHeaderFields int
+}
+
+// reader reads lines from a bufio.Reader while keeping count of the bytes and fields allowed
+type reader struct {
+	r      *bufio.Reader
+	bytes  int
+	fields int
+}
+
+// newReader returns a line reader enforcing limits
+func newReader(r *bufio.Reader, limits Limits) *reader {
+	lr := &reader{r: r, bytes: limits.MaxHeaderBytes, fields: limits.MaxHeaderFields}
+	if lr.bytes <= 0 {
+		lr.bytes = -1
+	}
+	if lr.fields <= 0 {
+		lr.fields = -1
+	}
+	return lr
+}
+
+// readLine reads one line without its CRLF or bare LF ending, never buffering more than the byte budget
+func (lr *reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		if lr.bytes >= 0 {
+			if len(chunk) > lr.bytes {
+				return "", ErrHeaderTooLarge
+			}
+			lr.bytes -= len(chunk)
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+// readFields reads header fields up to and including the empty line that ends them
+func (lr *reader) readFields() (Header, error) {
+	header := Header{}
+	for {
+		line, err := lr.readLine()
+		if err == io.EOF {
+			return header, ErrHeadNotTerminated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return header, nil
+		}
+
+		if lr.fields == 0 {
+			return nil, ErrTooManyFields
+		}
+		if lr.fields > 0 {
+			lr.fields--
+		}
+
+		name, value, err := parseField(line)
+		if err != nil {
+			return nil, err
+		}
+		header.Add(name, value)
+	}
+}
+
+// parseField splits a header field line into its name and value. Obsolete line folding and whitespace
+// between the name and the colon are rejected, as RFC 9112 requires of servers.
+func parseField(line string) (string, string, error) {
+	name, value, ok := strings.Cut(line, ":")
+	if !ok || !isToken(name) {
+		return "", "", ErrMalformedHeader
+	}
+	return name, strings.Trim(value, " \t"), nil
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ReadHeader reads header fields up to the empty line that ends them, as found in trailers and
+// multipart part headers
+func ReadHeader(r *bufio.Reader, limits Limits) (Header, error) {
+	header, err := newReader(r, limits).readFields()
+	if err == ErrHeadNotTerminated {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return header, err
+}
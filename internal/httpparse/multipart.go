@@ -0,0 +1,148 @@
+package httpparse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// MultipartReader iterates over the parts of a multipart body
+type MultipartReader struct {
+	r       *bufio.Reader
+	limits  Limits
+	dash    string // "--" + boundary
+	delim   []byte // CRLF + dash, which ends the data of every part
+	current *Part
+	started bool
+	done    bool
+}
+
+// Part is one part of a multipart body, reading it yields its content
+type Part struct {
+	Header Header
+	mr     *MultipartReader
+	eof    bool
+}
+
+// NewMultipartReader reads the parts of r delimited by boundary, part headers are bounded by limits
+func NewMultipartReader(r io.Reader, boundary string, limits Limits) *MultipartReader {
+	dash := "--" + boundary
+	return &MultipartReader{
+		r:      bufio.NewReaderSize(r, max(4096, 2*len(dash)+4)),
+		limits: limits,
+		dash:   dash,
+		delim:  []byte("\r\n" + dash),
+	}
+}
+
+// NextPart skips the rest of the current part and returns the next one, or io.EOF after the last
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		mr.current = nil
+	}
+
+	var rest string
+	if !mr.started {
+		// The preamble before the first boundary carries no content
+		mr.started = true
+		for {
+			line, err := newReader(mr.r, Limits{}).readLine()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			if after, ok := strings.CutPrefix(line, mr.dash); ok {
+				rest = after
+				break
+			}
+		}
+	} else {
+		// The current part's data stops right before the delimiter
+		if _, err := mr.r.Discard(len(mr.delim)); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		// The final delimiter may be the last bytes of the body, without a line ending
+		if peek, _ := mr.r.Peek(2); string(peek) == "--" {
+			mr.done = true
+			return nil, io.EOF
+		}
+		line, err := newReader(mr.r, Limits{MaxHeaderBytes: maxChunkLineBytes}).readLine()
+		if err == io.EOF || err == ErrHeaderTooLarge {
+			err = ErrMalformedMultipart
+		}
+		if err != nil {
+			return nil, err
+		}
+		rest = line
+	}
+
+	if strings.HasPrefix(rest, "--") {
+		mr.done = true
+		return nil, io.EOF
+	}
+	if strings.Trim(rest, " \t") != "" {
+		return nil, ErrMalformedMultipart
+	}
+
+	header, err := ReadHeader(mr.r, mr.limits)
+	if err != nil {
+		return nil, err
+	}
+	mr.current = &Part{Header: header, mr: mr}
+	return mr.current, nil
+}
+
+// Read implements io.Reader, returning io.EOF at the boundary ending the part
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	r, delim := p.mr.r, p.mr.delim
+
+	if r.Buffered() < len(delim) {
+		if _, err := r.Peek(len(delim)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	buf, _ := r.Peek(r.Buffered())
+
+	if i := bytes.Index(buf, delim); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		n := copy(b, buf[:i])
+		r.Discard(n)
+		return n, nil
+	}
+
+	// The tail of the buffer might be the start of the delimiter, keep it until more data arrives
+	n := copy(b, buf[:len(buf)-len(delim)+1])
+	r.Discard(n)
+	return n, nil
+}
+
+// FormName returns the name parameter of the part's Content-Disposition
+func (p *Part) FormName() string {
+	_, params, _ := ParseParams(p.Header.Get("Content-Disposition"))
+	return params["name"]
+}
+
+// FileName returns the filename parameter of the part's Content-Disposition
+func (p *Part) FileName() string {
+	_, params, _ := ParseParams(p.Header.Get("Content-Disposition"))
+	return params["filename"]
+}
@@ -0,0 +1,166 @@
+package httpparse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// part is what a test expects of one part
+type part struct {
+	name, filename, contentType, body string
+}
+
+func TestMultipartReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		parts []part
+		err   error
+	}{
+		{
+			name: "form fields and a file",
+			input: "--xyz\r\n" +
+				"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+				"hello\r\n" +
+				"--xyz\r\n" +
+				"Content-Disposition: form-data; name=\"file\"; filename=\"a b.txt\"\r\n" +
+				"Content-Type: text/plain\r\n\r\n" +
+				"line one\r\nline two\r\n" +
+				"--xyz--\r\n",
+			parts: []part{
+				{name: "title", body: "hello"},
+				{name: "file", filename: "a b.txt", contentType: "text/plain", body: "line one\r\nline two"},
+			},
+		},
+		{
+			name:  "preamble and epilogue",
+			input: "this is a preamble\r\n--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyz--\r\nepilogue",
+			parts: []part{{name: "a", body: "1"}},
+		},
+		{
+			name:  "transport padding after boundaries",
+			input: "--xyz  \r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyz \t\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\n2\r\n--xyz--",
+			parts: []part{{name: "a", body: "1"}, {name: "b", body: "2"}},
+		},
+		{
+			name:  "final boundary without a line ending",
+			input: "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyz--",
+			parts: []part{{name: "a", body: "1"}},
+		},
+		{
+			name:  "empty part body",
+			input: "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n\r\n--xyz--\r\n",
+			parts: []part{{name: "a", body: ""}},
+		},
+		{
+			name:  "content resembling the boundary",
+			input: "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n--xy\r\n-xyz\r\n--xy z\r\n--xyz--\r\n",
+			parts: []part{{name: "a", body: "--xy\r\n-xyz\r\n--xy z"}},
+		},
+		{
+			name:  "no parts",
+			input: "--xyz--\r\n",
+		},
+		{
+			name:  "missing final boundary",
+			input: "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1234",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "no boundary at all",
+			input: "just some text\r\n",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "garbage after boundary",
+			input: "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyzgarbage\r\n\r\n2\r\n--xyz--",
+			parts: []part{{name: "a", body: "1"}},
+			err:   ErrMalformedMultipart,
+		},
+		{
+			name:  "malformed part header",
+			input: "--xyz\r\nnot a header\r\n\r\n1\r\n--xyz--",
+			err:   ErrMalformedHeader,
+		},
+	}
+
+	for _, test := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(test.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := readParts(NewMultipartReader(r, "xyz", Limits{}))
+
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s (one byte %v): error %v, want %v", test.name, oneByte, err, test.err)
+			}
+			if len(got) != len(test.parts) {
+				t.Errorf("%s (one byte %v): got %d parts %+v, want %d", test.name, oneByte, len(got), got, len(test.parts))
+				continue
+			}
+			for i := range got {
+				if got[i] != test.parts[i] {
+					t.Errorf("%s (one byte %v): part %d = %+v, want %+v", test.name, oneByte, i, got[i], test.parts[i])
+				}
+			}
+		}
+	}
+}
+
+// readParts reads every part of mr until io.EOF or the first error
+func readParts(mr *MultipartReader) ([]part, error) {
+	var parts []part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part{p.FormName(), p.FileName(), p.Header.Get("Content-Type"), string(body)})
+	}
+}
+
+func TestMultipartReaderSkipsUnreadParts(t *testing.T) {
+	input := "--b\r\n\r\n" + strings.Repeat("x", 10000) + "\r\n--b\r\nContent-Disposition: form-data; name=\"second\"\r\n\r\nok\r\n--b--\r\n"
+	mr := NewMultipartReader(strings.NewReader(input), "b", Limits{})
+
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(p)
+	if p.FormName() != "second" || string(body) != "ok" {
+		t.Errorf("got %q %q, want the second part", p.FormName(), body)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("error %v, want io.EOF", err)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("error %v after the end, want io.EOF", err)
+	}
+}
+
+func TestMultipartReaderLargePart(t *testing.T) {
+	content := strings.Repeat("0123456789\r\n-", 5000)
+	input := "--boundary\r\n\r\n" + content + "\r\n--boundary--\r\n"
+	parts, err := readParts(NewMultipartReader(iotest.HalfReader(strings.NewReader(input)), "boundary", Limits{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].body != content {
+		t.Errorf("large part was not read back intact")
+	}
+}
@@ -0,0 +1,58 @@
+package httpparse
+
+import "strings"
+
+// ParseParams splits a header value like `form-data; name="file"; filename="a.txt"` into its lower-cased
+// leading value and its parameters, keyed by lower-cased name. Quoted values are unescaped.
+func ParseParams(value string) (string, map[string]string, bool) {
+	main, rest, _ := strings.Cut(value, ";")
+	main = strings.ToLower(strings.TrimSpace(main))
+	params := map[string]string{}
+
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			return main, params, true
+		}
+
+		name, after, ok := strings.Cut(rest, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !isToken(name) {
+			return main, params, false
+		}
+		after = strings.TrimLeft(after, " \t")
+
+		var v string
+		if strings.HasPrefix(after, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(after) && after[i] != '"'; i++ {
+				if after[i] == '\\' && i+1 < len(after) {
+					i++
+				}
+				b.WriteByte(after[i])
+			}
+			if i == len(after) {
+				return main, params, false
+			}
+			v, rest = b.String(), after[i+1:]
+		} else {
+			end := strings.IndexByte(after, ';')
+			if end < 0 {
+				end = len(after)
+			}
+			v, rest = strings.TrimRight(after[:end], " \t"), after[end:]
+		}
+		params[name] = v
+	}
+}
+
+// Boundary returns the boundary parameter of a multipart Content-Type
+func Boundary(contentType string) (string, bool) {
+	mediaType, params, ok := ParseParams(contentType)
+	boundary := params["boundary"]
+	if !ok || !strings.HasPrefix(mediaType, "multipart/") || boundary == "" || len(boundary) > 70 {
+		return "", false
+	}
+	return boundary, true
+}
@@ -0,0 +1,54 @@
+package httpparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		value  string
+		main   string
+		params map[string]string
+		ok     bool
+	}{
+		{"text/plain", "text/plain", map[string]string{}, true},
+		{"Text/HTML; Charset=UTF-8", "text/html", map[string]string{"charset": "UTF-8"}, true},
+		{`form-data; name="file"; filename="a.txt"`, "form-data", map[string]string{"name": "file", "filename": "a.txt"}, true},
+		{`form-data; name="a;b"; filename="q\"uote.txt"`, "form-data", map[string]string{"name": "a;b", "filename": `q"uote.txt`}, true},
+		{"multipart/form-data ;boundary = abc ", "multipart/form-data", map[string]string{"boundary": "abc"}, true},
+		{"a; ; b=1;", "a", map[string]string{"b": "1"}, true},
+		{`form-data; name="unterminated`, "form-data", map[string]string{}, false},
+		{"form-data; novalue", "form-data", map[string]string{}, false},
+		{"form-data; =x", "form-data", map[string]string{}, false},
+	}
+
+	for _, test := range tests {
+		main, params, ok := ParseParams(test.value)
+		if main != test.main || !reflect.DeepEqual(params, test.params) || ok != test.ok {
+			t.Errorf("ParseParams(%q) = %q, %v, %v; want %q, %v, %v", test.value, main, params, ok, test.main, test.params, test.ok)
+		}
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	tests := []struct {
+		contentType string
+		boundary    string
+		ok          bool
+	}{
+		{"multipart/form-data; boundary=----WebKitFormBoundary7MA4YWxk", "----WebKitFormBoundary7MA4YWxk", true},
+		{`multipart/mixed; boundary="with space"`, "with space", true},
+		{"multipart/form-data", "", false},
+		{"multipart/form-data; boundary=", "", false},
+		{"text/plain; boundary=abc", "", false},
+		{"multipart/form-data; boundary=" + string(make([]byte, 71)), "", false},
+	}
+
+	for _, test := range tests {
+		boundary, ok := Boundary(test.contentType)
+		if boundary != test.boundary || ok != test.ok {
+			t.Errorf("Boundary(%q) = %q, %v; want %q, %v", test.contentType, boundary, ok, test.boundary, test.ok)
+		}
+	}
+}
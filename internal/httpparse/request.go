@@ -0,0 +1,85 @@
+package httpparse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// RequestLine is the first line of a request
+type RequestLine struct {
+	Method string
+	Target string
+	Proto  string
+}
+
+// String returns the request line as it appears on the wire, without its line ending
+func (l RequestLine) String() string {
+	return l.Method + " " + l.Target + " " + l.Proto
+}
+
+// ParseRequestLine splits a request line into its method, target, and protocol, which must be
+// separated by single spaces. The method must be a token, the target must hold no whitespace or control
+// characters, and the protocol must have the HTTP/<digit>.<digit> form; whether that version is
+// supported is left to the caller.
+func ParseRequestLine(line string) (RequestLine, error) {
+	method, rest, ok1 := strings.Cut(line, " ")
+	target, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !isToken(method) || !isTarget(target) || !isHTTPVersion(proto) {
+		return RequestLine{}, ErrMalformedRequestLine
+	}
+	return RequestLine{Method: method, Target: target, Proto: proto}, nil
+}
+
+// isTarget reports whether s is a non-empty request target without whitespace or control characters
+func isTarget(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// isHTTPVersion reports whether s has the form of an HTTP version, like HTTP/1.1
+func isHTTPVersion(s string) bool {
+	return len(s) == 8 && strings.HasPrefix(s, "HTTP/") &&
+		'0' <= s[5] && s[5] <= '9' && s[6] == '.' && '0' <= s[7] && s[7] <= '9'
+}
+
+// ReadRequestHead reads a request line and its header fields, parsing each field as it is read so
+// memory stays bounded by limits. Empty lines before the request line are skipped as RFC 9112 allows.
+// When the input ends after a complete line but before the empty line, the request line and fields read
+// so far are returned with ErrHeadNotTerminated.
+func ReadRequestHead(r *bufio.Reader, limits Limits) (RequestLine, Header, error) {
+	lr := newReader(r, limits)
+
+	var line string
+	for line == "" {
+		var err error
+		line, err = lr.readLine()
+		if err == io.EOF {
+			return RequestLine{}, nil, ErrEmptyRequest
+		}
+		if err != nil {
+			return RequestLine{}, nil, err
+		}
+	}
+
+	requestLine, err := ParseRequestLine(line)
+	if err != nil {
+		return RequestLine{}, nil, err
+	}
+
+	header, err := lr.readFields()
+	if err == ErrHeadNotTerminated {
+		return requestLine, header, err
+	}
+	if err != nil {
+		return RequestLine{}, nil, err
+	}
+	return requestLine, header, nil
+}
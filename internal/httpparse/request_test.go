@@ -0,0 +1,225 @@
+package httpparse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseRequestLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want RequestLine
+		err  error
+	}{
+		{"GET / HTTP/1.1", RequestLine{"GET", "/", "HTTP/1.1"}, nil},
+		{"POST /files/a.txt?x=1 HTTP/1.0", RequestLine{"POST", "/files/a.txt?x=1", "HTTP/1.0"}, nil},
+		{"OPTIONS * HTTP/1.1", RequestLine{"OPTIONS", "*", "HTTP/1.1"}, nil},
+		{"", RequestLine{}, ErrMalformedRequestLine},
+		{"GET", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /", RequestLine{}, ErrMalformedRequestLine},
+		{"GET  / HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/1.1 extra", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /\tx HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"G(T / HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{" GET / HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / ", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/1.1 ", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /a\x00b HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /a\x7fb HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /a\x1bb HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET /\r HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / http/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/1.10", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/x.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / FTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET / HTTP/2.0", RequestLine{"GET", "/", "HTTP/2.0"}, nil},
+		{"GET / HTTP/1.0", RequestLine{"GET", "/", "HTTP/1.0"}, nil},
+		{"M-SEARCH * HTTP/1.1", RequestLine{"M-SEARCH", "*", "HTTP/1.1"}, nil},
+		{"GET http://example.com/a?b=c HTTP/1.1", RequestLine{"GET", "http://example.com/a?b=c", "HTTP/1.1"}, nil},
+		{"GET /caf\xc3\xa9 HTTP/1.1", RequestLine{"GET", "/caf\xc3\xa9", "HTTP/1.1"}, nil},
+		{"GE\x00T / HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+		{"GET:/ / HTTP/1.1", RequestLine{}, ErrMalformedRequestLine},
+	}
+
+	for _, test := range tests {
+		got, err := ParseRequestLine(test.line)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ParseRequestLine(%q) = %+v, %v; want %+v, %v", test.line, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRequestLineString(t *testing.T) {
+	line := RequestLine{"GET", "/echo/abc", "HTTP/1.1"}
+	if got := line.String(); got != "GET /echo/abc HTTP/1.1" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestReadRequestHead(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		line   RequestLine
+		header Header
+		err    error
+	}{
+		{
+			name:   "no fields",
+			input:  "GET / HTTP/1.1\r\n\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{},
+		},
+		{
+			name:   "fields",
+			input:  "GET /user-agent HTTP/1.1\r\nHost: localhost:4221\r\nuser-agent:  curl/8.0 \r\nAccept: */*\r\n\r\nbody",
+			line:   RequestLine{"GET", "/user-agent", "HTTP/1.1"},
+			header: Header{"Host": {"localhost:4221"}, "User-Agent": {"curl/8.0"}, "Accept": {"*/*"}},
+		},
+		{
+			name:   "repeated fields keep their order",
+			input:  "GET / HTTP/1.1\r\nCookie: a=1\r\ncookie: b=2\r\n\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"Cookie": {"a=1", "b=2"}},
+		},
+		{
+			name:   "bare LF line endings",
+			input:  "GET / HTTP/1.1\nHost: x\n\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"Host": {"x"}},
+		},
+		{
+			name:   "leading empty lines are skipped",
+			input:  "\r\n\r\nGET / HTTP/1.1\r\n\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{},
+		},
+		{
+			name:   "empty value",
+			input:  "GET / HTTP/1.1\r\nX-Empty:\r\n\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"X-Empty": {""}},
+		},
+		{
+			name:   "value containing a colon",
+			input:  "GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"Host": {"example.com:8080"}},
+		},
+		{name: "empty input", input: "", err: ErrEmptyRequest},
+		{name: "only empty lines", input: "\r\n\r\n", err: ErrEmptyRequest},
+		{name: "malformed request line", input: "GET /\r\n\r\n", err: ErrMalformedRequestLine},
+		{name: "truncated request line", input: "GET / HTTP/1.1", err: io.ErrUnexpectedEOF},
+		{
+			name:   "head without its empty line",
+			input:  "GET / HTTP/1.1\r\nHost: x\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"Host": {"x"}},
+			err:    ErrHeadNotTerminated,
+		},
+		{
+			name:   "request line without its empty line",
+			input:  "GET / HTTP/1.1\r\n",
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{},
+			err:    ErrHeadNotTerminated,
+		},
+		{name: "truncated field", input: "GET / HTTP/1.1\r\nHost: x", err: io.ErrUnexpectedEOF},
+		{name: "field without colon", input: "GET / HTTP/1.1\r\nHost\r\n\r\n", err: ErrMalformedHeader},
+		{name: "empty field name", input: "GET / HTTP/1.1\r\n: x\r\n\r\n", err: ErrMalformedHeader},
+		{name: "space before colon", input: "GET / HTTP/1.1\r\nHost : x\r\n\r\n", err: ErrMalformedHeader},
+		{name: "obsolete line folding", input: "GET / HTTP/1.1\r\nX-A: a\r\n b\r\n\r\n", err: ErrMalformedHeader},
+		{
+			name:   "header too large",
+			input:  "GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 100) + "\r\n\r\n",
+			limits: Limits{MaxHeaderBytes: 64},
+			err:    ErrHeaderTooLarge,
+		},
+		{
+			name:   "request line too large",
+			input:  "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n",
+			limits: Limits{MaxHeaderBytes: 64},
+			err:    ErrHeaderTooLarge,
+		},
+		{
+			name:   "exactly at the byte limit",
+			input:  "GET / HTTP/1.1\r\nA: b\r\n\r\n",
+			limits: Limits{MaxHeaderBytes: 24},
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"A": {"b"}},
+		},
+		{
+			name:   "one byte over the limit",
+			input:  "GET / HTTP/1.1\r\nA: b\r\n\r\n",
+			limits: Limits{MaxHeaderBytes: 23},
+			err:    ErrHeaderTooLarge,
+		},
+		{
+			name:   "too many fields",
+			input:  "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+			limits: Limits{MaxHeaderFields: 2},
+			err:    ErrTooManyFields,
+		},
+		{
+			name:   "exactly at the field limit",
+			input:  "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\n\r\n",
+			limits: Limits{MaxHeaderFields: 2},
+			line:   RequestLine{"GET", "/", "HTTP/1.1"},
+			header: Header{"A": {"1"}, "B": {"2"}},
+		},
+	}
+
+	for _, test := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(test.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			// A small buffer makes long lines span several ReadSlice calls
+			line, header, err := ReadRequestHead(bufio.NewReaderSize(r, 16), test.limits)
+
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s (one byte %v): error %v, want %v", test.name, oneByte, err, test.err)
+				continue
+			}
+			if line != test.line || !reflect.DeepEqual(header, test.header) {
+				t.Errorf("%s (one byte %v): got %+v %v, want %+v %v", test.name, oneByte, line, header, test.line, test.header)
+			}
+		}
+	}
+}
+
+func TestReadRequestHeadLeavesBody(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("POST /files/a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	if _, _, err := ReadRequestHead(r, Limits{}); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r)
+	if string(body) != "hello" {
+		t.Errorf("body = %q, want %q", body, "hello")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	header := Header{}
+	header.Add("content-type", "text/plain")
+	header.Add("X-FORWARDED-FOR", "10.0.0.1")
+	header.Add("x-forwarded-for", "10.0.0.2")
+
+	if got := header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Get(Content-Type) = %q", got)
+	}
+	if got := header.Get("X-Missing"); got != "" {
+		t.Errorf("Get(X-Missing) = %q", got)
+	}
+	if got := header.Values("X-Forwarded-For"); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Values(X-Forwarded-For) = %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+package httpparse
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// StatusLine is the first line of a response
+type StatusLine struct {
+	Proto  string
+	Code   int
+	Reason string
+}
+
+// ParseStatusLine splits a status line into its protocol, three digit status code, and reason phrase,
+// which may be empty
+func ParseStatusLine(line string) (StatusLine, error) {
+	proto, rest, ok := strings.Cut(line, " ")
+	code, reason, _ := strings.Cut(rest, " ")
+	if !ok || !isHTTPVersion(proto) || len(code) != 3 || code[0] < '1' || code[0] > '5' {
+		return StatusLine{}, ErrMalformedStatusLine
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return StatusLine{}, ErrMalformedStatusLine
+	}
+	return StatusLine{Proto: proto, Code: n, Reason: reason}, nil
+}
+
+// ReadResponseHead reads a status line and its header fields, bounded by limits like a request head. An
+// input that ends before the empty line ending the head is reported as io.ErrUnexpectedEOF.
+func ReadResponseHead(r *bufio.Reader, limits Limits) (StatusLine, Header, error) {
+	lr := newReader(r, limits)
+
+	line, err := lr.readLine()
+	if err != nil {
+		return StatusLine{}, nil, err
+	}
+	statusLine, err := ParseStatusLine(line)
+	if err != nil {
+		return StatusLine{}, nil, err
+	}
+
+	header, err := lr.readFields()
+	if err == ErrHeadNotTerminated {
+		return StatusLine{}, nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return StatusLine{}, nil, err
+	}
+	return statusLine, header, nil
+}
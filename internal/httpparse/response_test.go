@@ -0,0 +1,92 @@
+package httpparse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStatusLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want StatusLine
+		err  error
+	}{
+		{"HTTP/1.1 200 OK", StatusLine{"HTTP/1.1", 200, "OK"}, nil},
+		{"HTTP/1.0 404 Not Found", StatusLine{"HTTP/1.0", 404, "Not Found"}, nil},
+		{"HTTP/1.1 204", StatusLine{"HTTP/1.1", 204, ""}, nil},
+		{"HTTP/1.1 100 ", StatusLine{"HTTP/1.1", 100, ""}, nil},
+		{"", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1 20 OK", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1 2000 OK", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1 600 Odd", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1 2x0 OK", StatusLine{}, ErrMalformedStatusLine},
+		{"HTTP/1.1 +20 OK", StatusLine{}, ErrMalformedStatusLine},
+		{"http/1.1 200 OK", StatusLine{}, ErrMalformedStatusLine},
+	}
+
+	for _, test := range tests {
+		got, err := ParseStatusLine(test.line)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ParseStatusLine(%q) = %+v, %v, want %+v, %v", test.line, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestReadResponseHead(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   StatusLine
+		header Header
+		err    error
+	}{
+		{
+			name:   "fields",
+			input:  "HTTP/1.1 200 OK\r\nContent-Length: 5\r\ncontent-type: text/plain\r\n\r\nhello",
+			line:   StatusLine{"HTTP/1.1", 200, "OK"},
+			header: Header{"Content-Length": {"5"}, "Content-Type": {"text/plain"}},
+		},
+		{
+			name:  "truncated head",
+			input: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "empty input",
+			input: "",
+			err:   io.EOF,
+		},
+		{
+			name:  "malformed status line",
+			input: "HTTP/1.1 OK\r\n\r\n",
+			err:   ErrMalformedStatusLine,
+		},
+		{
+			name:  "malformed field",
+			input: "HTTP/1.1 200 OK\r\nno colon\r\n\r\n",
+			err:   ErrMalformedHeader,
+		},
+	}
+
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.input))
+		line, header, err := ReadResponseHead(r, Limits{})
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if line != test.line || !reflect.DeepEqual(header, test.header) {
+			t.Errorf("%s: got %+v %v, want %+v %v", test.name, line, header, test.line, test.header)
+		}
+		if test.err == nil {
+			if body, _ := io.ReadAll(r); string(body) != "hello" {
+				t.Errorf("%s: body = %q, want %q", test.name, body, "hello")
+			}
+		}
+	}
+}
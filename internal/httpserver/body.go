@@ -0,0 +1,176 @@
+package httpserver
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+)
+
+// maxDrainedBody bounds how much of a request body the handler left unread is discarded to keep the
+// connection, past it reusing the connection costs more than opening a new one
+const maxDrainedBody = 256 << 10
+
+// requestBody returns the reader of the request's body, which ends where the body does so a handler can't
+// read into the next request, and a drain func that discards what the handler left unread, reporting
+// whether the connection is still in step with the client afterwards. A client waiting with
+// Expect: 100-continue is told to send the body once the handler starts reading it.
+func (c *serverConn) requestBody(r *Request) (*bufio.Reader, func() bool, error) {
+	expectContinue, err := expectsContinue(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A client still waiting for 100 Continue may or may not send the body it announced, so where the
+	// next request starts is unknown
+	cont := &continueReader{send: c.sendContinue, sent: !expectContinue}
+
+	if codings := r.Header.Values("Transfer-Encoding"); len(codings) > 0 {
+		if len(codings) > 1 || !strings.EqualFold(strings.TrimSpace(codings[0]), "chunked") {
+			return nil, nil, ErrNotImplemented.WithDetail("unsupported transfer coding")
+		}
+
+		// Transfer-Encoding overrides Content-Length, but a request with both may be an attempt to
+		// smuggle a request past a proxy that framed it by the length, so the connection isn't reused
+		_, smuggled := r.Header["Content-Length"]
+		delete(r.Header, "Content-Length")
+
+		cont.r = httpparse.NewChunkedReader(c.reader, c.server.limits)
+		if max := c.server.config.MaxBodySize; max > 0 {
+			cont.r = LimitBody(cont.r, max)
+		}
+		body := bufio.NewReaderSize(cont, c.server.config.ReadBufferSize)
+		return body, func() bool {
+			if !cont.sent {
+				return false
+			}
+			n, err := io.CopyN(io.Discard, body, maxDrainedBody+1)
+			return !smuggled && err == io.EOF && n <= maxDrainedBody
+		}, nil
+	}
+
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "" {
+		return emptyBody(), func() bool { return true }, nil
+	}
+
+	// The handler refuses an invalid length, but where the next request starts is unknown then
+	length, err := strconv.ParseInt(contentLength, 10, 64)
+	if err != nil || length < 0 {
+		return emptyBody(), func() bool { return false }, nil
+	}
+	if length == 0 {
+		return emptyBody(), func() bool { return true }, nil
+	}
+	// The body isn't read, so the connection can't carry another request after the refusal
+	if max := c.server.config.MaxBodySize; max > 0 && length > max {
+		return nil, nil, ErrEntityTooLarge
+	}
+
+	limited := &io.LimitedReader{R: c.reader, N: length}
+	cont.r = limited
+	body := bufio.NewReaderSize(cont, int(min(length, int64(c.server.config.ReadBufferSize))))
+	return body, func() bool {
+		if !cont.sent || limited.N > maxDrainedBody {
+			return false
+		}
+		_, err := io.Copy(io.Discard, limited)
+		return err == nil && limited.N == 0
+	}, nil
+}
+
+// maxBytesReader reads at most n more bytes from r, failing with a 413 once the body goes past them
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+// LimitBody returns a reader of body that fails with ErrEntityTooLarge once more than n bytes were read
+// from it, for bodies whose length isn't declared up front
+func LimitBody(body io.Reader, n int64) io.Reader {
+	return &maxBytesReader{r: body, n: n}
+}
+
+// Read implements io.Reader
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, ErrEntityTooLarge
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	if m.n -= int64(n); m.n < 0 {
+		return n + int(m.n), ErrEntityTooLarge
+	}
+	return n, err
+}
+
+// emptyBody returns the body of a request that doesn't carry one
+func emptyBody() *bufio.Reader {
+	return bufio.NewReaderSize(strings.NewReader(""), 16)
+}
+
+// expectsContinue reports whether the client waits for 100 Continue before sending the request body,
+// returning a 417 for any expectation other than 100-continue. HTTP/1.0 clients don't know interim
+// responses, so their Expect is ignored.
+func expectsContinue(r *Request) (bool, error) {
+	if r.Proto == "HTTP/1.0" {
+		return false, nil
+	}
+
+	expect := false
+	for _, value := range r.Header.Values("Expect") {
+		for _, expectation := range strings.Split(value, ",") {
+			switch expectation = strings.TrimSpace(expectation); {
+			case expectation == "":
+			case strings.EqualFold(expectation, "100-continue"):
+				expect = true
+			default:
+				return false, ErrExpectationFailed.WithDetail("unsupported expectation " + expectation)
+			}
+		}
+	}
+	return expect, nil
+}
+
+// continueReader sends the 100 Continue a client waiting with Expect: 100-continue needs in order to
+// send the body once the handler starts reading it, so a handler refusing the request before reading
+// its body spares the client from sending it
+type continueReader struct {
+	r    io.Reader
+	send func() error
+
+	// sent is set once the client has been told to send the body, or when it isn't waiting to be told
+	sent bool
+}
+
+// Read implements io.Reader
+func (c *continueReader) Read(p []byte) (int, error) {
+	if !c.sent {
+		c.sent = true
+		if err := c.send(); err != nil {
+			return 0, err
+		}
+	}
+	return c.r.Read(p)
+}
+
+// wantsKeepAlive reports whether the client will send further requests on the connection. HTTP/1.1
+// connections are persistent unless the client sends Connection: close, HTTP/1.0 ones are closed after
+// the response unless the client opts in with Connection: keep-alive.
+func wantsKeepAlive(r *Request) bool {
+	keepAlive := r.Proto == "HTTP/1.1"
+	for _, value := range r.Header.Values("Connection") {
+		for _, option := range strings.Split(value, ",") {
+			switch option = strings.TrimSpace(option); {
+			case strings.EqualFold(option, "close"):
+				return false
+			case strings.EqualFold(option, "keep-alive"):
+				keepAlive = true
+			}
+		}
+	}
+	return keepAlive
+}
@@ -0,0 +1,238 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// serverConn is a connection and the state shared by the requests served on it
+type serverConn struct {
+	server   *Server
+	rwc      net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	recorder *responseRecorder
+	received *countingReader
+}
+
+// serveRequest reads and answers one request, reporting whether the connection can carry another
+func (c *serverConn) serveRequest(first bool) bool {
+	config := &c.server.config
+
+	// A client that doesn't start a request in time is disconnected without a response, the first
+	// request of a connection gets ReadTimeout since the client connected in order to send it
+	c.setState(StateIdle)
+	timeout := config.IdleTimeout
+	if first {
+		timeout = config.ReadTimeout
+	}
+	setReadTimeout(c.rwc, timeout)
+	// A connection going idle while keep-alives are disabled would otherwise wait out its timeout
+	if !first && c.server.keepAlivesDisabled.Load() {
+		return false
+	}
+	if _, err := c.reader.Peek(1); err != nil {
+		return false
+	}
+
+	c.setState(StateReading)
+	setReadTimeout(c.rwc, config.ReadTimeout)
+	r, err := readRequest(c.reader, c.server.limits, c.server.upgrades)
+	setReadTimeout(c.rwc, 0)
+	c.setState(StateWriting)
+	c.recorder.reset()
+
+	if err != nil {
+		c.server.logf("Error reading request: %s\n", err.Error())
+		config.WriteError(c.writer, err)
+		c.writer.Flush()
+		return false
+	}
+	r.RemoteAddr, r.Conn, r.TLS = c.rwc.RemoteAddr().String(), c.rwc, connectionState(c.rwc)
+	started := time.Now()
+
+	// HTTP/1.0 clients only reuse the connection when the response says it stays open, and HEAD requests
+	// are answered like GET ones with the body left out on the way
+	writer := c.writer
+	var out io.Writer = c.writer
+	if r.Proto == "HTTP/1.0" && wantsKeepAlive(r) {
+		// An HTTP/1.0 client needs to see Connection: keep-alive to reuse the connection
+		out = WithFields(out, [2]string{"Connection", "keep-alive"})
+	}
+	if r.Method == "HEAD" {
+		out = &headOnlyWriter{w: out}
+	}
+	if out != io.Writer(c.writer) {
+		writer = c.server.newWriter(out)
+		defer c.server.releaseWriter(writer)
+	}
+
+	body, drain, err := c.requestBody(r)
+	if err != nil {
+		r.Body = emptyBody()
+		config.WriteError(writer, err)
+		writer.Flush()
+		c.writer.Flush()
+		c.requestDone(r, started)
+		return false
+	}
+	r.Body = body
+	r.hijack = func() (*bufio.Reader, *bufio.Writer) {
+		writer.Flush()
+		return c.reader, c.writer
+	}
+
+	w := NewResponse(writer)
+	if err := config.Handler(w, r); err != nil {
+		config.WriteError(writer, err)
+	} else {
+		w.Finish()
+	}
+
+	writer.Flush()
+	flushErr := c.writer.Flush()
+	c.requestDone(r, started)
+
+	return flushErr == nil && wantsKeepAlive(r) && c.recorder.persistent(r.Method) && !c.server.keepAlivesDisabled.Load() && !r.closeAfter && drain()
+}
+
+// sendContinue will tell the client to go on sending the request body. The interim response isn't
+// part of the response RequestDone is told about.
+func (c *serverConn) sendContinue() error {
+	c.writer.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	err := c.writer.Flush()
+	c.recorder.reset()
+	return err
+}
+
+// setState reports the connection's new state to Config.ConnState
+func (c *serverConn) setState(state ConnState) {
+	if c.server.config.ConnState != nil {
+		c.server.config.ConnState(c.rwc, state)
+	}
+}
+
+// requestDone reports the response to r to Config.RequestDone
+func (c *serverConn) requestDone(r *Request, started time.Time) {
+	if c.server.config.RequestDone == nil {
+		return
+	}
+	status, _ := strconv.Atoi(c.recorder.status)
+	c.server.config.RequestDone(r, ResponseInfo{Status: status, Bytes: c.recorder.n, Received: c.received.take(), Started: started})
+}
+
+// deadlineWriter renews the write deadline of conn before every write, so only a client that stops
+// reading for longer than timeout fails the write, however long the whole response takes
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Write implements io.Writer
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.conn.Write(p)
+}
+
+// deadlineChunk is how much of a body deadlineWriter.ReadFrom sends before renewing the deadline
+const deadlineChunk = 1 << 20
+
+// ReadFrom implements io.ReaderFrom, sending src to the connection in chunks of deadlineChunk bytes with
+// the deadline renewed before each. The chunks are read straight from a file src limits, so the
+// connection can still send them with sendfile.
+func (d *deadlineWriter) ReadFrom(src io.Reader) (int64, error) {
+	remaining := int64(math.MaxInt64)
+	limited, isLimited := src.(*io.LimitedReader)
+	if isLimited {
+		src, remaining = limited.R, limited.N
+		defer func() { limited.N = remaining }()
+	}
+
+	var written int64
+	for remaining > 0 {
+		d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+		n, err := io.CopyN(d.conn, src, min(remaining, deadlineChunk))
+		written, remaining = written+n, remaining-n
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// take returns the count and starts counting again from zero
+func (c *countingReader) take() int64 {
+	n := c.n
+	c.n = 0
+	return n
+}
+
+const (
+	// lingerTimeout bounds how long a connection is drained after its response before it is closed
+	lingerTimeout = 2 * time.Second
+
+	// lingerBytes bounds how much unread input is drained after a response
+	lingerBytes = 256 << 10
+)
+
+// closeConnection closes conn once the response has been flushed. The sending side is shut down first
+// and any input the client is still sending is drained for a moment, since closing a socket with unread
+// data makes the kernel reset the connection, which can discard the response before the client reads it.
+// A client that already shut down its own sending side ends the drain right away.
+func closeConnection(conn net.Conn) {
+	halfCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok || halfCloser.CloseWrite() != nil {
+		conn.Close()
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.CopyN(io.Discard, conn, lingerBytes)
+	conn.Close()
+}
+
+// setReadTimeout makes reads from conn fail once timeout has passed, a zero timeout clears the deadline
+func setReadTimeout(conn net.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		conn.SetReadDeadline(time.Time{})
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// connectionState returns the state of conn's TLS connection, or nil when it isn't one. Connections
+// wrapping another, like a recording does, have an Unwrap method returning it.
+func connectionState(conn net.Conn) *tls.ConnectionState {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			state := c.ConnectionState()
+			return &state
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package httpserver is the server's HTTP/1.1 engine: it reads requests off connections, frames their
+// bodies, routes them to handlers, and keeps connections alive between requests. What the server serves
+// is plugged in through Config, so the engine can be run and tested on its own over any net.Listener.
+package httpserver
@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Encoder applies a content coding to response bodies
+type Encoder interface {
+	// Name returns the coding as sent in Content-Encoding, like gzip
+	Name() string
+
+	// NewWriter returns a writer encoding into w, the encoding is complete once it is closed
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// contentEncoders are the codings offered to clients, earlier ones are preferred when the client
+// accepts several equally
+var contentEncoders = []Encoder{gzipEncoder{}, deflateEncoder{}}
+
+// Encoders returns the codings offered to clients, in order of preference
+func Encoders() []Encoder {
+	return slices.Clone(contentEncoders)
+}
+
+// gzipEncoder is the gzip coding
+type gzipEncoder struct{}
+
+// Name implements Encoder
+func (gzipEncoder) Name() string { return "gzip" }
+
+// NewWriter implements Encoder
+func (gzipEncoder) NewWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+// deflateEncoder is the deflate coding, which HTTP defines as the zlib format rather than raw deflate
+type deflateEncoder struct{}
+
+// Name implements Encoder
+func (deflateEncoder) Name() string { return "deflate" }
+
+// NewWriter implements Encoder
+func (deflateEncoder) NewWriter(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+
+// NegotiateEncoding picks the encoder for the response to the request from its Accept-Encoding, nil
+// when the body is sent as is. Without Accept-Encoding nothing is encoded, and a client refusing
+// identity as well as every encoder gets ErrNotAcceptable.
+func NegotiateEncoding(r *Request) (Encoder, error) {
+	values := r.Header.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return nil, nil
+	}
+	accepted := parseAcceptEncoding(values)
+
+	var best Encoder
+	bestQuality := 0.0
+	for _, encoder := range contentEncoders {
+		if q := codingQuality(accepted, encoder.Name()); q > bestQuality {
+			best, bestQuality = encoder, q
+		}
+	}
+
+	identity := codingQuality(accepted, "identity")
+	switch {
+	case best != nil && bestQuality >= identity:
+		return best, nil
+	case identity > 0:
+		return nil, nil
+	default:
+		return nil, ErrNotAcceptable
+	}
+}
+
+// parseAcceptEncoding returns the quality of every coding listed in the Accept-Encoding values, keyed
+// by its lower-cased name. Entries with an invalid quality are ignored.
+func parseAcceptEncoding(values []string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(entry, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			quality := 1.0
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(q, 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					continue
+				}
+				quality = parsed
+			}
+			accepted[name] = quality
+		}
+	}
+	return accepted
+}
+
+// codingQuality returns the quality the client gives coding, falling back to its * entry. identity is
+// acceptable unless the client refuses it by name or through *.
+func codingQuality(accepted map[string]float64, coding string) float64 {
+	if q, ok := accepted[coding]; ok {
+		return q
+	}
+	if q, ok := accepted["*"]; ok {
+		return q
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
+}
+
+// EncodeBody returns body encoded with encoder
+func EncodeBody(encoder Encoder, body []byte) ([]byte, error) {
+	var encoded bytes.Buffer
+	w := encoder.NewWriter(&encoded)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// WithContentEncoding wraps handler so that its response is encoded in the coding negotiated with
+// the client, unless the handler wrote the response itself or already set a Content-Encoding
+func WithContentEncoding(handler Handler) Handler {
+	return func(w *Response, r *Request) error {
+		encoder, err := NegotiateEncoding(r)
+		if err != nil {
+			return err
+		}
+		if err := handler(w, r); err != nil {
+			return err
+		}
+		if !w.used || w.Header("Content-Encoding") != "" {
+			return nil
+		}
+
+		w.SetHeader("Vary", "Accept-Encoding")
+		if encoder == nil {
+			return nil
+		}
+		encoded, err := EncodeBody(encoder, w.body.Bytes())
+		if err != nil {
+			return err
+		}
+		w.body.Reset()
+		w.body.Write(encoded)
+		w.SetHeader("Content-Encoding", encoder.Name())
+		return nil
+	}
+}
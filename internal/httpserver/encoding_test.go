@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		err    error
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "deflate", want: "deflate"},
+		{accept: "gzip, deflate", want: "gzip"},
+		{accept: "deflate;q=1, gzip;q=0.5", want: "deflate"},
+		{accept: "GZIP", want: "gzip"},
+		{accept: "br", want: ""},
+		{accept: "gzip;q=0", want: ""},
+		{accept: "gzip;q=0.5, identity;q=1", want: ""},
+		{accept: "*", want: "gzip"},
+		{accept: "gzip;q=2", want: ""},
+		{accept: "identity;q=0", err: ErrNotAcceptable},
+		{accept: "*;q=0", err: ErrNotAcceptable},
+		{accept: "*;q=0, deflate", want: "deflate"},
+	}
+
+	for _, test := range tests {
+		r := &Request{Header: Header{}}
+		if test.accept != "" {
+			r.Header["Accept-Encoding"] = []string{test.accept}
+		}
+		encoder, err := NegotiateEncoding(r)
+		got := ""
+		if encoder != nil {
+			got = encoder.Name()
+		}
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("%q: got %q %v, want %q %v", test.accept, got, err, test.want, test.err)
+		}
+	}
+}
+
+// decode returns body decoded from coding
+func decode(t *testing.T, coding string, body string) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch coding {
+	case "gzip":
+		r, err = gzip.NewReader(strings.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(strings.NewReader(body))
+	default:
+		return body
+	}
+	if err != nil {
+		t.Fatalf("decoding %s: %v", coding, err)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", coding, err)
+	}
+	return string(decoded)
+}
+
+func TestWithContentEncoding(t *testing.T) {
+	text := strings.Repeat("compress me ", 100)
+	router := NewRouter()
+	router.Handle("GET", "/text", WithContentEncoding(func(w *Response, r *Request) error {
+		w.WriteString(text)
+		return nil
+	}))
+	router.Handle("GET", "/encoded", WithContentEncoding(func(w *Response, r *Request) error {
+		w.SetHeader("Content-Encoding", "br")
+		w.WriteString("already")
+		return nil
+	}))
+	server := New(Config{Handler: router.Serve})
+
+	tests := []struct {
+		target string
+		accept string
+		status int
+		coding string
+		vary   string
+	}{
+		{target: "/text", status: 200, vary: "Accept-Encoding"},
+		{target: "/text", accept: "gzip", status: 200, coding: "gzip", vary: "Accept-Encoding"},
+		{target: "/text", accept: "deflate", status: 200, coding: "deflate", vary: "Accept-Encoding"},
+		{target: "/text", accept: "br", status: 200, vary: "Accept-Encoding"},
+		{target: "/text", accept: "identity;q=0", status: 406},
+		{target: "/encoded", accept: "gzip", status: 200, coding: "br"},
+	}
+
+	for _, test := range tests {
+		raw := "GET " + test.target + " HTTP/1.1\r\nHost: x\r\n"
+		if test.accept != "" {
+			raw += "Accept-Encoding: " + test.accept + "\r\n"
+		}
+		responses, _ := exchange(t, server, raw+"\r\n", "GET")
+		got := responses[0]
+		if got.status != test.status || got.header.Get("Content-Encoding") != test.coding || got.header.Get("Vary") != test.vary {
+			t.Errorf("%s %q: got %d coding %q vary %q, want %d %q %q", test.target, test.accept,
+				got.status, got.header.Get("Content-Encoding"), got.header.Get("Vary"), test.status, test.coding, test.vary)
+			continue
+		}
+		if test.target == "/text" && test.status == 200 {
+			if decoded := decode(t, test.coding, got.body); decoded != text {
+				t.Errorf("%q: decoded body %q", test.accept, decoded)
+			}
+		}
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	body := bytes.Repeat([]byte("abc"), 1000)
+	for _, encoder := range Encoders() {
+		encoded, err := EncodeBody(encoder, body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoder.Name(), err)
+		}
+		if len(encoded) >= len(body) {
+			t.Errorf("%s: encoded %d bytes into %d", encoder.Name(), len(body), len(encoded))
+		}
+		if decoded := decode(t, encoder.Name(), string(encoded)); decoded != string(body) {
+			t.Errorf("%s: round trip changed the body", encoder.Name())
+		}
+	}
+}
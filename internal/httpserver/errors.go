@@ -0,0 +1,141 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+)
+
+// Error is an error answered with an HTTP status. Handlers return one, possibly wrapped with %w,
+// instead of writing a response, and Config.WriteError turns it into the status line, header, and body.
+// A handler returning an error must not have written anything yet.
+type Error struct {
+	code   int
+	reason string
+
+	// detail is sent as a plain text body explaining the refusal
+	detail string
+
+	// header holds extra response header fields such as Retry-After
+	header [][2]string
+}
+
+var (
+	ErrBadRequest          = &Error{code: 400, reason: "Bad Request"}
+	ErrUnauthorized        = &Error{code: 401, reason: "Unauthorized"}
+	ErrForbidden           = &Error{code: 403, reason: "Forbidden"}
+	ErrNotFound            = &Error{code: 404, reason: "Not Found"}
+	ErrMethodNotAllowed    = &Error{code: 405, reason: "Method Not Allowed"}
+	ErrNotAcceptable       = &Error{code: 406, reason: "Not Acceptable"}
+	ErrTimeout             = &Error{code: 408, reason: "Request Timeout"}
+	ErrConflict            = &Error{code: 409, reason: "Conflict"}
+	ErrGone                = &Error{code: 410, reason: "Gone"}
+	ErrPreconditionFailed  = &Error{code: 412, reason: "Precondition Failed"}
+	ErrEntityTooLarge      = &Error{code: 413, reason: "Content Too Large"}
+	ErrUnsupportedMedia    = &Error{code: 415, reason: "Unsupported Media Type"}
+	ErrRangeNotSatisfiable = &Error{code: 416, reason: "Range Not Satisfiable"}
+	ErrExpectationFailed   = &Error{code: 417, reason: "Expectation Failed"}
+	ErrUnprocessable       = &Error{code: 422, reason: "Unprocessable Entity"}
+	ErrUpgradeRequired     = &Error{code: 426, reason: "Upgrade Required"}
+	ErrTooManyRequests     = &Error{code: 429, reason: "Too Many Requests"}
+	ErrHeaderTooLarge      = &Error{code: 431, reason: "Request Header Fields Too Large"}
+	ErrInternal            = &Error{code: 500, reason: "Internal Server Error"}
+	ErrNotImplemented      = &Error{code: 501, reason: "Not Implemented"}
+	ErrBadGateway          = &Error{code: 502, reason: "Bad Gateway"}
+	ErrUnavailable         = &Error{code: 503, reason: "Service Unavailable"}
+	ErrGatewayTimeout      = &Error{code: 504, reason: "Gateway Timeout"}
+	ErrVersionNotSupported = &Error{code: 505, reason: "HTTP Version Not Supported"}
+	ErrInsufficientStorage = &Error{code: 507, reason: "Insufficient Storage"}
+)
+
+// Error implements error
+func (e *Error) Error() string {
+	if e.detail != "" {
+		return e.Status() + ": " + e.detail
+	}
+	return e.Status()
+}
+
+// Is makes errors.Is match any error with the same status code, whatever its detail and header
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.code == e.code
+}
+
+// Code returns the status code, like 404
+func (e *Error) Code() int {
+	return e.code
+}
+
+// Status returns the status code and reason phrase, like "404 Not Found"
+func (e *Error) Status() string {
+	return strconv.Itoa(e.code) + " " + e.reason
+}
+
+// Detail returns the text explaining the refusal to the client, empty when there is none
+func (e *Error) Detail() string {
+	return e.detail
+}
+
+// Fields returns the extra header fields sent with the error, as name and value pairs
+func (e *Error) Fields() [][2]string {
+	return e.header
+}
+
+// WithDetail returns a copy of e explaining the refusal to the client with detail
+func (e *Error) WithDetail(detail string) *Error {
+	c := *e
+	c.detail = detail
+	return &c
+}
+
+// WithHeader returns a copy of e that also sends the header field name
+func (e *Error) WithHeader(name string, value string) *Error {
+	c := *e
+	c.header = append(append([][2]string(nil), e.header...), [2]string{name, value})
+	return &c
+}
+
+// StatusLine returns the status code and its reason phrase, like "200 OK"
+func StatusLine(code int) string {
+	return (&Error{code: code, reason: http.StatusText(code)}).Status()
+}
+
+// StatusOf maps err onto the status it is answered with. Errors that aren't an *Error are classified
+// by the parsing or network error they wrap, anything unrecognized is a 500.
+func StatusOf(err error) *Error {
+	var httpErr *Error
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr
+	case errors.Is(err, httpparse.ErrHeaderTooLarge), errors.Is(err, httpparse.ErrTooManyFields):
+		return ErrHeaderTooLarge
+	case errors.Is(err, httpparse.ErrEmptyRequest),
+		errors.Is(err, httpparse.ErrMalformedRequestLine),
+		errors.Is(err, httpparse.ErrMalformedHeader),
+		errors.Is(err, httpparse.ErrMalformedChunk),
+		errors.Is(err, httpparse.ErrMalformedMultipart):
+		return ErrBadRequest
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	default:
+		return ErrInternal
+	}
+}
+
+// WriteError will respond to the request that failed with err, with the text of its detail as the body.
+// It is what a Server answers errors with unless Config.WriteError is set.
+func WriteError(writer *bufio.Writer, err error) {
+	status := StatusOf(err)
+	fields := status.header
+	if status.detail != "" {
+		fields = append(fields[:len(fields):len(fields)], [2]string{"Content-Type", "text/plain"})
+	}
+	writeResponse(writer, status.Status(), fields, []byte(status.detail))
+}
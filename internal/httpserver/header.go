@@ -0,0 +1,39 @@
+package httpserver
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// Header holds header field values by canonical field name, in the order they were received
+type Header map[string][]string
+
+// Get returns the first value of the named field, or an empty string if it is not present
+func (h Header) Get(name string) string {
+	if values := h[textproto.CanonicalMIMEHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of the named field
+func (h Header) Values(name string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// ParseResponseHead splits the status line and header fields off a buffered response, ok is false when
+// raw doesn't hold a complete head
+func ParseResponseHead(raw []byte) (string, Header, bool) {
+	block, _, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	status, fields, _ := strings.Cut(string(block), "\r\n")
+
+	header := Header{}
+	for _, line := range strings.Split(fields, "\r\n") {
+		if name, value, found := strings.Cut(line, ":"); found {
+			key := textproto.CanonicalMIMEHeaderKey(name)
+			header[key] = append(header[key], strings.TrimSpace(value))
+		}
+	}
+	return status, header, ok
+}
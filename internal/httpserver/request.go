@@ -0,0 +1,186 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+)
+
+// Request is a request read off a connection. Its head is available from the start, the engine sets
+// up Body once it knows how the body is framed.
+type Request struct {
+	Method string
+	Target string
+	// Proto is HTTP/1.0 or HTTP/1.1, later HTTP/1 minor versions are served as HTTP/1.1
+	Proto  string
+	Header Header
+
+	// Body reads the request body, it is empty for requests without one
+	Body *bufio.Reader
+
+	// Path is the target's decoded path without the surrounding slashes, RawPath the same path as sent
+	Path    string
+	RawPath string
+
+	// RemoteAddr is the address of the connection's peer
+	RemoteAddr string
+
+	// Conn is the connection the request arrived on
+	Conn net.Conn
+
+	// TLS is the state of the TLS connection the request arrived on, nil for plaintext requests
+	TLS *tls.ConnectionState
+
+	query  url.Values
+	params map[string]string
+
+	// closeAfter is set by a handler whose response was cut short, so the connection is closed after it
+	closeAfter bool
+
+	// hijack hands the connection's buffers over to the handler, the engine sets it up with the body
+	hijack func() (*bufio.Reader, *bufio.Writer)
+}
+
+// Query returns the parsed query string of the target
+func (r *Request) Query() url.Values {
+	return r.query
+}
+
+// Param returns the decoded path the route pattern's {name} segment matched, or "" when it has none
+func (r *Request) Param(name string) string {
+	return r.params[name]
+}
+
+// RequestLine returns the request line as the client sent it, apart from a normalized protocol
+func (r *Request) RequestLine() string {
+	return r.Method + " " + r.Target + " " + r.Proto
+}
+
+// CloseAfterResponse makes the connection close once the response has been sent, for handlers whose
+// response was cut short so the client can't tell where it ends
+func (r *Request) CloseAfterResponse() {
+	r.closeAfter = true
+}
+
+// Hijack takes the connection over for another protocol, after the handler wrote a 101 Switching
+// Protocols response. It returns the connection's reader, which may already hold what the client sent
+// after the request, and its writer, which the response written so far is flushed to. The handler is in
+// charge of the connection until it returns, the engine then closes it instead of reading another request.
+func (r *Request) Hijack() (*bufio.Reader, *bufio.Writer) {
+	r.closeAfter = true
+	return r.hijack()
+}
+
+// readRequest reads the request line and header fields from the client, parsing each field into the
+// header map as it is read so memory stays bounded by limits. Upgrade is only kept for the protocols
+// in upgrades.
+func readRequest(reader *bufio.Reader, limits httpparse.Limits, upgrades map[string]bool) (*Request, error) {
+	line, header, err := httpparse.ReadRequestHead(reader, limits)
+	// A client that shut down its sending side right after its last header field still gets its response
+	if err == httpparse.ErrHeadNotTerminated {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Later HTTP/1 minor versions are compatible with HTTP/1.1 and served as it, other major versions
+	// can't be understood at all
+	proto := line.Proto
+	switch {
+	case proto[5] != '1':
+		return nil, ErrVersionNotSupported
+	case proto != "HTTP/1.0":
+		proto = "HTTP/1.1"
+	}
+
+	r := &Request{Method: line.Method, Target: line.Target, Proto: proto, Header: Header(header)}
+	stripHopByHop(r.Header, upgrades)
+
+	// HTTP/1.1 requires exactly one Host, so a request can't be routed to different hosts along the way
+	if proto == "HTTP/1.1" && len(r.Header.Values("Host")) != 1 {
+		return nil, ErrBadRequest.WithDetail("an HTTP/1.1 request needs exactly one Host header field")
+	}
+
+	// RawPath is kept encoded, so handlers can tell an encoded slash from a separator, but its
+	// escapes are checked here so decoding it later can't fail
+	target, rawQuery, _ := strings.Cut(r.Target, "?")
+	r.RawPath = strings.Trim(target, "/")
+	if _, err := url.PathUnescape(r.RawPath); err != nil {
+		return nil, ErrBadRequest.WithDetail("malformed percent-encoding in the request path")
+	}
+	r.Path = DecodedPath(r.RawPath)
+	if r.query, err = url.ParseQuery(rawQuery); err != nil {
+		return nil, ErrBadRequest.WithDetail("malformed query string")
+	}
+
+	return r, nil
+}
+
+// DecodedPath returns the percent-decoded path, which for a Request's RawPath is checked to decode
+func DecodedPath(path string) string {
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return path
+	}
+	return decoded
+}
+
+// hopByHopFields are the fields RFC 9110 defines as only meaningful for a single connection. Connection
+// and Transfer-Encoding are hop-by-hop too, but they describe the connection to this server and the
+// framing of the request body, so they are kept for the server itself and must be dropped when forwarding.
+var hopByHopFields = []string{"Keep-Alive", "Proxy-Connection", "Te", "Upgrade"}
+
+// framingFields can't be removed by listing them in Connection, the request couldn't be read without them
+var framingFields = map[string]bool{"Host": true, "Content-Length": true, "Transfer-Encoding": true, "Connection": true}
+
+// EndToEnd returns a copy of header without the fields a proxy must not forward: the known hop-by-hop
+// fields, Connection and Transfer-Encoding, and whatever Connection names. It suits both the header of a
+// request forwarded upstream and that of the response relayed back.
+func EndToEnd(header Header) Header {
+	out := Header{}
+	for name, values := range header {
+		out[name] = slices.Clone(values)
+	}
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			delete(out, textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range hopByHopFields {
+		delete(out, name)
+	}
+	delete(out, "Connection")
+	delete(out, "Transfer-Encoding")
+	return out
+}
+
+// stripHopByHop removes the fields named in the request's Connection field and the known hop-by-hop
+// fields, so handlers only see end-to-end fields. Upgrade is kept when it asks for one of the
+// lower-cased protocols in upgrades.
+func stripHopByHop(header Header, upgrades map[string]bool) {
+	// Upgrade is read first, a client asking for one names it in Connection as well
+	upgrade := header.Get("Upgrade")
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			if !framingFields[key] {
+				delete(header, key)
+			}
+		}
+	}
+
+	for _, name := range hopByHopFields {
+		delete(header, name)
+	}
+
+	protocol, _, _ := strings.Cut(upgrade, "/")
+	if upgrade != "" && upgrades[strings.ToLower(strings.TrimSpace(protocol))] {
+		header["Upgrade"] = []string{upgrade}
+	}
+}
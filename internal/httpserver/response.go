@@ -0,0 +1,278 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Response collects the status, header fields, and body a handler answers with. The body is buffered
+// so its Content-Length can be declared once the handler returns, which also means nothing reaches the
+// connection before then and a handler may still return an error after calling Write.
+type Response struct {
+	writer *bufio.Writer
+	code   int
+	header [][2]string
+	body   bytes.Buffer
+	used   bool
+}
+
+// NewResponse returns a response that will be written to writer by Finish
+func NewResponse(writer *bufio.Writer) *Response {
+	return &Response{writer: writer, code: 200}
+}
+
+// SetStatus sets the status code of the response, 200 unless set
+func (w *Response) SetStatus(code int) {
+	w.code = code
+	w.used = true
+}
+
+// SetHeader sets the header field name to value, replacing any value it already had
+func (w *Response) SetHeader(name string, value string) {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	w.used = true
+	for i := range w.header {
+		if w.header[i][0] == name {
+			w.header[i][1] = value
+			return
+		}
+	}
+	w.header = append(w.header, [2]string{name, value})
+}
+
+// Header returns the value of the header field name, or "" when it isn't set
+func (w *Response) Header(name string) string {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	for _, field := range w.header {
+		if field[0] == name {
+			return field[1]
+		}
+	}
+	return ""
+}
+
+// Write appends p to the response body
+func (w *Response) Write(p []byte) (int, error) {
+	w.used = true
+	return w.body.Write(p)
+}
+
+// WriteString appends s to the response body
+func (w *Response) WriteString(s string) (int, error) {
+	w.used = true
+	return w.body.WriteString(s)
+}
+
+// Reset discards everything set on the response so far
+func (w *Response) Reset() {
+	*w = Response{writer: w.writer, code: 200}
+}
+
+// Raw returns the connection's writer, for handlers that write their whole response themselves
+// instead of going through w
+func (w *Response) Raw() *bufio.Writer {
+	return w.writer
+}
+
+// Finish will write the response, unless the handler never used it
+func (w *Response) Finish() {
+	if w.used {
+		writeResponse(w.writer, StatusLine(w.code), w.header, w.body.Bytes())
+	}
+}
+
+// writeResponse will write a complete response with the status, like "200 OK", header fields, and body.
+// Content-Length is declared from body, except for the statuses that never have one.
+func writeResponse(writer *bufio.Writer, status string, fields [][2]string, body []byte) {
+	writer.WriteString("HTTP/1.1 " + status + "\r\n")
+	for _, field := range fields {
+		if name := textproto.CanonicalMIMEHeaderKey(field[0]); name != "Content-Length" {
+			writer.WriteString(name + ": " + field[1] + "\r\n")
+		}
+	}
+	if !strings.HasPrefix(status, "1") && !strings.HasPrefix(status, "204 ") && !strings.HasPrefix(status, "304 ") {
+		writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	writer.WriteString("\r\n")
+	writer.Write(body)
+}
+
+// headOnlyWriter passes a response through up to the end of its head and discards its body, so HEAD
+// requests get exactly the header the same GET request would
+type headOnlyWriter struct {
+	w     io.Writer
+	ended bool
+
+	// matched counts how much of the blank line ending the head the bytes written so far end with
+	matched int
+}
+
+// Write implements io.Writer
+func (h *headOnlyWriter) Write(p []byte) (int, error) {
+	if h.ended {
+		return len(p), nil
+	}
+
+	const end = "\r\n\r\n"
+	for i, c := range p {
+		switch {
+		case c == end[h.matched]:
+			h.matched++
+		case c == '\r':
+			h.matched = 1
+		default:
+			h.matched = 0
+		}
+
+		if h.matched == len(end) {
+			h.ended = true
+			if _, err := h.w.Write(p[:i+1]); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+
+	if _, err := h.w.Write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom implements io.ReaderFrom, a body is dropped without reading it since it is never sent
+func (h *headOnlyWriter) ReadFrom(src io.Reader) (int64, error) {
+	if h.ended {
+		return 0, nil
+	}
+	return io.Copy(struct{ io.Writer }{h}, src)
+}
+
+// fieldsWriter passes a response through, adding header fields to its head. A field the head has already
+// is left alone, apart from Vary, which lists values and is added as another field.
+type fieldsWriter struct {
+	w      io.Writer
+	fields [][2]string
+	head   []byte
+	ended  bool
+}
+
+// WithFields returns a writer passing the response written to it on to w with fields added to its head,
+// for fields a response needs whichever handler writes it. Only the first head written is changed.
+func WithFields(w io.Writer, fields ...[2]string) io.Writer {
+	return &fieldsWriter{w: w, fields: fields}
+}
+
+// Write implements io.Writer
+func (f *fieldsWriter) Write(p []byte) (int, error) {
+	if f.ended {
+		return f.w.Write(p)
+	}
+
+	// The head is held back until it is complete, the fields are added right before its end
+	f.head = append(f.head, p...)
+	end := bytes.Index(f.head, []byte("\r\n\r\n"))
+	if end < 0 {
+		return len(p), nil
+	}
+	f.ended = true
+
+	out := f.head
+	if _, header, ok := ParseResponseHead(f.head[:end+4]); ok {
+		var added []byte
+		for _, field := range f.fields {
+			if header.Get(field[0]) == "" || field[0] == "Vary" {
+				added = append(added, field[0]+": "+field[1]+"\r\n"...)
+			}
+		}
+		out = slices.Concat(f.head[:end+2], added, f.head[end+2:])
+	}
+	if _, err := f.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom implements io.ReaderFrom, a body written once the head is complete goes to the ReadFrom of
+// the writer underneath
+func (f *fieldsWriter) ReadFrom(src io.Reader) (int64, error) {
+	if f.ended {
+		return io.Copy(f.w, src)
+	}
+	return io.Copy(struct{ io.Writer }{f}, src)
+}
+
+// maxRecordedHead bounds how much of a response head the recorder keeps to inspect its framing
+const maxRecordedHead = 8 << 10
+
+// responseRecorder notes the status code, size, and head of the response written through it
+type responseRecorder struct {
+	w      io.Writer
+	status string
+	head   []byte
+	ended  bool
+	n      int64
+}
+
+// Write implements io.Writer
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if !r.ended && len(r.head) < maxRecordedHead {
+		start := max(len(r.head)-3, 0)
+		r.head = append(r.head, p[:min(len(p), maxRecordedHead-len(r.head))]...)
+		r.ended = bytes.Contains(r.head[start:], []byte("\r\n\r\n"))
+
+		// The status code follows "HTTP/1.1 " in the first bytes of the response
+		if r.status == "" && len(r.head) >= 12 {
+			r.status = string(r.head[9:12])
+		}
+	}
+
+	n, err := r.w.Write(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom. A body written once the head has been recorded is handed to the
+// connection's own ReadFrom, which sends a file with sendfile on Linux instead of copying it through user
+// space.
+func (r *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if !r.ended {
+		return io.Copy(struct{ io.Writer }{r}, src)
+	}
+	n, err := io.Copy(r.w, src)
+	r.n += n
+	return n, err
+}
+
+// reset forgets the previous response on the connection before the next one is written
+func (r *responseRecorder) reset() {
+	r.status, r.head, r.ended, r.n = "", r.head[:0], false, 0
+}
+
+// persistent reports whether the connection can carry another request after the response: the client must
+// be able to tell where its body ends without the connection closing, and it must not have asked to close
+func (r *responseRecorder) persistent(method string) bool {
+	if !r.ended {
+		return false
+	}
+
+	_, header, _ := ParseResponseHead(r.head)
+	for _, value := range header.Values("Connection") {
+		if strings.EqualFold(strings.TrimSpace(value), "close") {
+			return false
+		}
+	}
+
+	switch {
+	case method == "HEAD", r.status == "204", r.status == "304", strings.HasPrefix(r.status, "1"):
+		return true
+	case header.Get("Content-Length") != "":
+		return true
+	default:
+		return strings.EqualFold(header.Get("Transfer-Encoding"), "chunked")
+	}
+}
@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"slices"
+	"strings"
+)
+
+// Handler answers a request, returning the error to answer it with instead when it refuses it
+type Handler func(w *Response, r *Request) error
+
+// Middleware wraps a handler with behavior shared by many routes, calling next to continue the request
+type Middleware func(next Handler) Handler
+
+// route is a handler registered for a method and path pattern
+type route struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+// Router dispatches requests to the handlers registered for their method and path
+type Router struct {
+	routes     []route
+	middleware []Middleware
+}
+
+// NewRouter returns a router without any routes
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for requests with method, or any method when it is "*", and a path
+// matching pattern. A {name} segment of the pattern matches any one path segment, a {name...} last
+// segment matches the rest of the path, including nothing. Routes are tried in registration order.
+// A GET route also answers HEAD, and OPTIONS is answered for every path with explicit methods.
+func (rt *Router) Handle(method string, pattern string, handler Handler) {
+	rt.routes = append(rt.routes, route{method: method, segments: splitPath(strings.Trim(pattern, "/")), handler: handler})
+}
+
+// Use appends middleware wrapping the handler of every route, registered before or after. The first
+// middleware used is the outermost, so it sees the request first and the handler's error last.
+func (rt *Router) Use(middleware ...Middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+// Serve dispatches r to the first route matching it, it is the Handler of the whole router
+func (rt *Router) Serve(w *Response, r *Request) error {
+	// Segments are split before decoding, so an encoded slash stays part of its segment
+	segments := splitPath(r.RawPath)
+	for i, segment := range segments {
+		segments[i] = DecodedPath(segment)
+	}
+
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if !route.allows(r.Method) {
+			allowed = append(allowed, route.method)
+			if route.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+
+		handler := route.handler
+		for i := len(rt.middleware) - 1; i >= 0; i-- {
+			handler = rt.middleware[i](handler)
+		}
+
+		r.params = params
+		return handler(w, r)
+	}
+
+	if len(allowed) == 0 {
+		return ErrNotFound
+	}
+
+	// Overlapping routes may allow a method more than once, each is listed the first time it comes up
+	var methods []string
+	for _, method := range append(allowed, "OPTIONS") {
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	allow := strings.Join(methods, ", ")
+	if r.Method == "OPTIONS" {
+		w.SetStatus(204)
+		w.SetHeader("Allow", allow)
+		return nil
+	}
+	return ErrMethodNotAllowed.WithHeader("Allow", allow)
+}
+
+// allows reports whether the route handles requests with method
+func (rt route) allows(method string) bool {
+	return rt.method == "*" || rt.method == method || (rt.method == "GET" && method == "HEAD")
+}
+
+// match reports whether the path segments match the route's pattern, returning the segments its
+// parameters matched
+func (rt route) match(segments []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, pattern := range rt.segments {
+		name, isParam := strings.CutPrefix(pattern, "{")
+		name, _ = strings.CutSuffix(name, "}")
+		if isParam {
+			if params == nil {
+				params = map[string]string{}
+			}
+			if rest, ok := strings.CutSuffix(name, "..."); ok && i == len(rt.segments)-1 {
+				params[rest] = strings.Join(segments[min(i, len(segments)):], "/")
+				return params, true
+			}
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+		if isParam {
+			params[name] = segments[i]
+		} else if pattern != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(rt.segments)
+}
+
+// splitPath splits a path without its surrounding slashes into its segments, none for the root
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	route := func(name string) Handler {
+		return func(w *Response, r *Request) error {
+			w.WriteString(name + " " + r.Param("name") + r.Param("rest"))
+			return nil
+		}
+	}
+	router.Handle("GET", "/", route("root"))
+	router.Handle("GET", "/echo/{name}", route("echo"))
+	router.Handle("POST", "/echo/{name}", route("post"))
+	router.Handle("GET", "/files/{rest...}", route("files"))
+	router.Handle("*", "/any", route("any"))
+	router.Handle("GET", "/echo/fixed", route("shadowed"))
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		body   string
+		allow  string
+	}{
+		{method: "GET", target: "/", status: 200, body: "root "},
+		{method: "GET", target: "/echo/abc", status: 200, body: "echo abc"},
+		{method: "GET", target: "/echo/abc/", status: 200, body: "echo abc"},
+		{method: "POST", target: "/echo/abc", status: 200, body: "post abc"},
+		{method: "GET", target: "/echo/fixed", status: 200, body: "echo fixed"},
+		{method: "GET", target: "/echo/a%2Fb", status: 200, body: "echo a/b"},
+		{method: "GET", target: "/echo/a/b", status: 404},
+		{method: "GET", target: "/echo", status: 404},
+		{method: "GET", target: "/files", status: 200, body: "files "},
+		{method: "GET", target: "/files/a/b%20c", status: 200, body: "files a/b c"},
+		{method: "PATCH", target: "/any", status: 200, body: "any "},
+		{method: "HEAD", target: "/echo/abc", status: 200},
+		{method: "DELETE", target: "/echo/abc", status: 405, allow: "GET, HEAD, POST, OPTIONS"},
+		{method: "OPTIONS", target: "/echo/abc", status: 204, allow: "GET, HEAD, POST, OPTIONS"},
+		{method: "DELETE", target: "/echo/fixed", status: 405, allow: "GET, HEAD, POST, OPTIONS"},
+		{method: "GET", target: "/missing", status: 404},
+	}
+
+	server := New(Config{Handler: router.Serve})
+	for _, test := range tests {
+		raw := test.method + " " + test.target + " HTTP/1.1\r\nHost: x\r\n\r\n"
+		responses, _ := exchange(t, server, raw, test.method)
+		got := responses[0]
+		if got.status != test.status || got.body != test.body || got.header.Get("Allow") != test.allow {
+			t.Errorf("%s %s: got %d %q Allow %q, want %d %q Allow %q", test.method, test.target,
+				got.status, got.body, got.header.Get("Allow"), test.status, test.body, test.allow)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *Response, r *Request) error {
+				order = append(order, name+" in")
+				err := next(w, r)
+				order = append(order, name+" out")
+				return err
+			}
+		}
+	}
+
+	router := NewRouter()
+	router.Use(trace("outer"))
+	router.Handle("GET", "/", func(w *Response, r *Request) error {
+		order = append(order, "handler")
+		return errTeapot
+	})
+	// Middleware applies to routes registered before it too
+	router.Use(trace("inner"))
+
+	err := router.Serve(NewResponse(nil), &Request{Method: "GET"})
+	if !errors.Is(err, errTeapot) {
+		t.Errorf("got %v, want the handler's error", err)
+	}
+	if got := strings.Join(order, ", "); got != "outer in, inner in, handler, inner out, outer out" {
+		t.Errorf("got order %s", got)
+	}
+}
+
+// errTeapot is an error only the tests return
+var errTeapot = &Error{code: 418, reason: "I'm a teapot"}
@@ -0,0 +1,230 @@
+package httpserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpparse"
+)
+
+// ConnState is how far a connection is with its current request, reported to Config.ConnState
+type ConnState int
+
+const (
+	// StateIdle is a connection waiting for the client to start a request
+	StateIdle ConnState = iota
+	// StateReading is a connection whose request head is being read
+	StateReading
+	// StateWriting is a connection whose request is being answered
+	StateWriting
+)
+
+// String returns the name of the state, like idle
+func (s ConnState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateReading:
+		return "reading"
+	case StateWriting:
+		return "writing"
+	}
+	return "unknown"
+}
+
+// ResponseInfo describes the response to a request, for Config.RequestDone
+type ResponseInfo struct {
+	// Status is the status code sent, 0 when the response didn't get as far as one
+	Status int
+	// Bytes counts everything written for the response, its head included
+	Bytes int64
+	// Received counts the bytes read off the connection since the previous request was done, the
+	// request's head and body. Input read ahead of a pipelined request is counted with this one.
+	Received int64
+	// Started is when the request head had been read
+	Started time.Time
+}
+
+// Config is what a Server serves and how it treats its connections. Zero fields get the defaults.
+type Config struct {
+	// Addr is the address ListenAndServe listens on, like :4221
+	Addr string
+
+	// Handler answers every request, a Router's Serve most of the time
+	Handler Handler
+
+	// ReadTimeout bounds how long a client may take to send a request head, IdleTimeout how long a
+	// persistent connection may wait for the next request. The first request of a connection gets
+	// ReadTimeout, zero disables either.
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+
+	// WriteTimeout bounds how long a single write to the client may block, so a client that stops reading
+	// its response is disconnected instead of holding the connection forever. Zero disables it.
+	WriteTimeout time.Duration
+
+	// MaxHeaderBytes and MaxHeaderFields bound the request head and the trailer of chunked request
+	// bodies, 64 KiB and 128 fields unless set
+	MaxHeaderBytes  int
+	MaxHeaderFields int
+
+	// MaxBodySize bounds request bodies, zero leaves them unbounded. A larger declared length is refused
+	// with 413 before the handler runs, a chunked body fails reading with ErrEntityTooLarge once it passes it.
+	MaxBodySize int64
+
+	// ReadBufferSize and WriteBufferSize are the sizes of each connection's buffers, 4096 unless set
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Upgrades lists the protocols a client may ask to switch to with Upgrade, requests for any other
+	// protocol reach the handler without their Upgrade field
+	Upgrades []string
+
+	// WriteError answers a request that failed with an error, WriteError unless set
+	WriteError func(writer *bufio.Writer, err error)
+
+	// ConnState is called whenever a connection moves to another state
+	ConnState func(conn net.Conn, state ConnState)
+
+	// RequestDone is called once the response to a request has been flushed
+	RequestDone func(r *Request, info ResponseInfo)
+
+	// Logf reports requests that couldn't be read, they are dropped silently unless set
+	Logf func(format string, args ...any)
+}
+
+// Server serves HTTP/1.1 on the connections handed to it
+type Server struct {
+	config   Config
+	limits   httpparse.Limits
+	upgrades map[string]bool
+
+	// keepAlivesDisabled makes every connection close after its current response
+	keepAlivesDisabled atomic.Bool
+
+	// readers and writers recycle the buffers of closed connections and finished responses, they are all
+	// of the sizes in the config
+	readers sync.Pool
+	writers sync.Pool
+}
+
+// New returns a server for config
+func New(config Config) *Server {
+	if config.Handler == nil {
+		config.Handler = func(w *Response, r *Request) error { return ErrNotFound }
+	}
+	if config.MaxHeaderBytes <= 0 {
+		config.MaxHeaderBytes = 64 << 10
+	}
+	if config.MaxHeaderFields <= 0 {
+		config.MaxHeaderFields = 128
+	}
+	if config.ReadBufferSize <= 0 {
+		config.ReadBufferSize = 4096
+	}
+	if config.WriteBufferSize <= 0 {
+		config.WriteBufferSize = 4096
+	}
+	if config.WriteError == nil {
+		config.WriteError = WriteError
+	}
+
+	upgrades := map[string]bool{}
+	for _, protocol := range config.Upgrades {
+		upgrades[strings.ToLower(protocol)] = true
+	}
+
+	return &Server{
+		config:   config,
+		limits:   httpparse.Limits{MaxHeaderBytes: config.MaxHeaderBytes, MaxHeaderFields: config.MaxHeaderFields},
+		upgrades: upgrades,
+	}
+}
+
+// ListenAndServe listens on the TCP address Config.Addr and serves the connections accepted on it
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves every connection accepted on l in its own goroutine, until accepting fails
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves requests on conn until the client or a response asks to close it, or the client
+// stays idle for longer than the timeouts, and closes it then. Requests are read and answered one at a
+// time, each response flushed whole before the next request is read, so pipelined requests get their
+// responses in order.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer closeConnection(conn)
+
+	var out io.Writer = conn
+	if s.config.WriteTimeout > 0 {
+		out = &deadlineWriter{conn: conn, timeout: s.config.WriteTimeout}
+	}
+	c := &serverConn{server: s, rwc: conn, recorder: &responseRecorder{w: out}, received: &countingReader{r: conn}}
+	c.reader, c.writer = s.newReader(c.received), s.newWriter(c.recorder)
+	defer s.releaseReader(c.reader)
+	defer s.releaseWriter(c.writer)
+
+	for first := true; c.serveRequest(first); first = false {
+	}
+}
+
+// newReader returns a reader of Config.ReadBufferSize bytes reading from r, recycled when one is free
+func (s *Server) newReader(r io.Reader) *bufio.Reader {
+	if reader, ok := s.readers.Get().(*bufio.Reader); ok {
+		reader.Reset(r)
+		return reader
+	}
+	return bufio.NewReaderSize(r, s.config.ReadBufferSize)
+}
+
+// releaseReader hands a reader from newReader back for another connection
+func (s *Server) releaseReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	s.readers.Put(reader)
+}
+
+// newWriter returns a writer of Config.WriteBufferSize bytes writing to w, recycled when one is free
+func (s *Server) newWriter(w io.Writer) *bufio.Writer {
+	if writer, ok := s.writers.Get().(*bufio.Writer); ok {
+		writer.Reset(w)
+		return writer
+	}
+	return bufio.NewWriterSize(w, s.config.WriteBufferSize)
+}
+
+// releaseWriter hands a writer from newWriter back for another connection or response
+func (s *Server) releaseWriter(writer *bufio.Writer) {
+	writer.Reset(nil)
+	s.writers.Put(writer)
+}
+
+// SetKeepAlivesEnabled controls whether connections are kept open for further requests, disabling
+// them makes every connection close after its current response, like while shutting down
+func (s *Server) SetKeepAlivesEnabled(enabled bool) {
+	s.keepAlivesDisabled.Store(!enabled)
+}
+
+// logf reports a problem through Config.Logf
+func (s *Server) logf(format string, args ...any) {
+	if s.config.Logf != nil {
+		s.config.Logf(format, args...)
+	}
+}
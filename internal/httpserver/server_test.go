@@ -0,0 +1,936 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// response is what a test reads back for one request
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// exchange writes raw to a connection served by server and reads back a response for each of methods,
+// the methods of the requests in raw. It also reports whether the server closed the connection after them.
+func exchange(t *testing.T, server *Server, raw string, methods ...string) ([]response, bool) {
+	t.Helper()
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.ServeConn(conn)
+	go io.WriteString(client, raw)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+	var responses []response
+	for _, method := range methods {
+		resp, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("reading response %d to %q: %v", len(responses)+1, raw, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("reading body %d to %q: %v", len(responses)+1, raw, err)
+		}
+		responses = append(responses, response{status: resp.StatusCode, header: resp.Header, body: string(body)})
+	}
+
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := reader.ReadByte()
+	return responses, !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// echoHandler answers with the request's method, path, and body
+func echoHandler(w *Response, r *Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	w.SetHeader("Content-Type", "text/plain")
+	w.WriteString(r.Method + " " + r.Path + " " + string(body))
+	return nil
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		body   string
+	}{
+		{name: "simple", raw: "GET /a/b HTTP/1.1\r\nHost: x\r\n\r\n", status: 200, body: "GET a/b "},
+		{name: "decoded path", raw: "GET /a%20b/c%2Fd HTTP/1.1\r\nHost: x\r\n\r\n", status: 200, body: "GET a b/c/d "},
+		{name: "HTTP/1.0 without Host", raw: "GET / HTTP/1.0\r\n\r\n", status: 200, body: "GET  "},
+		{name: "later minor version", raw: "GET / HTTP/1.7\r\nHost: x\r\n\r\n", status: 200, body: "GET  "},
+		{name: "malformed request line", raw: "GET /\r\n\r\n", status: 400},
+		{name: "malformed field", raw: "GET / HTTP/1.1\r\nHost x\r\n\r\n", status: 400},
+		{name: "HTTP/2", raw: "GET / HTTP/2.0\r\nHost: x\r\n\r\n", status: 505},
+		{name: "missing Host", raw: "GET / HTTP/1.1\r\n\r\n", status: 400},
+		{name: "two Hosts", raw: "GET / HTTP/1.1\r\nHost: x\r\nHost: y\r\n\r\n", status: 400},
+		{name: "malformed escape", raw: "GET /a%zz HTTP/1.1\r\nHost: x\r\n\r\n", status: 400},
+		{name: "malformed query", raw: "GET /?a=%zz HTTP/1.1\r\nHost: x\r\n\r\n", status: 400},
+		{name: "head too large", raw: "GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 2048) + "\r\n\r\n", status: 431},
+		{name: "too many fields", raw: "GET / HTTP/1.1\r\nHost: x\r\n" + strings.Repeat("X-A: 1\r\n", 20) + "\r\n", status: 431},
+	}
+
+	server := New(Config{Handler: echoHandler, MaxHeaderBytes: 1024, MaxHeaderFields: 16})
+	for _, test := range tests {
+		responses, _ := exchange(t, server, test.raw, "GET")
+		if got := responses[0]; got.status != test.status || (test.body != "" && got.body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, got.status, got.body, test.status, test.body)
+		}
+	}
+}
+
+func TestHopByHopFields(t *testing.T) {
+	var header Header
+	server := New(Config{
+		Upgrades: []string{"websocket"},
+		Handler: func(w *Response, r *Request) error {
+			header = r.Header
+			w.SetStatus(204)
+			return nil
+		},
+	})
+
+	raw := "GET / HTTP/1.1\r\nHost: x\r\nConnection: X-Private, Host\r\nX-Private: 1\r\nKeep-Alive: 5\r\nTE: trailers\r\n\r\n"
+	exchange(t, server, raw, "GET")
+	if header.Get("X-Private") != "" || header.Get("Keep-Alive") != "" || header.Get("Te") != "" || header.Get("Host") != "x" {
+		t.Errorf("hop-by-hop fields kept: %v", header)
+	}
+
+	exchange(t, server, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: WebSocket\r\n\r\n", "GET")
+	if header.Get("Upgrade") != "WebSocket" {
+		t.Errorf("handled upgrade dropped: %v", header)
+	}
+	exchange(t, server, "GET / HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\n", "GET")
+	if header.Get("Upgrade") != "websocket" {
+		t.Errorf("handled upgrade named in Connection dropped: %v", header)
+	}
+	exchange(t, server, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: h2c\r\n\r\n", "GET")
+	if header.Get("Upgrade") != "" {
+		t.Errorf("unhandled upgrade kept: %v", header)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	header := Header{
+		"Connection":        {"close, X-Private"},
+		"X-Private":         {"1"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"websocket"},
+		"Content-Type":      {"text/plain"},
+		"Set-Cookie":        {"a=1", "b=2"},
+	}
+	got := EndToEnd(header)
+	want := Header{"Content-Type": {"text/plain"}, "Set-Cookie": {"a=1", "b=2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EndToEnd = %v, want %v", got, want)
+	}
+	if header.Get("X-Private") != "1" {
+		t.Errorf("EndToEnd changed its argument: %v", header)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		methods   []string
+		keepAlive string
+		closed    bool
+	}{
+		{
+			name:    "HTTP/1.1 is persistent",
+			raw:     "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n",
+			methods: []string{"GET", "GET"},
+		},
+		{
+			name:    "Connection: close",
+			raw:     "GET /a HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			methods: []string{"GET"},
+			closed:  true,
+		},
+		{
+			name:    "HTTP/1.0 closes",
+			raw:     "GET /a HTTP/1.0\r\n\r\n",
+			methods: []string{"GET"},
+			closed:  true,
+		},
+		{
+			name:      "HTTP/1.0 keep-alive",
+			raw:       "GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /b HTTP/1.0\r\nConnection: keep-alive\r\n\r\n",
+			methods:   []string{"GET", "GET"},
+			keepAlive: "keep-alive",
+		},
+		{
+			name:    "HEAD then GET",
+			raw:     "HEAD /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n",
+			methods: []string{"HEAD", "GET"},
+		},
+		{
+			name:    "unread body is drained",
+			raw:     "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhelloGET /b HTTP/1.1\r\nHost: x\r\n\r\n",
+			methods: []string{"POST", "GET"},
+		},
+		{
+			name:    "invalid Content-Length",
+			raw:     "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: five\r\n\r\n",
+			methods: []string{"POST"},
+			closed:  true,
+		},
+	}
+
+	server := New(Config{Handler: func(w *Response, r *Request) error {
+		w.WriteString(r.Path)
+		return nil
+	}})
+	for _, test := range tests {
+		responses, closed := exchange(t, server, test.raw, test.methods...)
+		if closed != test.closed {
+			t.Errorf("%s: closed %v, want %v", test.name, closed, test.closed)
+		}
+		if got := responses[0].header.Get("Connection"); got != test.keepAlive {
+			t.Errorf("%s: Connection %q, want %q", test.name, got, test.keepAlive)
+		}
+		if len(responses) == 2 && responses[1].body != "b" {
+			t.Errorf("%s: second response %q, want b", test.name, responses[1].body)
+		}
+		if test.methods[0] == "HEAD" && (responses[0].body != "" || responses[0].header.Get("Content-Length") != "1") {
+			t.Errorf("%s: HEAD response %q with Content-Length %q", test.name, responses[0].body, responses[0].header.Get("Content-Length"))
+		}
+	}
+}
+
+// pipeline writes the requests in raw to a connection served by server in a single write and returns
+// everything the server sent back until it closed the connection
+func pipeline(t *testing.T, server *Server, raw string) string {
+	t.Helper()
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.ServeConn(conn)
+	go io.WriteString(client, raw)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("reading the responses to %q: %v", raw, err)
+	}
+	return string(out)
+}
+
+// echoed is the response echoHandler answers with body
+func echoed(body string) string {
+	return "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestWithFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"one write", []string{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi"},
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nVary: Origin\r\nX-Added: 1\r\n\r\nhi"},
+		{"split head", []string{"HTTP/1.1 200 OK\r\nContent-Le", "ngth: 2\r\n\r", "\nh", "i"},
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nVary: Origin\r\nX-Added: 1\r\n\r\nhi"},
+		{"field already set", []string{"HTTP/1.1 204 No Content\r\nX-Added: 2\r\nVary: Accept-Encoding\r\n\r\n"},
+			"HTTP/1.1 204 No Content\r\nX-Added: 2\r\nVary: Accept-Encoding\r\nVary: Origin\r\n\r\n"},
+		{"only the first head", []string{"HTTP/1.1 200 OK\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n"},
+			"HTTP/1.1 200 OK\r\nVary: Origin\r\nX-Added: 1\r\n\r\nHTTP/1.1 200 OK\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		var out strings.Builder
+		w := WithFields(&out, [2]string{"Vary", "Origin"}, [2]string{"X-Added", "1"})
+		for _, p := range test.writes {
+			if n, err := io.WriteString(w, p); n != len(p) || err != nil {
+				t.Fatalf("%s: Write = %d, %v", test.name, n, err)
+			}
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: wrote %q, want %q", test.name, out.String(), test.want)
+		}
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "mixed methods and bodies",
+			raw: "GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+				"HEAD /b HTTP/1.1\r\nHost: x\r\n\r\n" +
+				"POST /c HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+				"POST /d HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+				"GET /e HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			want: echoed("GET a ") +
+				strings.TrimSuffix(echoed("HEAD b "), "HEAD b ") +
+				echoed("POST c hello") +
+				echoed("POST d abc") +
+				echoed("GET e "),
+		},
+		{
+			name: "body left unread",
+			raw: "POST /skip HTTP/1.1\r\nHost: x\r\nContent-Length: 11\r\n\r\nGET /x HTTP" +
+				"GET /b HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			want: "HTTP/1.1 204 No Content\r\n\r\n" + echoed("GET b "),
+		},
+		{
+			name: "refused request in between",
+			raw: "GET /missing HTTP/1.1\r\nHost: x\r\n\r\n" +
+				"GET /b HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			want: "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n" + echoed("GET b "),
+		},
+		{
+			name: "malformed request ends the pipeline",
+			raw: "GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+				"GET /b\r\n\r\n" +
+				"GET /c HTTP/1.1\r\nHost: x\r\n\r\n",
+			want: echoed("GET a ") + "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n",
+		},
+		{
+			name: "HTTP/1.0 keep-alive",
+			raw:  "GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /b HTTP/1.0\r\n\r\nGET /c HTTP/1.0\r\n\r\n",
+			want: strings.Replace(echoed("GET a "), "\r\n\r\n", "\r\nConnection: keep-alive\r\n\r\n", 1) + echoed("GET b "),
+		},
+	}
+
+	server := New(Config{Handler: func(w *Response, r *Request) error {
+		switch r.Path {
+		case "skip":
+			w.SetStatus(204)
+			return nil
+		case "missing":
+			return ErrNotFound
+		}
+		return echoHandler(w, r)
+	}})
+	for _, test := range tests {
+		if got := pipeline(t, server, test.raw); got != test.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPipeliningOrder(t *testing.T) {
+	// Enough requests that both the requests and the responses span many buffer fills
+	var raw strings.Builder
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		fmt.Fprintf(&raw, "POST /%d HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n%s", i, 100, strings.Repeat(strconv.Itoa(i%10), 100))
+	}
+	raw.WriteString("GET /last HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+
+	var want strings.Builder
+	for i := 0; i < requests; i++ {
+		want.WriteString(echoed(fmt.Sprintf("POST %d %s", i, strings.Repeat(strconv.Itoa(i%10), 100))))
+	}
+	want.WriteString(echoed("GET last "))
+
+	server := New(Config{Handler: echoHandler, ReadBufferSize: 512, WriteBufferSize: 512})
+	if got := pipeline(t, server, raw.String()); got != want.String() {
+		t.Errorf("pipelined responses out of order or mangled, got %d bytes, want %d", len(got), want.Len())
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		body   string
+	}{
+		{name: "Content-Length", raw: "POST /u HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello", status: 200, body: "POST u hello"},
+		{name: "chunked", raw: "POST /u HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", status: 200, body: "POST u hello world"},
+		{name: "chunked overrides length", raw: "POST /u HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", status: 200, body: "POST u abc"},
+		{name: "malformed chunk", raw: "POST /u HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", status: 400},
+		{name: "unsupported coding", raw: "POST /u HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n", status: 501},
+		{name: "unsupported expectation", raw: "POST /u HTTP/1.1\r\nHost: x\r\nExpect: magic\r\nContent-Length: 1\r\n\r\na", status: 417},
+		{name: "HTTP/1.0 Expect is ignored", raw: "POST /u HTTP/1.0\r\nExpect: magic\r\nContent-Length: 1\r\n\r\na", status: 200, body: "POST u a"},
+	}
+
+	server := New(Config{Handler: echoHandler})
+	for _, test := range tests {
+		responses, _ := exchange(t, server, test.raw, "POST")
+		if got := responses[0]; got.status != test.status || (test.body != "" && got.body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, got.status, got.body, test.status, test.body)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		closed bool
+	}{
+		{name: "within the limit", raw: "POST /u HTTP/1.1\r\nHost: x\r\nContent-Length: 8\r\n\r\n12345678", status: 200},
+		{name: "declared length over the limit", raw: "POST /u HTTP/1.1\r\nHost: x\r\nContent-Length: 9\r\n\r\n123456789", status: 413, closed: true},
+		{name: "chunked within the limit", raw: "POST /u HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n12345678\r\n0\r\n\r\n", status: 200},
+		{name: "chunked over the limit", raw: "POST /u HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n12345\r\n4\r\n6789\r\n0\r\n\r\n", status: 413, closed: true},
+	}
+
+	server := New(Config{Handler: echoHandler, MaxBodySize: 8})
+	for _, test := range tests {
+		responses, closed := exchange(t, server, test.raw, "POST")
+		if got := responses[0]; got.status != test.status || closed != test.closed {
+			t.Errorf("%s: got %d closed %v, want %d closed %v", test.name, got.status, closed, test.status, test.closed)
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	server := New(Config{WriteTimeout: 50 * time.Millisecond, Handler: func(w *Response, r *Request) error {
+		w.Raw().WriteString("HTTP/1.1 200 OK\r\nContent-Length: 1048576\r\n\r\n")
+		_, err := w.Raw().Write(make([]byte, 1<<20))
+		return err
+	}})
+
+	// The client sends a request and never reads the response
+	client, conn := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(done)
+	}()
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still blocked on a client that doesn't read")
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	server := New(Config{Handler: func(w *Response, r *Request) error {
+		if r.Path == "refuse" {
+			return ErrForbidden
+		}
+		return echoHandler(w, r)
+	}})
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.ServeConn(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+
+	// The body is only sent once the server asks for it, as a waiting client would
+	io.WriteString(client, "POST /u HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != 100 {
+		t.Fatalf("got %v %v, want 100 Continue", resp, err)
+	}
+	io.WriteString(client, "hi")
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != 200 || string(body) != "POST u hi" {
+		t.Errorf("got %d %q, want 200 with the body", resp.StatusCode, body)
+	}
+
+	// A refusal comes without a 100 Continue, and the connection closes since the client may still send the body
+	io.WriteString(client, "POST /refuse HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n")
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != 403 {
+		t.Fatalf("got %v %v, want 403", resp, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection left open after a refused Expect: %v", err)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	server := New(Config{Upgrades: []string{"echo"}, Handler: func(w *Response, r *Request) error {
+		w.Raw().WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		reader, writer := r.Hijack()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		writer.WriteString("echo: " + line)
+		writer.Flush()
+		return nil
+	}})
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.ServeConn(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+
+	// What the client sends right after the request is already buffered when the handler takes over
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nping\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != 101 {
+		t.Fatalf("got %v %v, want 101 Switching Protocols", resp, err)
+	}
+	if line, err := reader.ReadString('\n'); err != nil || line != "echo: ping\n" {
+		t.Errorf("got %q %v, want the echoed line", line, err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection left open after the hijacking handler returned: %v", err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+		status  int
+		header  map[string]string
+		body    string
+	}{
+		{
+			name: "status and header",
+			handler: func(w *Response, r *Request) error {
+				w.SetStatus(201)
+				w.SetHeader("location", "/a")
+				w.SetHeader("Location", "/b")
+				return nil
+			},
+			status: 201,
+			header: map[string]string{"Location": "/b", "Content-Length": "0"},
+		},
+		{
+			name: "no Content-Length for 204",
+			handler: func(w *Response, r *Request) error {
+				w.SetStatus(204)
+				return nil
+			},
+			status: 204,
+			header: map[string]string{"Content-Length": ""},
+		},
+		{
+			name: "error after writing",
+			handler: func(w *Response, r *Request) error {
+				w.WriteString("partial")
+				return ErrConflict.WithDetail("taken").WithHeader("Retry-After", "1")
+			},
+			status: 409,
+			header: map[string]string{"Retry-After": "1", "Content-Type": "text/plain"},
+			body:   "taken",
+		},
+		{
+			name: "reset",
+			handler: func(w *Response, r *Request) error {
+				w.SetHeader("X-A", "1")
+				w.WriteString("discarded")
+				w.Reset()
+				w.WriteString("kept")
+				return nil
+			},
+			status: 200,
+			header: map[string]string{"X-A": ""},
+			body:   "kept",
+		},
+		{
+			name: "raw response",
+			handler: func(w *Response, r *Request) error {
+				w.Raw().WriteString("HTTP/1.1 202 Accepted\r\nContent-Length: 3\r\n\r\nraw")
+				return nil
+			},
+			status: 202,
+			body:   "raw",
+		},
+	}
+
+	for _, test := range tests {
+		responses, _ := exchange(t, New(Config{Handler: test.handler}), "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET")
+		got := responses[0]
+		if got.status != test.status || got.body != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, got.status, got.body, test.status, test.body)
+		}
+		for name, value := range test.header {
+			if got.header.Get(name) != value {
+				t.Errorf("%s: %s %q, want %q", test.name, name, got.header.Get(name), value)
+			}
+		}
+	}
+}
+
+func TestConfigHooks(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	var done []ResponseInfo
+	var errs []error
+	server := New(Config{
+		Handler: echoHandler,
+		ConnState: func(conn net.Conn, state ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		},
+		RequestDone: func(r *Request, info ResponseInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			done = append(done, info)
+		},
+		WriteError: func(writer *bufio.Writer, err error) {
+			errs = append(errs, err)
+			WriteError(writer, err)
+		},
+	})
+
+	exchange(t, server, "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET / HTTP/9.0\r\n\r\n", "GET", "GET")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ConnState{StateIdle, StateReading, StateWriting, StateIdle, StateReading, StateWriting}
+	if len(states) != len(want) {
+		t.Fatalf("states %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("states %v, want %v", states, want)
+			break
+		}
+	}
+	// A request that couldn't be read never reaches RequestDone
+	if len(done) != 1 || done[0].Status != 200 || done[0].Bytes == 0 || done[0].Received == 0 || done[0].Started.IsZero() {
+		t.Errorf("RequestDone got %+v, want the one 200", done)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrVersionNotSupported) {
+		t.Errorf("WriteError got %v, want the 505", errs)
+	}
+}
+
+// pipeListener is a net.Listener accepting in-memory connections made with dial
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newPipeListener returns a listener without any connections yet
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial returns the client end of a new connection that the listener accepts
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+// Accept implements net.Listener
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements net.Listener
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// pipeAddr is the address of a pipeListener
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServe(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/echo/{msg}", func(w *Response, r *Request) error {
+		w.WriteString(r.Param("msg"))
+		return nil
+	})
+	server := New(Config{Handler: router.Serve})
+
+	l := newPipeListener()
+	served := make(chan error)
+	go func() { served <- server.Serve(l) }()
+
+	// Several clients are served at once
+	var wg sync.WaitGroup
+	for _, msg := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := l.dial()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go io.WriteString(conn, "GET /echo/"+msg+" HTTP/1.1\r\nHost: x\r\n\r\n")
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Errorf("%s: %v", msg, err)
+				return
+			}
+			if body, _ := io.ReadAll(resp.Body); string(body) != msg {
+				t.Errorf("got %q, want %q", body, msg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	l.Close()
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Serve returned %v, want net.ErrClosed", err)
+	}
+}
+
+func TestSetKeepAlivesEnabled(t *testing.T) {
+	server := New(Config{Handler: echoHandler})
+	server.SetKeepAlivesEnabled(false)
+	responses, closed := exchange(t, server, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n", "GET")
+	if !closed || responses[0].status != 200 {
+		t.Errorf("got %d, closed %v, want 200 and closed", responses[0].status, closed)
+	}
+
+	server.SetKeepAlivesEnabled(true)
+	if _, closed := exchange(t, server, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n", "GET"); closed {
+		t.Error("connection closed with keep-alives enabled again")
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	if err := New(Config{Addr: "256.0.0.1:0"}).ListenAndServe(); err == nil {
+		t.Error("ListenAndServe on an invalid address returned nil")
+	}
+}
+
+// fileHandler answers with the contents of the file at path through the writer's ReadFrom
+func fileHandler(path string) Handler {
+	return func(w *Response, r *Request) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		writer := w.Raw()
+		writer.WriteString("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.FormatInt(info.Size(), 10) + "\r\n\r\n")
+		_, err = writer.ReadFrom(io.LimitReader(file, info.Size()))
+		return err
+	}
+}
+
+func TestBodyReadFrom(t *testing.T) {
+	path := t.TempDir() + "/body"
+	body := strings.Repeat("0123456789abcdef", 1<<14)
+	os.WriteFile(path, []byte(body), 0644)
+	head := int64(len("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n"))
+
+	tests := []struct {
+		name         string
+		config       Config
+		raw          string
+		methods      []string
+		body         string
+		keepAlive    string
+		bytesWritten int64
+	}{
+		{"HTTP/1.1", Config{}, "GET / HTTP/1.1\r\nHost: x\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n", []string{"GET", "GET"}, body, "", head + int64(len(body))},
+		{"write timeout", Config{WriteTimeout: time.Second}, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", []string{"GET"}, body, "", head + int64(len(body))},
+		{"HEAD", Config{}, "HEAD / HTTP/1.1\r\nHost: x\r\n\r\n", []string{"HEAD"}, "", "", head},
+		{"HTTP/1.0 keep-alive", Config{}, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", []string{"GET"}, body, "keep-alive", head + int64(len("Connection: keep-alive\r\n")+len(body))},
+	}
+
+	for _, test := range tests {
+		var written []int64
+		var mu sync.Mutex
+		test.config.Handler = fileHandler(path)
+		test.config.RequestDone = func(r *Request, info ResponseInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			written = append(written, info.Bytes)
+		}
+
+		responses, _ := exchange(t, New(test.config), test.raw, test.methods...)
+		for i, resp := range responses {
+			if resp.body != test.body || resp.header.Get("Connection") != test.keepAlive {
+				t.Errorf("%s: response %d has %d bytes and Connection %q, want %d and %q", test.name, i+1, len(resp.body), resp.header.Get("Connection"), len(test.body), test.keepAlive)
+			}
+		}
+		mu.Lock()
+		if len(written) == 0 || written[0] != test.bytesWritten {
+			t.Errorf("%s: recorded %v bytes written, want %d", test.name, written, test.bytesWritten)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestHeadOnlyReadFrom(t *testing.T) {
+	var out strings.Builder
+	writer := &headOnlyWriter{w: &out}
+	writer.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"))
+
+	body := strings.NewReader("hello")
+	if n, err := writer.ReadFrom(body); n != 0 || err != nil || body.Len() != 5 {
+		t.Errorf("ReadFrom after the head read %d bytes (%d left) with %v, want none read", n, body.Len(), err)
+	}
+	if out.String() != "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n" {
+		t.Errorf("wrote %q, want only the head", out.String())
+	}
+}
+
+// BenchmarkLargeBody compares sending a file body through the writer's ReadFrom, which reaches sendfile
+// on a TCP connection, with copying it through a buffer into the writer
+func BenchmarkLargeBody(b *testing.B) {
+	const size = 64 << 20
+	path := b.TempDir() + "/body"
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	copied := func(w *Response, r *Request) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer := w.Raw()
+		writer.WriteString("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(size) + "\r\n\r\n")
+		_, err = io.CopyBuffer(struct{ io.Writer }{writer}, struct{ io.Reader }{file}, make([]byte, 4096))
+		return err
+	}
+
+	for _, bench := range []struct {
+		name    string
+		handler Handler
+	}{
+		{"ReadFrom", fileHandler(path)},
+		{"CopyBuffer", copied},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			go New(Config{Handler: bench.handler}).Serve(l)
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			reader := bufio.NewReaderSize(conn, 64<<10)
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n, err := io.Copy(io.Discard, resp.Body); n != size || err != nil {
+					b.Fatalf("read %d bytes of the body: %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkConnBuffers measures the allocations of serving a short request on a new connection, whose
+// read and write buffers are recycled from the connections before it
+func BenchmarkConnBuffers(b *testing.B) {
+	server := New(Config{Handler: echoHandler, ReadBufferSize: 64 << 10, WriteBufferSize: 64 << 10})
+	raw := "GET /buffers HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client, conn := net.Pipe()
+		go server.ServeConn(conn)
+		go io.WriteString(client, raw)
+		io.Copy(io.Discard, client)
+		client.Close()
+	}
+}
+
+// wrappedConn wraps a connection the way a recording does, handing it out through Unwrap
+type wrappedConn struct {
+	net.Conn
+}
+
+// Unwrap returns the wrapped connection
+func (c wrappedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func TestRequestTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	server := New(Config{Handler: func(w *Response, r *Request) error {
+		if r.TLS == nil {
+			w.WriteString("plaintext")
+			return nil
+		}
+		w.WriteString(tls.VersionName(r.TLS.Version) + " " + r.TLS.ServerName)
+		return nil
+	}})
+
+	if responses, _ := exchange(t, server, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET"); responses[0].body != "plaintext" {
+		t.Errorf("plaintext request got %q", responses[0].body)
+	}
+
+	// The TLS connection is found below the connection wrapping it
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.ServeConn(wrappedConn{tls.Server(conn, config)})
+	tlsClient := tls.Client(client, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	tlsClient.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(tlsClient, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(tlsClient), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "TLS 1.3 localhost" {
+		t.Errorf("TLS request got %q, want \"TLS 1.3 localhost\"", body)
+	}
+}
@@ -0,0 +1,3 @@
+// Package metrics keeps counters, gauges, and histograms in memory and writes them in the Prometheus
+// text exposition format, without depending on the Prometheus client libraries.
+package metrics
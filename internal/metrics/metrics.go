@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text format WriteText writes
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are upper bounds in seconds suited to request latencies, Prometheus's default buckets
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a value that only goes up
+type Counter struct {
+	value atomic.Int64
+}
+
+// Add increases the counter by n, which must not be negative
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Value returns the current count
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Add changes the gauge by n
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// CounterVec is a family of counters told apart by the value of one label
+type CounterVec struct {
+	label    string
+	counters sync.Map
+}
+
+// With returns the counter for the label value, creating it on first use
+func (v *CounterVec) With(value string) *Counter {
+	if c, ok := v.counters.Load(value); ok {
+		return c.(*Counter)
+	}
+	c, _ := v.counters.LoadOrStore(value, &Counter{})
+	return c.(*Counter)
+}
+
+// Histogram counts observations into buckets by their upper bounds
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Int64
+	count  atomic.Int64
+
+	// sum holds the bits of the float64 sum of every observation
+	sum atomic.Uint64
+}
+
+// Observe adds v to the histogram
+func (h *Histogram) Observe(v float64) {
+	if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// metric is one registered metric family, write appends its samples in the text format
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	write func(b *strings.Builder, name string)
+}
+
+// Registry holds the metrics exposed together, in the order they were registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns a registry without any metrics
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric family, names must be unique within the registry
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name == m.name {
+			panic("metrics: " + m.name + " registered twice")
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers and returns a counter
+func (r *Registry) Counter(name string, help string) *Counter {
+	c := &Counter{}
+	r.register(metric{name: name, help: help, kind: "counter", write: func(b *strings.Builder, name string) {
+		writeSample(b, name, "", c.Value())
+	}})
+	return c
+}
+
+// CounterFunc registers a counter whose value is read from value, for counts kept elsewhere
+func (r *Registry) CounterFunc(name string, help string, value func() int64) {
+	r.register(metric{name: name, help: help, kind: "counter", write: func(b *strings.Builder, name string) {
+		writeSample(b, name, "", value())
+	}})
+}
+
+// CounterVec registers and returns a family of counters labeled with label
+func (r *Registry) CounterVec(name string, help string, label string) *CounterVec {
+	v := &CounterVec{label: label}
+	r.register(metric{name: name, help: help, kind: "counter", write: func(b *strings.Builder, name string) {
+		var values []string
+		v.counters.Range(func(key, _ any) bool {
+			values = append(values, key.(string))
+			return true
+		})
+		slices.Sort(values)
+		for _, value := range values {
+			writeSample(b, name, labelPair(v.label, value), v.With(value).Value())
+		}
+	}})
+	return v
+}
+
+// Gauge registers and returns a gauge
+func (r *Registry) Gauge(name string, help string) *Gauge {
+	g := &Gauge{}
+	r.register(metric{name: name, help: help, kind: "gauge", write: func(b *strings.Builder, name string) {
+		writeSample(b, name, "", g.Value())
+	}})
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is read from value, for values kept elsewhere
+func (r *Registry) GaugeFunc(name string, help string, value func() int64) {
+	r.register(metric{name: name, help: help, kind: "gauge", write: func(b *strings.Builder, name string) {
+		writeSample(b, name, "", value())
+	}})
+}
+
+// Histogram registers and returns a histogram with the ascending upper bounds of its buckets
+func (r *Registry) Histogram(name string, help string, bounds []float64) *Histogram {
+	h := &Histogram{bounds: slices.Clone(bounds), counts: make([]atomic.Int64, len(bounds))}
+	r.register(metric{name: name, help: help, kind: "histogram", write: func(b *strings.Builder, name string) {
+		// Buckets are cumulative, each one counts every observation up to its bound
+		var cumulative int64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i].Load()
+			writeSample(b, name+"_bucket", labelPair("le", formatFloat(bound)), cumulative)
+		}
+		count := h.count.Load()
+		writeSample(b, name+"_bucket", labelPair("le", "+Inf"), count)
+		b.WriteString(name + "_sum " + formatFloat(math.Float64frombits(h.sum.Load())) + "\n")
+		writeSample(b, name+"_count", "", count)
+	}})
+	return h
+}
+
+// WriteText will write every metric of the registry to w in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		b.WriteString("# HELP " + m.name + " " + escapeHelp(m.help) + "\n")
+		b.WriteString("# TYPE " + m.name + " " + m.kind + "\n")
+		m.write(&b, m.name)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSample appends a sample line, labels is empty or the text between the braces
+func writeSample(b *strings.Builder, name string, labels string, value int64) {
+	b.WriteString(name)
+	if labels != "" {
+		b.WriteString("{" + labels + "}")
+	}
+	b.WriteString(" " + strconv.FormatInt(value, 10) + "\n")
+}
+
+// labelPair returns the label with its value quoted and escaped
+func labelPair(name string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+// escapeHelp escapes the backslashes and line breaks of help text
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// formatFloat formats v the shortest way that parses back to it
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
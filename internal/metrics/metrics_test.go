@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.CounterVec("requests_total", "Requests by status class", "code")
+	inFlight := r.Gauge("in_flight", "Requests being handled")
+	bytes := r.Counter("bytes_total", "Bytes sent,\nin total")
+	r.GaugeFunc("connections", `Open connections, a \ path`, func() int64 { return 7 })
+	latency := r.Histogram("latency_seconds", "Request latency", []float64{0.1, 1})
+
+	requests.With("2xx").Add(3)
+	requests.With("4xx").Inc()
+	requests.With(`we"ird`).Inc()
+	inFlight.Add(2)
+	inFlight.Add(-1)
+	bytes.Add(1024)
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests by status class
+# TYPE requests_total counter
+requests_total{code="2xx"} 3
+requests_total{code="4xx"} 1
+requests_total{code="we\"ird"} 1
+# HELP in_flight Requests being handled
+# TYPE in_flight gauge
+in_flight 1
+# HELP bytes_total Bytes sent,\nin total
+# TYPE bytes_total counter
+bytes_total 1024
+# HELP connections Open connections, a \\ path
+# TYPE connections gauge
+connections 7
+# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 3.65
+latency_seconds_count 4
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("c", "")
+	vec := r.CounterVec("v", "", "l")
+	histogram := r.Histogram("h", "", DefaultBuckets)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.Inc()
+				vec.With("x").Inc()
+				histogram.Observe(0.25)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counter.Value() != 8000 || vec.With("x").Value() != 8000 || histogram.count.Load() != 8000 {
+		t.Errorf("lost updates: counter %d, vec %d, histogram %d", counter.Value(), vec.With("x").Value(), histogram.count.Load())
+	}
+
+	var out strings.Builder
+	r.WriteText(&out)
+	if !strings.Contains(out.String(), "h_sum 2000\n") || !strings.Contains(out.String(), `h_bucket{le="0.25"} 8000`) {
+		t.Errorf("histogram written as\n%s", out.String())
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	r := NewRegistry()
+	r.Counter("c", "")
+	r.Gauge("c", "")
+}
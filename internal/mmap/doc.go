@@ -0,0 +1,7 @@
+// Package mmap maps files read-only into memory where the platform supports it.
+package mmap
+
+import "errors"
+
+// ErrUnsupported is returned when a file can't be mapped and should be read normally instead
+var ErrUnsupported = errors.New("mmap: not supported")
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package mmap
+
+import "os"
+
+// Map always fails with ErrUnsupported on this platform
+func Map(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, ErrUnsupported
+}
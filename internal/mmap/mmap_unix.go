@@ -0,0 +1,24 @@
+//go:build unix
+
+package mmap
+
+import (
+	"os"
+	"syscall"
+)
+
+// Map maps the first size bytes of f read-only into memory. The returned unmap function must be
+// called once the data is no longer used. Truncating the file while it is mapped makes reads fault,
+// so callers should only map files that aren't being rewritten in place.
+func Map(f *os.File, size int64) ([]byte, func() error, error) {
+	if size == 0 || int64(int(size)) != size {
+		return nil, nil, ErrUnsupported
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
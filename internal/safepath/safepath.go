@@ -0,0 +1,64 @@
+// Package safepath resolves names taken from request targets to paths below a root directory,
+// refusing every name that could reach outside of it.
+package safepath
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalid is returned for a name that can't name a file, such as one containing a NUL byte
+	ErrInvalid = errors.New("safepath: invalid name")
+	// ErrTraversal is returned for a name with a dot-dot segment, plain or percent-encoded, or one that
+	// would otherwise resolve outside of the root
+	ErrTraversal = errors.New("safepath: name escapes the root")
+)
+
+// CheckEscapes inspects the still percent-encoded, slash separated name for escapes that would change
+// its structure once decoded: encoded slashes, backslashes, and NUL bytes are ErrInvalid, and encoded
+// dot segments are ErrTraversal. Plain dot-dot segments are left for Resolve to refuse.
+func CheckEscapes(raw string) error {
+	if !strings.Contains(raw, "%") {
+		return nil
+	}
+
+	for _, segment := range strings.Split(raw, "/") {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return ErrInvalid
+		}
+		if strings.ContainsAny(decoded, "/\\\x00") {
+			return ErrInvalid
+		}
+		if decoded != segment && (decoded == "." || decoded == "..") {
+			return ErrTraversal
+		}
+	}
+	return nil
+}
+
+// Resolve returns the path that the decoded, slash separated name refers to below root. Names with
+// a NUL byte or a backslash are ErrInvalid, and names with a dot-dot segment are ErrTraversal even when
+// they would stay below root, since no client has a reason to send one.
+func Resolve(root string, name string) (string, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return "", ErrInvalid
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", ErrTraversal
+		}
+	}
+
+	root = filepath.Clean(root)
+	path := filepath.Join(root, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", ErrTraversal
+	}
+	return path, nil
+}
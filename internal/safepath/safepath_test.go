@@ -0,0 +1,75 @@
+package safepath
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckEscapes(t *testing.T) {
+	tests := []struct {
+		raw string
+		err error
+	}{
+		{"a.txt", nil},
+		{"sub/a.txt", nil},
+		{"a%20b.txt", nil},
+		{"caf%C3%A9.txt", nil},
+		{"../etc/passwd", nil},
+		{"%2e%2e/etc/passwd", ErrTraversal},
+		{"%2E%2E/etc/passwd", ErrTraversal},
+		{".%2e/etc/passwd", ErrTraversal},
+		{"sub/%2e", ErrTraversal},
+		{"..%2fetc%2fpasswd", ErrInvalid},
+		{"..%5cetc", ErrInvalid},
+		{"a.txt%00.png", ErrInvalid},
+		{"bad%zzescape", ErrInvalid},
+		{"trailing%2", ErrInvalid},
+	}
+
+	for _, test := range tests {
+		if err := CheckEscapes(test.raw); !errors.Is(err, test.err) {
+			t.Errorf("CheckEscapes(%q) = %v; want %v", test.raw, err, test.err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root := filepath.FromSlash("/srv/files")
+
+	tests := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{"", root, nil},
+		{"a.txt", filepath.Join(root, "a.txt"), nil},
+		{"sub/a.txt", filepath.Join(root, "sub", "a.txt"), nil},
+		{"sub//a.txt", filepath.Join(root, "sub", "a.txt"), nil},
+		{"./a.txt", filepath.Join(root, "a.txt"), nil},
+		{"/a.txt", filepath.Join(root, "a.txt"), nil},
+		{"..", "", ErrTraversal},
+		{"../etc/passwd", "", ErrTraversal},
+		{"../../etc/passwd", "", ErrTraversal},
+		{"sub/../../etc/passwd", "", ErrTraversal},
+		{"sub/../a.txt", "", ErrTraversal},
+		{"..\\etc\\passwd", "", ErrInvalid},
+		{"a.txt\x00.png", "", ErrInvalid},
+	}
+
+	for _, test := range tests {
+		path, err := Resolve(root, test.name)
+		if path != test.path || !errors.Is(err, test.err) {
+			t.Errorf("Resolve(%q) = %q, %v; want %q, %v", test.name, path, err, test.path, test.err)
+		}
+	}
+}
+
+func TestResolveRootWithTrailingSlash(t *testing.T) {
+	root := filepath.FromSlash("/srv/files/")
+
+	path, err := Resolve(root, "a.txt")
+	if want := filepath.Join(root, "a.txt"); path != want || err != nil {
+		t.Errorf("Resolve(%q, %q) = %q, %v; want %q, nil", root, "a.txt", path, err, want)
+	}
+}
@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CloseCode is the status code of a close frame, telling why the connection is closed
+type CloseCode uint16
+
+// The close codes of RFC 6455 section 7.4.1 a server sends or receives
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	CloseProtocolError   CloseCode = 1002
+	CloseUnsupportedData CloseCode = 1003
+	// CloseNoStatus stands for a close frame without a code, it is never sent
+	CloseNoStatus        CloseCode = 1005
+	CloseInvalidPayload  CloseCode = 1007
+	ClosePolicyViolation CloseCode = 1008
+	CloseTooLarge        CloseCode = 1009
+	CloseInternalError   CloseCode = 1011
+)
+
+// validCloseCode reports whether a client may send code, the codes reserved for the protocol itself and
+// those never defined can't appear in a close frame
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// CloseError is returned by ReadMessage once the client closed the connection
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+// Error implements error
+func (e *CloseError) Error() string {
+	if e.Reason != "" {
+		return "websocket: closed with " + strconv.Itoa(int(e.Code)) + ": " + e.Reason
+	}
+	return "websocket: closed with " + strconv.Itoa(int(e.Code))
+}
+
+// Conn is the server end of a WebSocket connection, over the buffers of the connection it was upgraded from
+type Conn struct {
+	r          *bufio.Reader
+	w          *bufio.Writer
+	maxMessage int64
+	closeSent  bool
+}
+
+// NewConn returns a connection reading frames from r and writing them to w, accepting messages of up to
+// maxMessage bytes
+func NewConn(r *bufio.Reader, w *bufio.Writer, maxMessage int64) *Conn {
+	return &Conn{r: r, w: w, maxMessage: maxMessage}
+}
+
+// ReadMessage reads the next text or binary message, joining its fragments. Pings that arrive meanwhile are
+// answered with pongs and pongs are skipped. A close frame from the client is answered with one and returned
+// as a *CloseError, a client breaking the protocol is sent the close frame matching its error, which is
+// returned as well.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var op Opcode
+	var message []byte
+	started := false
+	for {
+		frame, err := ReadFrame(c.r, c.maxMessage-int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch frame.Opcode {
+		case OpPing:
+			if err := c.write(Frame{Fin: true, Opcode: OpPong, Payload: frame.Payload}); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			closeErr, err := parseClose(frame.Payload)
+			if err != nil {
+				return 0, nil, c.fail(err)
+			}
+			// The client's code is echoed back, a close frame without one is answered without one
+			if closeErr.Code == CloseNoStatus {
+				c.closeWith(nil)
+			} else {
+				c.Close(closeErr.Code, "")
+			}
+			return 0, nil, closeErr
+		case OpContinuation:
+			if !started {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+		default:
+			if started {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+			op, started = frame.Opcode, true
+		}
+
+		message = append(message, frame.Payload...)
+		if frame.Fin {
+			if op == OpText && !utf8.Valid(message) {
+				return 0, nil, c.fail(ErrInvalidUTF8)
+			}
+			return op, message, nil
+		}
+	}
+}
+
+// parseClose parses the payload of a close frame into its code and reason
+func parseClose(payload []byte) (*CloseError, error) {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatus}, nil
+	case len(payload) == 1:
+		return nil, ErrProtocol
+	}
+
+	code := CloseCode(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, ErrProtocol
+	}
+	if !utf8.Valid(payload[2:]) {
+		return nil, ErrInvalidUTF8
+	}
+	return &CloseError{Code: code, Reason: string(payload[2:])}, nil
+}
+
+// fail will send the close frame matching err, when it is an error of the client, and returns err
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrProtocol), errors.Is(err, ErrUnmasked):
+		c.Close(CloseProtocolError, "")
+	case errors.Is(err, ErrTooLarge):
+		c.Close(CloseTooLarge, "")
+	case errors.Is(err, ErrInvalidUTF8):
+		c.Close(CloseInvalidPayload, "")
+	}
+	return err
+}
+
+// WriteMessage sends data as a single text or binary message
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	return c.write(Frame{Fin: true, Opcode: op, Payload: data})
+}
+
+// Close sends a close frame with code and reason, which must fit in a control frame. Only the first close
+// frame is sent, nothing may be sent after it.
+func (c *Conn) Close(code CloseCode, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.closeWith(append(payload, reason[:min(len(reason), maxControlPayload-2)]...))
+}
+
+// closeWith sends a close frame with payload, unless one was sent already
+func (c *Conn) closeWith(payload []byte) error {
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	return c.write(Frame{Fin: true, Opcode: OpClose, Payload: payload})
+}
+
+// write sends frame and flushes it to the client
+func (c *Conn) write(frame Frame) error {
+	if err := WriteFrame(c.w, frame); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// serverFrames reads back the frames the server wrote
+func serverFrames(t *testing.T, raw []byte) []Frame {
+	t.Helper()
+	var frames []Frame
+	for len(raw) > 0 {
+		// Server frames are unmasked and the tests only send short ones
+		length := int(raw[1] & 0x7f)
+		frames = append(frames, Frame{Fin: raw[0]&0x80 != 0, Opcode: Opcode(raw[0] & 0x0f), Payload: raw[2 : 2+length]})
+		raw = raw[2+length:]
+	}
+	return frames
+}
+
+func TestConn(t *testing.T) {
+	closePayload := func(code CloseCode, reason string) []byte {
+		return append([]byte{byte(code >> 8), byte(code)}, reason...)
+	}
+
+	tests := []struct {
+		name     string
+		input    [][]byte
+		op       Opcode
+		message  string
+		err      error
+		closeErr *CloseError
+		sent     []Frame
+	}{
+		{
+			name:    "single frame",
+			input:   [][]byte{clientFrame(true, OpText, []byte("hello"))},
+			op:      OpText,
+			message: "hello",
+		},
+		{
+			name: "fragments with a ping between them",
+			input: [][]byte{
+				clientFrame(false, OpBinary, []byte("ab")),
+				clientFrame(true, OpPing, []byte("p")),
+				clientFrame(false, OpContinuation, []byte("cd")),
+				clientFrame(true, OpContinuation, []byte("e")),
+			},
+			op:      OpBinary,
+			message: "abcde",
+			sent:    []Frame{{true, OpPong, []byte("p")}},
+		},
+		{
+			name:     "close is echoed",
+			input:    [][]byte{clientFrame(true, OpClose, closePayload(CloseGoingAway, "bye"))},
+			closeErr: &CloseError{Code: CloseGoingAway, Reason: "bye"},
+			sent:     []Frame{{true, OpClose, closePayload(CloseGoingAway, "")}},
+		},
+		{
+			name:     "close without a code",
+			input:    [][]byte{clientFrame(true, OpClose, nil)},
+			closeErr: &CloseError{Code: CloseNoStatus},
+			sent:     []Frame{{true, OpClose, []byte{}}},
+		},
+		{
+			name:  "reserved close code",
+			input: [][]byte{clientFrame(true, OpClose, closePayload(1005, ""))},
+			err:   ErrProtocol,
+			sent:  []Frame{{true, OpClose, closePayload(CloseProtocolError, "")}},
+		},
+		{
+			name:  "continuation without a message",
+			input: [][]byte{clientFrame(true, OpContinuation, []byte("x"))},
+			err:   ErrProtocol,
+			sent:  []Frame{{true, OpClose, closePayload(CloseProtocolError, "")}},
+		},
+		{
+			name:  "new message inside a fragmented one",
+			input: [][]byte{clientFrame(false, OpText, []byte("a")), clientFrame(true, OpText, []byte("b"))},
+			err:   ErrProtocol,
+			sent:  []Frame{{true, OpClose, closePayload(CloseProtocolError, "")}},
+		},
+		{
+			name:  "invalid UTF-8",
+			input: [][]byte{clientFrame(true, OpText, []byte{0xff, 0xfe})},
+			err:   ErrInvalidUTF8,
+			sent:  []Frame{{true, OpClose, closePayload(CloseInvalidPayload, "")}},
+		},
+		{
+			name:  "message too large across fragments",
+			input: [][]byte{clientFrame(false, OpText, bytes.Repeat([]byte("a"), 10)), clientFrame(true, OpContinuation, bytes.Repeat([]byte("a"), 10))},
+			err:   ErrTooLarge,
+			sent:  []Frame{{true, OpClose, closePayload(CloseTooLarge, "")}},
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		c := NewConn(bufio.NewReader(bytes.NewReader(bytes.Join(test.input, nil))), bufio.NewWriter(&out), 16)
+		op, message, err := c.ReadMessage()
+
+		var closeErr *CloseError
+		switch {
+		case test.closeErr != nil:
+			if !errors.As(err, &closeErr) || *closeErr != *test.closeErr {
+				t.Errorf("%s: error %v, want %v", test.name, err, test.closeErr)
+			}
+		case !errors.Is(err, test.err):
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		case err == nil && (op != test.op || string(message) != test.message):
+			t.Errorf("%s: got %v %q, want %v %q", test.name, op, message, test.op, test.message)
+		}
+
+		sent := serverFrames(t, out.Bytes())
+		if len(sent) != len(test.sent) {
+			t.Errorf("%s: sent %v, want %v", test.name, sent, test.sent)
+			continue
+		}
+		for i := range sent {
+			if sent[i].Fin != test.sent[i].Fin || sent[i].Opcode != test.sent[i].Opcode || !bytes.Equal(sent[i].Payload, test.sent[i].Payload) {
+				t.Errorf("%s: sent %v, want %v", test.name, sent, test.sent)
+			}
+		}
+	}
+}
+
+func TestConnCloseOnce(t *testing.T) {
+	var out bytes.Buffer
+	c := NewConn(bufio.NewReader(bytes.NewReader(nil)), bufio.NewWriter(&out), 16)
+	c.WriteMessage(OpText, []byte("hi"))
+	c.Close(CloseNormal, "done")
+	c.Close(CloseGoingAway, "again")
+
+	sent := serverFrames(t, out.Bytes())
+	if len(sent) != 2 || string(sent[0].Payload) != "hi" || sent[1].Opcode != OpClose || string(sent[1].Payload[2:]) != "done" {
+		t.Errorf("sent %v", sent)
+	}
+}
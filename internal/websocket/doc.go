@@ -0,0 +1,17 @@
+// Package websocket implements the server side of the WebSocket protocol of RFC 6455: the opening
+// handshake's accept key, reading and writing frames, and a connection that joins fragmented messages and
+// answers pings and close frames. The HTTP upgrade itself is left to the server.
+package websocket
+
+import "errors"
+
+var (
+	// ErrProtocol is returned for a frame that breaks the framing rules, like a fragmented control frame
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrUnmasked is returned for a frame from a client without a masking key
+	ErrUnmasked = errors.New("websocket: client frame is not masked")
+	// ErrTooLarge is returned once a message exceeds the size a connection accepts
+	ErrTooLarge = errors.New("websocket: message too large")
+	// ErrInvalidUTF8 is returned for a text message or close reason that isn't valid UTF-8
+	ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text")
+)
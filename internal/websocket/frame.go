@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Opcode is the type of a frame
+type Opcode byte
+
+// The frame types of RFC 6455 section 5.2
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+// isControl reports whether op is a control frame, which can't be fragmented
+func (op Opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// known reports whether op is defined, the rest are reserved for extensions
+func (op Opcode) known() bool {
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	}
+	return false
+}
+
+// maxControlPayload bounds the payload of control frames
+const maxControlPayload = 125
+
+// Frame is one frame of a message, a message that isn't fragmented is a single frame with Fin set
+type Frame struct {
+	Fin     bool
+	Opcode  Opcode
+	Payload []byte
+}
+
+// ReadFrame reads a frame sent by a client and unmasks its payload. Client frames must be masked and
+// must not set the reserved bits, since no extension is negotiated, and control frames must be short and
+// unfragmented. A data frame with a payload longer than maxPayload is refused with ErrTooLarge before
+// its payload is read.
+func ReadFrame(r io.Reader, maxPayload int64) (Frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return Frame{}, err
+	}
+
+	frame := Frame{Fin: head[0]&0x80 != 0, Opcode: Opcode(head[0] & 0x0f)}
+	if head[0]&0x70 != 0 || !frame.Opcode.known() {
+		return Frame{}, ErrProtocol
+	}
+	if head[1]&0x80 == 0 {
+		return Frame{}, ErrUnmasked
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return Frame{}, unexpected(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return Frame{}, unexpected(err)
+		}
+		if ext[0]&0x80 != 0 {
+			return Frame{}, ErrProtocol
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if frame.Opcode.isControl() && (length > maxControlPayload || !frame.Fin) {
+		return Frame{}, ErrProtocol
+	}
+	if !frame.Opcode.isControl() && length > maxPayload {
+		return Frame{}, ErrTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return Frame{}, unexpected(err)
+	}
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return Frame{}, unexpected(err)
+	}
+	for i := range frame.Payload {
+		frame.Payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// unexpected reports the input ending inside a frame as io.ErrUnexpectedEOF, only ending between frames
+// is io.EOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteFrame writes frame as a server sends it, unmasked and with the shortest length encoding
+func WriteFrame(w io.Writer, frame Frame) error {
+	var head [10]byte
+	head[0] = byte(frame.Opcode)
+	if frame.Fin {
+		head[0] |= 0x80
+	}
+
+	n := 2
+	switch length := len(frame.Payload); {
+	case length <= 125:
+		head[1] = byte(length)
+	case length <= 0xffff:
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+		n = 4
+	default:
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+		n = 10
+	}
+
+	if _, err := w.Write(head[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame.Payload)
+	return err
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// clientFrame returns frame as a client sends it, masked with a fixed key
+func clientFrame(fin bool, op Opcode, payload []byte) []byte {
+	var buf bytes.Buffer
+	WriteFrame(&buf, Frame{Fin: fin, Opcode: op, Payload: payload})
+	raw := buf.Bytes()
+
+	// The mask bit is set and the key follows the length
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	head := len(raw) - len(payload)
+	out := append([]byte{}, raw[:head]...)
+	out[1] |= 0x80
+	out = append(out, mask...)
+	for i, b := range payload {
+		out = append(out, b^mask[i%4])
+	}
+	return out
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455 section 1.3
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey = %q", got)
+	}
+
+	tests := map[string]bool{
+		"dGhlIHNhbXBsZSBub25jZQ==": true,
+		"":                         false,
+		"c2hvcnQ=":                 false,
+		"not base64!":              false,
+	}
+	for key, want := range tests {
+		if got := ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	huge := bytes.Repeat([]byte("y"), 70000)
+	tests := []struct {
+		name  string
+		input []byte
+		max   int64
+		want  Frame
+		err   error
+	}{
+		{"text", clientFrame(true, OpText, []byte("hello")), 1 << 20, Frame{true, OpText, []byte("hello")}, nil},
+		{"empty", clientFrame(true, OpBinary, nil), 1 << 20, Frame{true, OpBinary, []byte{}}, nil},
+		{"16 bit length", clientFrame(false, OpBinary, long), 1 << 20, Frame{false, OpBinary, long}, nil},
+		{"64 bit length", clientFrame(true, OpBinary, huge), 1 << 20, Frame{true, OpBinary, huge}, nil},
+		{"too large", clientFrame(true, OpBinary, long), 100, Frame{}, ErrTooLarge},
+		{"unmasked", []byte{0x81, 0x01, 'a'}, 1 << 20, Frame{}, ErrUnmasked},
+		{"reserved bit", append([]byte{0xc1}, clientFrame(true, OpText, []byte("a"))[1:]...), 1 << 20, Frame{}, ErrProtocol},
+		{"reserved opcode", clientFrame(true, 0x3, nil), 1 << 20, Frame{}, ErrProtocol},
+		{"fragmented control", clientFrame(false, OpPing, nil), 1 << 20, Frame{}, ErrProtocol},
+		{"long control", clientFrame(true, OpPing, long), 1 << 20, Frame{}, ErrProtocol},
+		{"no input", nil, 1 << 20, Frame{}, io.EOF},
+		{"cut short", clientFrame(true, OpText, []byte("hello"))[:8], 1 << 20, Frame{}, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		got, err := ReadFrame(bytes.NewReader(test.input), test.max)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v %v %d bytes, want %v %v %d bytes", test.name, got.Fin, got.Opcode, len(got.Payload), test.want.Fin, test.want.Opcode, len(test.want.Payload))
+		}
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	tests := []struct {
+		frame Frame
+		head  []byte
+	}{
+		{Frame{true, OpText, []byte("hi")}, []byte{0x81, 2}},
+		{Frame{false, OpBinary, make([]byte, 126)}, []byte{0x02, 126, 0, 126}},
+		{Frame{true, OpBinary, make([]byte, 65536)}, []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+		{Frame{true, OpClose, nil}, []byte{0x88, 0}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, test.frame); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.Bytes(); !bytes.Equal(got[:len(test.head)], test.head) || len(got) != len(test.head)+len(test.frame.Payload) {
+			t.Errorf("WriteFrame(%v, %d bytes) starts with %x, want %x", test.frame.Opcode, len(test.frame.Payload), got[:min(len(got), 10)], test.head)
+		}
+	}
+}
+
+func TestReadFrameLeavesNextFrame(t *testing.T) {
+	input := append(clientFrame(true, OpText, []byte("a")), clientFrame(true, OpText, []byte("b"))...)
+	r := bufio.NewReader(bytes.NewReader(input))
+	for _, want := range []string{"a", "b"} {
+		frame, err := ReadFrame(r, 10)
+		if err != nil || string(frame.Payload) != want {
+			t.Errorf("ReadFrame = %q, %v, want %q", frame.Payload, err, want)
+		}
+	}
+	if _, err := ReadFrame(r, 10); err != io.EOF {
+		t.Errorf("ReadFrame after the last frame: %v, want io.EOF", err)
+	}
+}
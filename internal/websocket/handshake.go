@@ -0,0 +1,21 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+)
+
+// acceptGUID is appended to the client's key before hashing it, as RFC 6455 section 1.3 specifies
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// AcceptKey returns the Sec-WebSocket-Accept value answering a client's Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ValidKey reports whether key is a Sec-WebSocket-Key, 16 bytes encoded in base64
+func ValidKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}